@@ -28,3 +28,21 @@ func Middleware(cmds ...string) wish.Middleware {
 		}
 	}
 }
+
+// GroupMiddleware is like Middleware, with the commands allowed to the members
+// of each group, as given by wish.UserFromContext, e.g. with a wish.UserStore.
+// Users in several groups are allowed the commands of all of them, and users
+// not resolved by a wish.UserStore are not allowed any command.
+func GroupMiddleware(groups map[string][]string) wish.Middleware {
+	return func(sh ssh.Handler) ssh.Handler {
+		return func(s ssh.Session) {
+			var cmds []string
+			if u, ok := wish.UserFromContext(s.Context()); ok {
+				for _, group := range u.Groups {
+					cmds = append(cmds, groups[group]...)
+				}
+			}
+			Middleware(cmds...)(sh)(s)
+		}
+	}
+}
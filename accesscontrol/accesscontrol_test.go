@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"charm.land/ssh"
+	"charm.land/wish/v2"
 	"charm.land/wish/v2/accesscontrol"
 	"charm.land/wish/v2/testsession"
 	gossh "golang.org/x/crypto/ssh"
@@ -77,6 +78,50 @@ func TestMiddleware(t *testing.T) {
 	})
 }
 
+func TestGroupMiddleware(t *testing.T) {
+	hash, err := wish.HashPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := wish.NewMemoryUserStore(
+		wish.UserRecord{Name: "foo", PasswordHash: hash, Groups: []string{"devs", "ops"}},
+		wish.UserRecord{Name: "bar", PasswordHash: hash},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := func(tb testing.TB, user string) *gossh.Session {
+		tb.Helper()
+		srv := &ssh.Server{
+			Handler: accesscontrol.GroupMiddleware(map[string][]string{
+				"devs": {"echo"},
+				"ops":  {"uptime"},
+			})(func(s ssh.Session) {
+				s.Write([]byte(out))
+			}),
+		}
+		if err := wish.WithUserStore(store)(srv); err != nil {
+			tb.Fatal(err)
+		}
+		return testsession.New(tb, srv, &gossh.ClientConfig{
+			User: user,
+			Auth: []gossh.AuthMethod{gossh.Password("secret")},
+		})
+	}
+
+	for user, cmds := range map[string]map[string]bool{
+		"foo": {"echo": true, "uptime": true, "cat": false},
+		"bar": {"echo": false},
+	} {
+		for cmd, allowed := range cmds {
+			out, err := session(t, user).Output(cmd)
+			if allowed != (err == nil) {
+				t.Errorf("%s %s: expected allowed %v, got %q %v", user, cmd, allowed, out, err)
+			}
+		}
+	}
+}
+
 func setup(tb testing.TB, allowedCmds ...string) *gossh.Session {
 	tb.Helper()
 	return testsession.New(tb, &ssh.Server{
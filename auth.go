@@ -0,0 +1,270 @@
+package wish
+
+import (
+	"encoding/hex"
+	"errors"
+	"slices"
+
+	"charm.land/ssh"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// AuthMethod is an authentication method of an AuthPolicy chain, accepting
+// or denying the client.
+type AuthMethod struct {
+	m *authMethod
+}
+
+type authMethod struct {
+	publicKey           ssh.PublicKeyHandler
+	password            ssh.PasswordHandler
+	keyboardInteractive ssh.KeyboardInteractiveHandler
+}
+
+// PublicKeyMethod returns the public key AuthMethod using the given handler,
+// e.g. the one of a Keyring.
+func PublicKeyMethod(h ssh.PublicKeyHandler) AuthMethod {
+	return AuthMethod{&authMethod{publicKey: h}}
+}
+
+// PasswordMethod returns the password AuthMethod using the given handler.
+func PasswordMethod(h ssh.PasswordHandler) AuthMethod {
+	return AuthMethod{&authMethod{password: h}}
+}
+
+// KeyboardInteractiveMethod returns the keyboard-interactive AuthMethod using
+// the given handler, e.g. the one of a TOTP.
+func KeyboardInteractiveMethod(h ssh.KeyboardInteractiveHandler) AuthMethod {
+	return AuthMethod{&authMethod{keyboardInteractive: h}}
+}
+
+// AuthPolicy is the sequences of authentication methods, or chains, allowing
+// the users in. Clients must pass every method of one of the chains, in order,
+// e.g. a public key and then a one-time password. Each method passed but the
+// last one is reported to the client as a partial success, telling it the
+// methods it may try next.
+// Analogous to the AuthenticationMethods OpenSSH option.
+//
+// When several chains allow the same method next, their methods are tried in
+// the order the chains were added, and only the chains of the first method
+// accepting the client go on.
+type AuthPolicy struct {
+	chains [][]AuthMethod
+	users  map[string][][]AuthMethod
+}
+
+// NewAuthPolicy returns an empty AuthPolicy, which doesn't allow anyone in
+// until chains are added.
+func NewAuthPolicy() *AuthPolicy {
+	return &AuthPolicy{users: map[string][][]AuthMethod{}}
+}
+
+// Allow adds a chain of methods allowing the users without chains of their
+// own, see AllowUser.
+func (p *AuthPolicy) Allow(methods ...AuthMethod) *AuthPolicy {
+	p.chains = append(p.chains, methods)
+	return p
+}
+
+// AllowUser adds a chain of methods allowing the given user. Users with chains
+// of their own may only use those.
+func (p *AuthPolicy) AllowUser(user string, methods ...AuthMethod) *AuthPolicy {
+	p.users[user] = append(p.users[user], methods)
+	return p
+}
+
+func (p *AuthPolicy) chainsFor(user string) [][]AuthMethod {
+	if chains, ok := p.users[user]; ok {
+		return chains
+	}
+	return p.chains
+}
+
+// all returns the chains of all the users.
+func (p *AuthPolicy) all() [][]AuthMethod {
+	all := slices.Clone(p.chains)
+	for _, chains := range p.users {
+		all = append(all, chains...)
+	}
+	return all
+}
+
+func (p *AuthPolicy) validate() error {
+	if _, ok := p.users[""]; ok {
+		return errors.New("auth policy: empty user name")
+	}
+	for _, chain := range p.all() {
+		if len(chain) == 0 {
+			return errors.New("auth policy: empty chain")
+		}
+		for _, method := range chain {
+			if m := method.m; m == nil || m.publicKey == nil && m.password == nil && m.keyboardInteractive == nil {
+				return errors.New("auth policy: method without handler")
+			}
+		}
+	}
+	return nil
+}
+
+// WithAuthPolicy returns an ssh.Option that authenticates the clients with
+// the given policy, replacing the public key, password and keyboard-interactive
+// handlers, which must not be set by later options.
+func WithAuthPolicy(p *AuthPolicy) ssh.Option {
+	return func(s *ssh.Server) error {
+		if err := p.validate(); err != nil {
+			return err
+		}
+		s.PublicKeyHandler = nil
+		s.PasswordHandler = nil
+		s.KeyboardInteractiveHandler = nil
+
+		config := s.ServerConfigCallback
+		s.ServerConfigCallback = func(ctx ssh.Context) *gossh.ServerConfig {
+			cfg := &gossh.ServerConfig{}
+			if config != nil {
+				cfg = config(ctx)
+			}
+			a := &authenticator{ctx: ctx, pending: map[*gossh.Permissions]authStep{}}
+			cb := a.callbacks(p.chainsFor, p.all())
+			cfg.PublicKeyCallback = cb.PublicKeyCallback
+			cfg.PasswordCallback = cb.PasswordCallback
+			cfg.KeyboardInteractiveCallback = cb.KeyboardInteractiveCallback
+			cfg.VerifiedPublicKeyCallback = a.verifiedPublicKey
+			return cfg
+		}
+		return nil
+	}
+}
+
+// authStep is where the chains are at once a method passed.
+type authStep struct {
+	next [][]AuthMethod
+	done bool
+}
+
+// authenticator authenticates the client of a connection.
+type authenticator struct {
+	ctx ssh.Context
+
+	// pending are the steps of the public keys accepted, until the client
+	// proves it has their private keys.
+	pending map[*gossh.Permissions]authStep
+}
+
+// callbacks returns the callbacks of the methods starting the chains of the
+// user. The methods offered to the clients are the ones starting the given
+// chains, as the user isn't known before the first attempt.
+func (a *authenticator) callbacks(chains func(user string) [][]AuthMethod, offered [][]AuthMethod) gossh.ServerAuthCallbacks {
+	var cb gossh.ServerAuthCallbacks
+	for _, chain := range offered {
+		m := chain[0].m
+		if m.publicKey != nil && cb.PublicKeyCallback == nil {
+			cb.PublicKeyCallback = func(conn gossh.ConnMetadata, key gossh.PublicKey) (*gossh.Permissions, error) {
+				a.begin(conn)
+				step, ok := advance(chains(conn.User()), func(m *authMethod) bool {
+					return m.publicKey != nil && m.publicKey(a.ctx, key)
+				})
+				if !ok {
+					return nil, ssh.ErrPermissionDenied
+				}
+				perms := a.ctx.Permissions().Permissions
+				a.pending[perms] = step
+				return perms, nil
+			}
+		}
+		if m.password != nil && cb.PasswordCallback == nil {
+			cb.PasswordCallback = func(conn gossh.ConnMetadata, password []byte) (*gossh.Permissions, error) {
+				a.begin(conn)
+				step, ok := advance(chains(conn.User()), func(m *authMethod) bool {
+					return m.password != nil && m.password(a.ctx, string(password))
+				})
+				if !ok {
+					return nil, ssh.ErrPermissionDenied
+				}
+				return a.result(step, a.ctx.Permissions().Permissions)
+			}
+		}
+		if m.keyboardInteractive != nil && cb.KeyboardInteractiveCallback == nil {
+			cb.KeyboardInteractiveCallback = func(conn gossh.ConnMetadata, challenger gossh.KeyboardInteractiveChallenge) (*gossh.Permissions, error) {
+				a.begin(conn)
+				step, ok := advance(chains(conn.User()), func(m *authMethod) bool {
+					return m.keyboardInteractive != nil && m.keyboardInteractive(a.ctx, challenger)
+				})
+				if !ok {
+					return nil, ssh.ErrPermissionDenied
+				}
+				return a.result(step, a.ctx.Permissions().Permissions)
+			}
+		}
+	}
+	return cb
+}
+
+// verifiedPublicKey completes the step of a public key, once the client
+// proved it has its private key. The key is only known to the session then,
+// as the permissions of the partial successes are discarded.
+func (a *authenticator) verifiedPublicKey(_ gossh.ConnMetadata, key gossh.PublicKey, perms *gossh.Permissions, _ string) (*gossh.Permissions, error) {
+	step, ok := a.pending[perms]
+	if !ok {
+		return nil, ssh.ErrPermissionDenied
+	}
+	a.ctx.SetValue(ssh.ContextKeyPublicKey, key)
+	return a.result(step, perms)
+}
+
+// result returns the permissions of the session if all the methods of a chain
+// passed, or the callbacks of the next methods.
+func (a *authenticator) result(step authStep, perms *gossh.Permissions) (*gossh.Permissions, error) {
+	if step.done {
+		return perms, nil
+	}
+	next := func(string) [][]AuthMethod { return step.next }
+	return nil, &gossh.PartialSuccessError{Next: a.callbacks(next, step.next)}
+}
+
+// begin resets the permissions and sets the connection metadata, as ssh.Server
+// does before calling its handlers.
+func (a *authenticator) begin(conn gossh.ConnMetadata) {
+	a.ctx.Permissions().Permissions = &gossh.Permissions{}
+	a.ctx.SetValue(ssh.ContextKeyUser, conn.User())
+	if a.ctx.Value(ssh.ContextKeySessionID) != nil {
+		return
+	}
+	a.ctx.SetValue(ssh.ContextKeySessionID, hex.EncodeToString(conn.SessionID()))
+	a.ctx.SetValue(ssh.ContextKeyClientVersion, string(conn.ClientVersion()))
+	a.ctx.SetValue(ssh.ContextKeyServerVersion, string(conn.ServerVersion()))
+	a.ctx.SetValue(ssh.ContextKeyLocalAddr, conn.LocalAddr())
+	a.ctx.SetValue(ssh.ContextKeyRemoteAddr, conn.RemoteAddr())
+}
+
+// advance tries the next methods of the chains until one accepts the client,
+// and returns where the chains of that method are at.
+func advance(chains [][]AuthMethod, try func(*authMethod) bool) (authStep, bool) {
+	var accepted *authMethod
+	tried := map[*authMethod]bool{}
+	for _, chain := range chains {
+		m := chain[0].m
+		if tried[m] {
+			continue
+		}
+		tried[m] = true
+		if try(m) {
+			accepted = m
+			break
+		}
+	}
+	if accepted == nil {
+		return authStep{}, false
+	}
+	var step authStep
+	for _, chain := range chains {
+		if chain[0].m != accepted {
+			continue
+		}
+		if len(chain) == 1 {
+			return authStep{done: true}, true
+		}
+		step.next = append(step.next, chain[1:])
+	}
+	return step, true
+}
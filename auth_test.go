@@ -0,0 +1,135 @@
+package wish
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"charm.land/ssh"
+	"charm.land/wish/v2/testsession"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// authServer returns the address of a server where foo must use its key and
+// a one-time password, and the others either a password and a one-time
+// password or a key, and the current one-time password.
+func authServer(tb testing.TB) (string, string) {
+	tb.Helper()
+	now := time.Unix(1111111111, 0)
+	totp := NewTOTP(func(ssh.Context) ([]byte, bool) { return rfcSecret, true })
+	totp.Now = func() time.Time { return now }
+	foo := parseKey(tb, string(getBytes(tb, "testdata/foo.pub")))
+
+	publicKey := PublicKeyMethod(func(_ ssh.Context, key ssh.PublicKey) bool {
+		return ssh.KeysEqual(key, foo)
+	})
+	password := PasswordMethod(func(_ ssh.Context, password string) bool {
+		return password == "secret"
+	})
+	otp := KeyboardInteractiveMethod(totp.KeyboardInteractiveHandler)
+	policy := NewAuthPolicy().
+		AllowUser("foo", publicKey, otp).
+		Allow(password, otp).
+		Allow(publicKey)
+
+	s := &ssh.Server{
+		Handler: func(s ssh.Session) {
+			fmt.Fprintf(s, "%s %v", s.User(), s.PublicKey() != nil)
+		},
+	}
+	requireNoError(tb, WithAuthPolicy(policy)(s))
+	return testsession.Listen(tb, s), totp.Code(rfcSecret, now)
+}
+
+func authLogin(tb testing.TB, addr, user string, methods ...gossh.AuthMethod) (string, error) {
+	tb.Helper()
+	sess, err := testsession.NewClientSession(tb, addr, &gossh.ClientConfig{
+		User: user,
+		Auth: methods,
+	})
+	if err != nil {
+		return "", err //nolint:wrapcheck
+	}
+	out, err := sess.Output("")
+	requireNoError(tb, err)
+	return string(out), nil
+}
+
+func answer(code string) gossh.AuthMethod {
+	return gossh.KeyboardInteractive(func(string, string, []string, []bool) ([]string, error) {
+		return []string{code}, nil
+	})
+}
+
+func requireNoAuth(tb testing.TB, err error) {
+	tb.Helper()
+	if err == nil || !strings.Contains(err.Error(), "unable to authenticate") {
+		tb.Fatalf("expected an authentication error, got %v", err)
+	}
+}
+
+func TestAuthPolicy(t *testing.T) {
+	signer, err := gossh.ParsePrivateKey(getBytes(t, "testdata/foo"))
+	requireNoError(t, err)
+	publicKey := gossh.PublicKeys(signer)
+
+	t.Run("public key and code", func(t *testing.T) {
+		addr, code := authServer(t)
+		out, err := authLogin(t, addr, "foo", publicKey, answer(code))
+		requireNoError(t, err)
+		requireEqual(t, "foo true", out)
+	})
+
+	t.Run("public key only", func(t *testing.T) {
+		addr, _ := authServer(t)
+		_, err := authLogin(t, addr, "foo", publicKey)
+		requireNoAuth(t, err)
+	})
+
+	t.Run("code only", func(t *testing.T) {
+		addr, code := authServer(t)
+		_, err := authLogin(t, addr, "foo", answer(code))
+		requireNoAuth(t, err)
+	})
+
+	t.Run("wrong code", func(t *testing.T) {
+		addr, _ := authServer(t)
+		_, err := authLogin(t, addr, "foo", publicKey, answer("000000"))
+		requireNoAuth(t, err)
+	})
+
+	t.Run("chains of other users", func(t *testing.T) {
+		addr, code := authServer(t)
+		_, err := authLogin(t, addr, "foo", gossh.Password("secret"), answer(code))
+		requireNoAuth(t, err)
+	})
+
+	t.Run("password and code", func(t *testing.T) {
+		addr, code := authServer(t)
+		out, err := authLogin(t, addr, "bar", gossh.Password("secret"), answer(code))
+		requireNoError(t, err)
+		requireEqual(t, "bar false", out)
+		_, err = authLogin(t, addr, "bar", gossh.Password("nope"), answer(code))
+		requireNoAuth(t, err)
+	})
+
+	t.Run("alternative chain", func(t *testing.T) {
+		addr, _ := authServer(t)
+		out, err := authLogin(t, addr, "bar", publicKey)
+		requireNoError(t, err)
+		requireEqual(t, "bar true", out)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, p := range []*AuthPolicy{
+			NewAuthPolicy().Allow(),
+			NewAuthPolicy().AllowUser("foo", AuthMethod{}),
+			NewAuthPolicy().AllowUser("", PasswordMethod(nil)),
+		} {
+			if err := WithAuthPolicy(p)(&ssh.Server{}); err == nil {
+				t.Fatal("expected an error, got nil")
+			}
+		}
+	})
+}
@@ -0,0 +1,168 @@
+package wish
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"charm.land/ssh"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// CertOption configures the certificates allowed by WithTrustedUserCAKeys.
+type CertOption func(*certOptions) error
+
+type certOptions struct {
+	principals     func(user string) []string
+	revoked        *fileCache[*RevocationList]
+	revokedSerials map[uint64]bool
+	critical       []string
+}
+
+// WithPrincipals sets the principals allowed for each user: certificates are
+// allowed if one of their principals is. Defaults to the user name itself.
+// Analogous to the AuthorizedPrincipalsFile OpenSSH option.
+func WithPrincipals(principals func(user string) []string) CertOption {
+	return func(o *certOptions) error {
+		o.principals = principals
+		return nil
+	}
+}
+
+// WithPrincipalsMap is like WithPrincipals, with the principals allowed for
+// each user name. Users not in the map are denied.
+func WithPrincipalsMap(principals map[string][]string) CertOption {
+	return WithPrincipals(func(user string) []string {
+		return principals[user]
+	})
+}
+
+// WithRevokedKeys denies the certificates revoked by the given file, a KRL or
+// a list of public keys. See ParseRevocationList. The file is reloaded when it
+// changes, and no certificates are allowed while it can't be read.
+// Analogous to the RevokedKeys OpenSSH option.
+func WithRevokedKeys(path string) CertOption {
+	return func(o *certOptions) error {
+		o.revoked = &fileCache[*RevocationList]{path: path, parse: ParseRevocationList}
+		_, err := o.revoked.reload()
+		return err
+	}
+}
+
+// WithRevokedSerials denies the certificates with the given serial numbers.
+func WithRevokedSerials(serials ...uint64) CertOption {
+	return func(o *certOptions) error {
+		if o.revokedSerials == nil {
+			o.revokedSerials = map[uint64]bool{}
+		}
+		for _, serial := range serials {
+			o.revokedSerials[serial] = true
+		}
+		return nil
+	}
+}
+
+// WithCriticalOptions allows the certificates with the given critical options,
+// which the caller must then enforce, e.g. force-command with
+// keyoptions.WithForceCommand. Certificates with other critical options than
+// source-address are denied.
+func WithCriticalOptions(names ...string) CertOption {
+	return func(o *certOptions) error {
+		o.critical = append(o.critical, names...)
+		return nil
+	}
+}
+
+// critical option of the certificates enforced by checkCert, see
+// PROTOCOL.certkeys in the OpenSSH sources.
+const certSourceAddress = "source-address"
+
+// checkCert checks that the certificate is allowed for the session, besides
+// its signature.
+func (o *certOptions) checkCert(ctx ssh.Context, cert *gossh.Certificate) error {
+	if cert.CertType != gossh.UserCert {
+		return errors.New("not a user certificate")
+	}
+	if len(cert.ValidPrincipals) == 0 {
+		// golang.org/x/crypto/ssh would allow any user.
+		return errors.New("certificate has no principals")
+	}
+	if o.revokedSerials[cert.Serial] {
+		return fmt.Errorf("certificate serial %d is revoked", cert.Serial)
+	}
+	if o.revoked != nil {
+		l, err := o.revoked.get()
+		if err != nil {
+			return err
+		}
+		if l.IsRevoked(cert) {
+			return errors.New("certificate is revoked")
+		}
+	}
+
+	principals := []string{ctx.User()}
+	if o.principals != nil {
+		principals = o.principals(ctx.User())
+	}
+	checker := &gossh.CertChecker{
+		SupportedCriticalOptions: append([]string{certSourceAddress}, o.critical...),
+	}
+	err := fmt.Errorf("no principals allowed for %s", ctx.User())
+	for _, p := range principals {
+		if err = checker.CheckCert(p, cert); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	if addrs, ok := cert.CriticalOptions[certSourceAddress]; ok {
+		return checkSourceAddress(ctx.RemoteAddr(), addrs)
+	}
+	return nil
+}
+
+// checkSourceAddress checks the address against the source-address critical
+// option, a list of addresses or CIDR ranges.
+func checkSourceAddress(addr net.Addr, addrs string) error {
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return fmt.Errorf("source-address: unknown address %v", addr)
+	}
+	for _, a := range strings.Split(addrs, ",") {
+		a = strings.TrimSpace(a)
+		if _, cidr, err := net.ParseCIDR(a); err == nil {
+			if cidr.Contains(tcp.IP) {
+				return nil
+			}
+			continue
+		}
+		ip := net.ParseIP(a)
+		if ip == nil {
+			return fmt.Errorf("source-address: invalid address %q", a)
+		}
+		if ip.Equal(tcp.IP) {
+			return nil
+		}
+	}
+	return fmt.Errorf("source-address: %v is not allowed", tcp.IP)
+}
+
+var contextKeyCertificate = &contextKey{"certificate"}
+
+// CertificateFromContext returns the certificate that authenticated the
+// session, when using WithTrustedUserCAKeys, e.g. to authorize it by its key
+// ID, serial or extensions.
+func CertificateFromContext(ctx ssh.Context) (*gossh.Certificate, bool) {
+	cert, ok := ctx.Value(contextKeyCertificate).(*gossh.Certificate)
+	key, _ := ctx.Value(ssh.ContextKeyPublicKey).(ssh.PublicKey)
+	if !ok || key == nil || !bytes.Equal(key.Marshal(), cert.Marshal()) {
+		// set by an attempt with another key, which may not have been
+		// verified.
+		return nil, false
+	}
+	return cert, true
+}
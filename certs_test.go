@@ -0,0 +1,234 @@
+package wish
+
+import (
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"charm.land/ssh"
+	"charm.land/wish/v2/testsession"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// signCert returns a signer of a certificate of testdata/foo, signed by
+// testdata/ca, for the principal foo.
+func signCert(tb testing.TB, mutate func(*gossh.Certificate)) gossh.Signer {
+	tb.Helper()
+	ca, err := gossh.ParsePrivateKey(getBytes(tb, "testdata/ca"))
+	requireNoError(tb, err)
+	signer, err := gossh.ParsePrivateKey(getBytes(tb, "testdata/foo"))
+	requireNoError(tb, err)
+
+	cert := &gossh.Certificate{
+		Key:             signer.PublicKey(),
+		Serial:          1,
+		CertType:        gossh.UserCert,
+		KeyId:           "id-1",
+		ValidPrincipals: []string{"foo"},
+		ValidBefore:     gossh.CertTimeInfinity,
+		Permissions: gossh.Permissions{
+			Extensions: map[string]string{"permit-pty": ""},
+		},
+	}
+	if mutate != nil {
+		mutate(cert)
+	}
+	requireNoError(tb, cert.SignCert(rand.Reader, ca))
+	certSigner, err := gossh.NewCertSigner(cert, signer)
+	requireNoError(tb, err)
+	return certSigner
+}
+
+func certServer(tb testing.TB, opts ...CertOption) string {
+	tb.Helper()
+	s := &ssh.Server{
+		Handler: func(s ssh.Session) {
+			cert, ok := CertificateFromContext(s.Context())
+			fmt.Fprintf(s, "%v %s %d", ok, cert.KeyId, cert.Serial)
+		},
+	}
+	requireNoError(tb, WithTrustedUserCAKeys("testdata/ca.pub", opts...)(s))
+	return testsession.Listen(tb, s)
+}
+
+func certLogin(tb testing.TB, addr, user string, signer gossh.Signer) (string, error) {
+	tb.Helper()
+	sess, err := testsession.NewClientSession(tb, addr, &gossh.ClientConfig{
+		User: user,
+		Auth: []gossh.AuthMethod{gossh.PublicKeys(signer)},
+	})
+	if err != nil {
+		return "", err //nolint:wrapcheck
+	}
+	out, err := sess.Output("")
+	requireNoError(tb, err)
+	return string(out), nil
+}
+
+func TestCertificates(t *testing.T) {
+	t.Run("context", func(t *testing.T) {
+		out, err := certLogin(t, certServer(t), "foo", signCert(t, nil))
+		requireNoError(t, err)
+		requireEqual(t, "true id-1 1", out)
+	})
+
+	t.Run("no principals", func(t *testing.T) {
+		_, err := certLogin(t, certServer(t), "foo", signCert(t, func(c *gossh.Certificate) {
+			c.ValidPrincipals = nil
+		}))
+		requireAuthError(t, err)
+	})
+
+	t.Run("host certificate", func(t *testing.T) {
+		_, err := certLogin(t, certServer(t), "foo", signCert(t, func(c *gossh.Certificate) {
+			c.CertType = gossh.HostCert
+		}))
+		requireAuthError(t, err)
+	})
+
+	t.Run("principals", func(t *testing.T) {
+		addr := certServer(t, WithPrincipalsMap(map[string][]string{
+			"git": {"admins", "foo"},
+		}))
+		_, err := certLogin(t, addr, "git", signCert(t, nil))
+		requireNoError(t, err)
+		_, err = certLogin(t, addr, "foo", signCert(t, nil))
+		requireAuthError(t, err)
+	})
+
+	t.Run("source-address", func(t *testing.T) {
+		addr := certServer(t)
+		_, err := certLogin(t, addr, "foo", signCert(t, func(c *gossh.Certificate) {
+			c.CriticalOptions = map[string]string{"source-address": "192.0.2.1,127.0.0.0/8"}
+		}))
+		requireNoError(t, err)
+		_, err = certLogin(t, addr, "foo", signCert(t, func(c *gossh.Certificate) {
+			c.CriticalOptions = map[string]string{"source-address": "192.0.2.0/24"}
+		}))
+		requireAuthError(t, err)
+	})
+
+	t.Run("unsupported critical option", func(t *testing.T) {
+		_, err := certLogin(t, certServer(t), "foo", signCert(t, func(c *gossh.Certificate) {
+			c.CriticalOptions = map[string]string{"verify-required": ""}
+		}))
+		requireAuthError(t, err)
+	})
+
+	t.Run("force-command", func(t *testing.T) {
+		forced := func(c *gossh.Certificate) {
+			c.CriticalOptions = map[string]string{"force-command": "echo forced"}
+		}
+		_, err := certLogin(t, certServer(t), "foo", signCert(t, forced))
+		requireAuthError(t, err)
+		_, err = certLogin(t, certServer(t, WithCriticalOptions("force-command")), "foo", signCert(t, forced))
+		requireNoError(t, err)
+	})
+
+	t.Run("revoked serials", func(t *testing.T) {
+		addr := certServer(t, WithRevokedSerials(5, 6))
+		_, err := certLogin(t, addr, "foo", signCert(t, func(c *gossh.Certificate) { c.Serial = 6 }))
+		requireAuthError(t, err)
+		_, err = certLogin(t, addr, "foo", signCert(t, func(c *gossh.Certificate) { c.Serial = 7 }))
+		requireNoError(t, err)
+	})
+
+	t.Run("revoked keys", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "revoked")
+		requireNoError(t, os.WriteFile(path, getBytes(t, "testdata/revoked-certs.krl"), 0o600))
+		addr := certServer(t, WithRevokedKeys(path))
+		_, err := certLogin(t, addr, "foo", signCert(t, func(c *gossh.Certificate) { c.Serial = 15 }))
+		requireAuthError(t, err)
+		_, err = certLogin(t, addr, "foo", signCert(t, func(c *gossh.Certificate) { c.Serial = 21 }))
+		requireNoError(t, err)
+
+		// reloaded when it changes, and denying everyone when it's gone.
+		requireNoError(t, os.WriteFile(path, getBytes(t, "testdata/revoked-key.krl"), 0o600))
+		_, err = certLogin(t, addr, "foo", signCert(t, func(c *gossh.Certificate) { c.Serial = 21 }))
+		requireAuthError(t, err)
+		requireNoError(t, os.Remove(path))
+		_, err = certLogin(t, addr, "foo", signCert(t, func(c *gossh.Certificate) { c.Serial = 1 }))
+		requireAuthError(t, err)
+	})
+
+	t.Run("revoked keys file not found", func(t *testing.T) {
+		if err := WithTrustedUserCAKeys("testdata/ca.pub", WithRevokedKeys("testdata/nope.krl"))(&ssh.Server{}); err == nil {
+			t.Fatal("expected an error, got nil")
+		}
+	})
+}
+
+func TestRevocationList(t *testing.T) {
+	cert := func(tb testing.TB, serial uint64, keyID string) ssh.PublicKey {
+		tb.Helper()
+		return signCert(tb, func(c *gossh.Certificate) {
+			c.Serial = serial
+			c.KeyId = keyID
+		}).PublicKey()
+	}
+	foo := parseKey(t, string(getBytes(t, "testdata/foo.pub")))
+
+	t.Run("certificates", func(t *testing.T) {
+		l, err := ParseRevocationList(getBytes(t, "testdata/revoked-certs.krl"))
+		requireNoError(t, err)
+		for serial, revoked := range map[uint64]bool{
+			0: false, 6: false, 7: true, 8: false, 10: true, 20: true, 21: false,
+			100: true, 101: false, 110: true, 111: false, 112: false, 1 << 63: false,
+		} {
+			if got := l.IsRevoked(cert(t, serial, "id-1")); got != revoked {
+				t.Errorf("serial %d: expected revoked %v, got %v", serial, revoked, got)
+			}
+		}
+		requireEqual(t, true, l.IsRevoked(cert(t, 1, "id-revoked")))
+		requireEqual(t, false, l.IsRevoked(foo))
+	})
+
+	t.Run("explicit key", func(t *testing.T) {
+		l, err := ParseRevocationList(getBytes(t, "testdata/revoked-key.krl"))
+		requireNoError(t, err)
+		requireEqual(t, true, l.IsRevoked(foo))
+		requireEqual(t, true, l.IsRevoked(cert(t, 1, "id-1")))
+		requireEqual(t, false, l.IsRevoked(parseKey(t, k1)))
+	})
+
+	t.Run("ca fingerprint", func(t *testing.T) {
+		l, err := ParseRevocationList(getBytes(t, "testdata/revoked-ca.krl"))
+		requireNoError(t, err)
+		requireEqual(t, false, l.IsRevoked(foo))
+		requireEqual(t, true, l.IsRevoked(cert(t, 1, "id-1")))
+	})
+
+	t.Run("public keys", func(t *testing.T) {
+		l, err := ParseRevocationList([]byte("# revoked\n" + k1 + "\n"))
+		requireNoError(t, err)
+		requireEqual(t, true, l.IsRevoked(parseKey(t, k1)))
+		requireEqual(t, false, l.IsRevoked(foo))
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		krl := getBytes(t, "testdata/revoked-certs.krl")
+		if _, err := ParseRevocationList(krl[:len(krl)-3]); err == nil {
+			t.Fatal("expected an error, got nil")
+		}
+	})
+}
+
+func TestCertificateFromContext(t *testing.T) {
+	cert := signCert(t, nil).PublicKey().(*gossh.Certificate)
+	ctx := &testContext{}
+	ctx.SetValue(contextKeyCertificate, cert)
+	_, ok := CertificateFromContext(ctx)
+	requireEqual(t, false, ok)
+
+	// authenticated with another key, e.g. the certified one alone.
+	ctx.SetValue(ssh.ContextKeyPublicKey, cert.Key)
+	_, ok = CertificateFromContext(ctx)
+	requireEqual(t, false, ok)
+
+	ctx.SetValue(ssh.ContextKeyPublicKey, cert)
+	got, ok := CertificateFromContext(ctx)
+	requireEqual(t, true, ok)
+	requireEqual(t, "id-1", got.KeyId)
+}
@@ -0,0 +1,65 @@
+package wish
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"charm.land/log/v2"
+)
+
+// fileCache holds the parsed content of a file, parsed again whenever the
+// file changes, which is checked on each access.
+type fileCache[T any] struct {
+	path  string
+	parse func(data []byte) (T, error)
+
+	mu    sync.Mutex
+	info  os.FileInfo
+	value T
+	err   error
+}
+
+// get returns the parsed content of the file, reloading it if it changed.
+// The error of the last load, if any, is returned instead.
+func (c *fileCache[T]) get() (T, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	info, err := os.Stat(c.path)
+	if err == nil && c.info != nil && os.SameFile(c.info, info) &&
+		c.info.ModTime().Equal(info.ModTime()) && c.info.Size() == info.Size() {
+		return c.value, c.err
+	}
+	prev := c.err
+	c.load(info, err)
+	if c.err != nil && (prev == nil || prev.Error() != c.err.Error()) {
+		log.Warn("failed to reload", "path", c.path, "error", c.err)
+	}
+	return c.value, c.err
+}
+
+// reload loads the file again, even if it didn't change.
+func (c *fileCache[T]) reload() (T, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	info, err := os.Stat(c.path)
+	c.load(info, err)
+	return c.value, c.err
+}
+
+// load parses the file, given the result of its stat, with c.mu held.
+func (c *fileCache[T]) load(info os.FileInfo, err error) {
+	var zero T
+	c.info, c.value, c.err = nil, zero, nil
+	if err != nil {
+		c.err = fmt.Errorf("stat %s: %w", c.path, err)
+		return
+	}
+	bts, err := os.ReadFile(c.path)
+	if err != nil {
+		c.err = fmt.Errorf("read %s: %w", c.path, err)
+		return
+	}
+	c.info = info
+	c.value, c.err = c.parse(bts)
+}
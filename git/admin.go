@@ -0,0 +1,268 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"charm.land/log/v2"
+	"charm.land/ssh"
+	"charm.land/wish/v2"
+	"charm.land/wish/v2/router"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// ErrRepoExists represents an attempt to create or rename a repo over an
+// existing one.
+var ErrRepoExists = errors.New("repo already exists")
+
+// defaultDescription is the description written by git init.
+const defaultDescription = "Unnamed repository; edit this file 'description' to name the repository."
+
+// AdminMiddleware adds commands managing the repos stored in the specified
+// repo directory, next to Middleware:
+//
+//	repo create <name> [description]
+//	repo delete <name>
+//	repo list
+//	repo rename <name> <new-name>
+//	repo set-description <name> <description...>
+//	repo set-default-branch <name> <branch>
+//
+// The commands require AdminAccess to the repos they change, while listing
+// shows the repos the user can read. The options should be the ones given to
+// Middleware, so both use the same repo paths.
+//
+// Only the repo commands are handled, and `repo --help` shows their usage.
+// The others, including help, are passed to the next handler.
+func AdminMiddleware(repoDir string, gh Hooks, opts ...Option) wish.Middleware {
+	a := &admin{repoDir: repoDir, gh: gh, o: newOptions(opts)}
+	r := router.New()
+	r.Handle("repo create <name> [description]", "Create a repo", a.create)
+	r.Handle("repo delete <name>", "Delete a repo", a.delete)
+	r.Handle("repo list", "List the repos", a.list)
+	r.Handle("repo rename <name> <new-name>", "Rename a repo", a.rename)
+	r.Handle("repo set-description <name> <description...>", "Set the description of a repo", a.setDescription)
+	r.Handle("repo set-default-branch <name> <branch>", "Set the default branch of a repo", a.setDefaultBranch)
+	return r.Middleware()
+}
+
+type admin struct {
+	repoDir string
+	gh      Hooks
+	o       options
+}
+
+// repo resolves the given repo, exiting with an error if the session doesn't
+// have AdminAccess to it.
+func (a *admin) repo(s ssh.Session, repo string) (string, string, bool) {
+	name, rp, err := a.o.resolver.ResolveRepo(s, a.repoDir, repo)
+	if err != nil {
+		wish.Fatalln(s, err)
+		return "", "", false
+	}
+	access, err := authRepo(s, a.gh, AdminOperation, name)
+	if err != nil {
+		wish.Fatalln(s, err)
+		return "", "", false
+	}
+	if access < AdminAccess {
+		wish.Fatalln(s, ErrNotAuthed)
+		return "", "", false
+	}
+	return name, rp, true
+}
+
+// existing is like repo, but also exits with an error if the repo doesn't
+// exist.
+func (a *admin) existing(s ssh.Session, repo string) (string, string, bool) {
+	name, rp, ok := a.repo(s, repo)
+	if !ok {
+		return "", "", false
+	}
+	if !isRepo(rp) {
+		wish.Fatalln(s, ErrInvalidRepo)
+		return "", "", false
+	}
+	return name, rp, true
+}
+
+func (a *admin) create(s ssh.Session, args router.Args) {
+	name, rp, ok := a.repo(s, args.Get("name"))
+	if !ok {
+		return
+	}
+	if !a.available(s, name, rp) {
+		return
+	}
+	if err := EnsureRepo(filepath.Dir(rp), filepath.Base(rp)); err != nil {
+		a.fail(s, "create", name, err)
+		return
+	}
+	if desc, ok := args.Lookup("description"); ok {
+		if err := writeDescription(rp, desc); err != nil {
+			a.fail(s, "create", name, err)
+			return
+		}
+	}
+	wish.Printf(s, "Created %s\n", name)
+}
+
+func (a *admin) delete(s ssh.Session, args router.Args) {
+	name, rp, ok := a.existing(s, args.Get("name"))
+	if !ok {
+		return
+	}
+	if err := os.RemoveAll(rp); err != nil {
+		a.fail(s, "delete", name, err)
+		return
+	}
+	wish.Printf(s, "Deleted %s\n", name)
+}
+
+func (a *admin) rename(s ssh.Session, args router.Args) {
+	name, rp, ok := a.existing(s, args.Get("name"))
+	if !ok {
+		return
+	}
+	newName, newRp, ok := a.repo(s, args.Get("new-name"))
+	if !ok {
+		return
+	}
+	if isWithin(filepath.ToSlash(newRp), filepath.ToSlash(rp)) {
+		wish.Fatalln(s, fmt.Sprintf("cannot move %s inside itself, to %s", name, newName))
+		return
+	}
+	if !a.available(s, newName, newRp) {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(newRp), os.ModeDir|os.FileMode(0o700)); err != nil {
+		a.fail(s, "rename", newName, err)
+		return
+	}
+	if err := os.Rename(rp, newRp); err != nil {
+		a.fail(s, "rename", name, err)
+		return
+	}
+	wish.Printf(s, "Renamed %s to %s\n", name, newName)
+}
+
+func (a *admin) setDescription(s ssh.Session, args router.Args) {
+	name, rp, ok := a.existing(s, args.Get("name"))
+	if !ok {
+		return
+	}
+	if err := writeDescription(rp, strings.Join(args.Rest(), " ")); err != nil {
+		a.fail(s, "set description", name, err)
+		return
+	}
+	wish.Printf(s, "Updated the description of %s\n", name)
+}
+
+func (a *admin) setDefaultBranch(s ssh.Session, args router.Args) {
+	name, rp, ok := a.existing(s, args.Get("name"))
+	if !ok {
+		return
+	}
+	branch := plumbing.NewBranchReferenceName(args.Get("branch"))
+	if err := branch.Validate(); err != nil {
+		wish.Fatalln(s, fmt.Sprintf("invalid branch name: %q", args.Get("branch")))
+		return
+	}
+	r, err := git.PlainOpen(rp)
+	if err != nil {
+		a.fail(s, "set default branch", name, err)
+		return
+	}
+	if err := r.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, branch)); err != nil {
+		a.fail(s, "set default branch", name, err)
+		return
+	}
+	wish.Printf(s, "Set the default branch of %s to %s\n", name, branch.Short())
+}
+
+func (a *admin) list(s ssh.Session, _ router.Args) {
+	namer, _ := a.o.resolver.(RepoNamer)
+	tw := tabwriter.NewWriter(s, 0, 4, 2, ' ', 0)
+	err := filepath.WalkDir(a.repoDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() || p == a.repoDir || !isRepo(p) {
+			return nil
+		}
+		rel, err := filepath.Rel(a.repoDir, p)
+		if err != nil {
+			return err //nolint:wrapcheck
+		}
+		name := filepath.ToSlash(rel)
+		if namer != nil {
+			var ok bool
+			if name, ok = namer.RepoName(a.repoDir, p); !ok {
+				return fs.SkipDir
+			}
+		}
+		if access, err := authRepo(s, a.gh, FetchOperation, name); err == nil && access >= ReadOnlyAccess {
+			_, _ = fmt.Fprintf(tw, "%s\t%s\n", name, readDescription(p))
+		}
+		return fs.SkipDir
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		a.fail(s, "list", "", err)
+		return
+	}
+	_ = tw.Flush()
+}
+
+// available exits with an error if a repo already exists at rp.
+func (a *admin) available(s ssh.Session, name, rp string) bool {
+	exists, err := fileExists(rp)
+	if err != nil {
+		a.fail(s, "stat", name, err)
+		return false
+	}
+	if exists {
+		wish.Fatalln(s, ErrRepoExists)
+		return false
+	}
+	return true
+}
+
+// fail logs err and exits with ErrSystemMalfunction.
+func (a *admin) fail(s ssh.Session, op, repo string, err error) {
+	log.Error("git admin", "op", op, "repo", repo, "error", err)
+	wish.Fatalln(s, ErrSystemMalfunction)
+}
+
+// isRepo reports whether the given directory is a bare git repo.
+func isRepo(dir string) bool {
+	if fi, err := os.Stat(filepath.Join(dir, "objects")); err != nil || !fi.IsDir() {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(dir, "HEAD"))
+	return err == nil
+}
+
+func readDescription(rp string) string {
+	bts, err := os.ReadFile(filepath.Join(rp, "description"))
+	if err != nil {
+		return ""
+	}
+	desc := strings.TrimSpace(string(bts))
+	if desc == defaultDescription {
+		return ""
+	}
+	return desc
+}
+
+func writeDescription(rp, desc string) error {
+	if err := os.WriteFile(filepath.Join(rp, "description"), []byte(desc+"\n"), 0o600); err != nil {
+		return fmt.Errorf("write description: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package git
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"charm.land/ssh"
+	"charm.land/wish/v2/testsession"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// adminHooks give admin access to the "admin" user, read access to the
+// others, and hide the "secret" repo.
+type adminHooks struct{}
+
+func (adminHooks) AuthRepo(string, ssh.PublicKey) AccessLevel { return NoAccess }
+func (adminHooks) Push(string, ssh.PublicKey)                 {}
+func (adminHooks) Fetch(string, ssh.PublicKey)                {}
+
+func (adminHooks) AuthorizeRepo(s ssh.Session, _ Operation, repo string) (AccessLevel, error) {
+	switch {
+	case s.User() == "admin":
+		return AdminAccess, nil
+	case repo == "secret":
+		return NoAccess, nil
+	}
+	return ReadOnlyAccess, nil
+}
+
+func TestAdminMiddleware(t *testing.T) {
+	repoDir := t.TempDir()
+	srv := &ssh.Server{
+		Handler: AdminMiddleware(repoDir, adminHooks{}, WithResolver(PathResolver{GitSuffix: true}))(func(s ssh.Session) {
+			_, _ = s.Write([]byte("next\n"))
+		}),
+	}
+	addr := testsession.Listen(t, srv)
+	run := func(t *testing.T, user, cmd string) (string, error) {
+		t.Helper()
+		sess, err := testsession.NewClientSession(t, addr, &gossh.ClientConfig{
+			User:            user,
+			HostKeyCallback: gossh.InsecureIgnoreHostKey(), //nolint:gosec
+		})
+		requireNoError(t, err)
+		out, err := sess.CombinedOutput(cmd)
+		return string(out), err
+	}
+	requireOutput := func(t *testing.T, user, cmd, expected string) {
+		t.Helper()
+		out, err := run(t, user, cmd)
+		requireNoError(t, err)
+		if out != expected {
+			t.Fatalf("expected %q, got %q", expected, out)
+		}
+	}
+	requireFailure := func(t *testing.T, user, cmd, expected string) {
+		t.Helper()
+		out, err := run(t, user, cmd)
+		var exitErr *gossh.ExitError
+		if !errors.As(err, &exitErr) || exitErr.ExitStatus() != 1 {
+			t.Fatalf("expected exit status 1, got %v", err)
+		}
+		requireContains(t, out, expected)
+	}
+
+	t.Run("create", func(t *testing.T) {
+		requireOutput(t, "admin", "repo create a/repo1 'first repo'", "Created a/repo1\n")
+		requireOutput(t, "admin", "repo create secret", "Created secret\n")
+		if !isRepo(filepath.Join(repoDir, "a", "repo1.git")) {
+			t.Fatal("expected a bare repo")
+		}
+		requireFailure(t, "admin", "repo create a/repo1.git", ErrRepoExists.Error())
+		requireFailure(t, "admin", "repo create ../repo1", ErrInvalidRepo.Error())
+		requireFailure(t, "alice", "repo create repo2", ErrNotAuthed.Error())
+	})
+
+	t.Run("list", func(t *testing.T) {
+		requireOutput(t, "admin", "repo list", "a/repo1  first repo\nsecret   \n")
+		requireOutput(t, "alice", "repo list", "a/repo1  first repo\n")
+	})
+
+	t.Run("set description", func(t *testing.T) {
+		requireOutput(t, "admin", "repo set-description a/repo1 the first repo", "Updated the description of a/repo1\n")
+		requireOutput(t, "alice", "repo list", "a/repo1  the first repo\n")
+		requireFailure(t, "alice", "repo set-description a/repo1 mine", ErrNotAuthed.Error())
+		requireFailure(t, "admin", "repo set-description nope nope", ErrInvalidRepo.Error())
+	})
+
+	t.Run("set default branch", func(t *testing.T) {
+		requireOutput(t, "admin", "repo set-default-branch a/repo1 trunk", "Set the default branch of a/repo1 to trunk\n")
+		bts, err := os.ReadFile(filepath.Join(repoDir, "a", "repo1.git", "HEAD"))
+		requireNoError(t, err)
+		if got := strings.TrimSpace(string(bts)); got != "ref: refs/heads/trunk" {
+			t.Fatalf("unexpected HEAD: %q", got)
+		}
+		requireFailure(t, "admin", "repo set-default-branch a/repo1 'no spaces'", "invalid branch name")
+	})
+
+	t.Run("rename", func(t *testing.T) {
+		requireFailure(t, "admin", "repo rename a/repo1 secret", ErrRepoExists.Error())
+		requireFailure(t, "admin", "repo rename secret secret.git/repo1", "cannot move secret inside itself")
+		requireOutput(t, "admin", "repo rename a/repo1 b/c/repo1", "Renamed a/repo1 to b/c/repo1\n")
+		requireOutput(t, "alice", "repo list", "b/c/repo1  the first repo\n")
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		requireFailure(t, "alice", "repo delete b/c/repo1", ErrNotAuthed.Error())
+		requireOutput(t, "admin", "repo delete b/c/repo1", "Deleted b/c/repo1\n")
+		requireFailure(t, "admin", "repo delete b/c/repo1", ErrInvalidRepo.Error())
+		requireOutput(t, "alice", "repo list", "")
+	})
+
+	t.Run("other commands", func(t *testing.T) {
+		requireOutput(t, "alice", "git-upload-pack repo1", "next\n")
+		requireOutput(t, "alice", "help", "next\n")
+		out, err := run(t, "alice", "repo --help")
+		requireNoError(t, err)
+		requireContains(t, out, "repo rename <name> <new-name>")
+	})
+}
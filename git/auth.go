@@ -0,0 +1,68 @@
+package git
+
+import "charm.land/ssh"
+
+// Operation is a git operation requested by a client.
+type Operation int
+
+const (
+	// FetchOperation is a fetch or a clone, served by git-upload-pack.
+	FetchOperation Operation = iota
+
+	// PushOperation is a push, served by git-receive-pack.
+	PushOperation
+
+	// ArchiveOperation is a git archive --remote, served by
+	// git-upload-archive.
+	ArchiveOperation
+
+	// AdminOperation is an admin command, e.g. renaming a repo. It requires
+	// AdminAccess.
+	AdminOperation
+)
+
+// String implements fmt.Stringer.
+func (op Operation) String() string {
+	switch op {
+	case FetchOperation:
+		return "fetch"
+	case PushOperation:
+		return "push"
+	case ArchiveOperation:
+		return "archive"
+	case AdminOperation:
+		return "admin"
+	}
+	return "unknown"
+}
+
+// AuthHooks can be implemented by a Hooks implementation to authorize
+// requests with the details of the session, e.g. its user, its context or the
+// principals of its certificate, or the groups of its wish.UserFromContext.
+// When implemented, it's used instead of Hooks.AuthRepo.
+type AuthHooks interface {
+	// AuthorizeRepo returns the access level of the session to the repo for
+	// the requested operation. Returning an error denies access, and its
+	// message is shown to the client.
+	AuthorizeRepo(s ssh.Session, op Operation, repo string) (AccessLevel, error)
+}
+
+var operations = map[string]Operation{
+	"git-upload-pack":    FetchOperation,
+	"git-receive-pack":   PushOperation,
+	"git-upload-archive": ArchiveOperation,
+}
+
+func isGitCommand(cmd string) bool {
+	_, ok := operations[cmd]
+	return ok
+}
+
+// authRepo returns the access level of the session to the repo for the given
+// operation, using AuthHooks if implemented.
+func authRepo(s ssh.Session, gh Hooks, op Operation, repo string) (AccessLevel, error) {
+	if ah, ok := gh.(AuthHooks); ok {
+		return ah.AuthorizeRepo(s, op, repo) //nolint:wrapcheck
+	}
+	return gh.AuthRepo(repo, s.PublicKey()), nil
+}
@@ -0,0 +1,77 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"charm.land/ssh"
+)
+
+// namespaceHooks allow users to push to their own namespace only, anyone to
+// fetch, and no one to archive.
+type namespaceHooks struct{}
+
+func (namespaceHooks) AuthRepo(string, ssh.PublicKey) AccessLevel { return NoAccess }
+func (namespaceHooks) Push(string, ssh.PublicKey)                 {}
+func (namespaceHooks) Fetch(string, ssh.PublicKey)                {}
+
+func (namespaceHooks) AuthorizeRepo(s ssh.Session, op Operation, repo string) (AccessLevel, error) {
+	switch op {
+	case PushOperation:
+		if !strings.HasPrefix(repo, s.User()+"/") {
+			return NoAccess, fmt.Errorf("%s can't push to %s", s.User(), repo)
+		}
+		return ReadWriteAccess, nil
+	case FetchOperation:
+		return ReadOnlyAccess, nil
+	default:
+		return NoAccess, errors.New("archives are disabled")
+	}
+}
+
+func TestAuthHooks(t *testing.T) {
+	remote, pk := newTestServer(t, t.TempDir(), namespaceHooks{})
+	alice := strings.Replace(remote, "ssh://", "ssh://alice@", 1)
+
+	cwd := t.TempDir()
+	requireNoError(t, runGitHelper(t, pk, cwd, "init", "-b", "main"))
+	requireNoError(t, runGitHelper(t, pk, cwd, "commit", "--allow-empty", "-m", "initial commit"))
+
+	t.Run("push to own namespace", func(t *testing.T) {
+		requireNoError(t, runGitHelper(t, pk, cwd, "push", alice+"/alice/repo1", "main"))
+	})
+
+	t.Run("push to another namespace", func(t *testing.T) {
+		out, err := runGitOutput(t, pk, cwd, "push", alice+"/bob/repo1", "main")
+		requireError(t, err)
+		requireContains(t, out, "alice can't push to bob/repo1")
+	})
+
+	t.Run("fetch", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "repo1")
+		requireNoError(t, runGitHelper(t, pk, cwd, "clone", remote+"/alice/repo1", dir))
+	})
+
+	t.Run("archive", func(t *testing.T) {
+		out, err := runGitOutput(t, pk, cwd, "archive", "--remote", alice+"/alice/repo1", "main")
+		requireError(t, err)
+		requireContains(t, out, "archives are disabled")
+	})
+}
+
+func TestOperationString(t *testing.T) {
+	for op, want := range map[Operation]string{
+		FetchOperation:   "fetch",
+		PushOperation:    "push",
+		ArchiveOperation: "archive",
+		AdminOperation:   "admin",
+		Operation(-1):    "unknown",
+	} {
+		if got := op.String(); got != want {
+			t.Errorf("expected %q, got %q", want, got)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package git
+
+import (
+	"strings"
+
+	"charm.land/ssh"
+	"charm.land/wish/v2"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// Environment variables set for the git processes run by the ExecBackend, and
+// therefore for the hook scripts of the repos, e.g. hooks/pre-receive.
+const (
+	// EnvUser is the name of the SSH user.
+	EnvUser = "WISH_USER"
+
+	// EnvKeyFingerprint is the SHA256 fingerprint of the public key of the
+	// user, e.g. SHA256:uNiVztksCsDhcc0u9e8BujQXVUpKZIDTMczCvj3tD2s. It's
+	// empty if the user didn't authenticate with a key.
+	EnvKeyFingerprint = "WISH_KEY_FINGERPRINT"
+
+	// EnvRemoteAddr is the address of the client, e.g. 192.0.2.1:51234.
+	EnvRemoteAddr = "WISH_REMOTE_ADDR"
+
+	// EnvRepo is the name of the repo, as given by the RepoResolver.
+	EnvRepo = "WISH_REPO"
+
+	// EnvAccessLevel is the access level of the user to the repo, as given
+	// by AccessLevel.String, e.g. read-write.
+	EnvAccessLevel = "WISH_ACCESS_LEVEL"
+
+	// EnvGroups are the comma separated groups of the user, as given by
+	// wish.UserFromContext, e.g. with a wish.UserStore. It's empty if the
+	// user wasn't resolved by a wish.UserStore.
+	EnvGroups = "WISH_GROUPS"
+)
+
+// String implements fmt.Stringer.
+func (a AccessLevel) String() string {
+	switch a {
+	case NoAccess:
+		return "no-access"
+	case ReadOnlyAccess:
+		return "read-only"
+	case ReadWriteAccess:
+		return "read-write"
+	case AdminAccess:
+		return "admin"
+	}
+	return "unknown"
+}
+
+// hookEnv returns the environment variables describing the session.
+func hookEnv(s ssh.Session, repo string, access AccessLevel) []string {
+	var fingerprint string
+	if pk := s.PublicKey(); pk != nil {
+		fingerprint = gossh.FingerprintSHA256(pk)
+	}
+	var groups []string
+	if u, ok := wish.UserFromContext(s.Context()); ok {
+		groups = u.Groups
+	}
+	return []string{
+		EnvUser + "=" + s.User(),
+		EnvKeyFingerprint + "=" + fingerprint,
+		EnvRemoteAddr + "=" + s.RemoteAddr().String(),
+		EnvRepo + "=" + repo,
+		EnvAccessLevel + "=" + access.String(),
+		EnvGroups + "=" + strings.Join(groups, ","),
+	}
+}
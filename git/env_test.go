@@ -0,0 +1,64 @@
+package git
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestHookEnv(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hooks require a shell")
+	}
+	t.Run("receive hooks", func(t *testing.T) {
+		testHookEnv(t, &protectingHooks{}, "repo1")
+	})
+	t.Run("auth hooks", func(t *testing.T) {
+		testHookEnv(t, namespaceHooks{}, "wish/repo1")
+	})
+}
+
+func testHookEnv(t *testing.T, hooks Hooks, repo string) {
+	repoDir := t.TempDir()
+	remote, pk := newTestServer(t, repoDir, hooks)
+	requireNoError(t, EnsureRepo(repoDir, repo))
+	hooksDir := filepath.Join(repoDir, repo, "hooks")
+	requireNoError(t, os.MkdirAll(hooksDir, 0o700))
+	out := filepath.Join(t.TempDir(), "env")
+	script := "#!/bin/sh\nenv | grep ^WISH_ | grep -v ^WISH_GIT_ | sort > " + out + "\n"
+	requireNoError(t, os.WriteFile(filepath.Join(hooksDir, "pre-receive"), []byte(script), 0o700)) //nolint:gosec
+
+	cwd := t.TempDir()
+	requireNoError(t, runGitHelper(t, pk, cwd, "init", "-b", "main"))
+	requireNoError(t, runGitHelper(t, pk, cwd, "commit", "--allow-empty", "-m", "initial commit"))
+	requireNoError(t, runGitHelper(t, pk, cwd, "push", strings.Replace(remote, "ssh://", "ssh://wish@", 1)+"/"+repo, "main"))
+
+	env, err := os.ReadFile(out)
+	requireNoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(env)), "\n")
+	if len(lines) != 6 {
+		t.Fatalf("expected 6 variables, got %q", env)
+	}
+	requireContains(t, lines[0], EnvAccessLevel+"=read-write")
+	requireContains(t, lines[1], EnvGroups+"=")
+	requireContains(t, lines[2], EnvKeyFingerprint+"=SHA256:")
+	requireContains(t, lines[3], EnvRemoteAddr+"=127.0.0.1:")
+	requireContains(t, lines[4], EnvRepo+"="+repo)
+	requireContains(t, lines[5], EnvUser+"=wish")
+}
+
+func TestAccessLevelString(t *testing.T) {
+	for level, want := range map[AccessLevel]string{
+		NoAccess:        "no-access",
+		ReadOnlyAccess:  "read-only",
+		ReadWriteAccess: "read-write",
+		AdminAccess:     "admin",
+		AccessLevel(-1): "unknown",
+	} {
+		if got := level.String(); got != want {
+			t.Errorf("expected %q, got %q", want, got)
+		}
+	}
+}
@@ -54,6 +54,10 @@ type GitHooks = Hooks //nolint:revive
 // implementations and post push/fetch notifications. Prior to git access,
 // AuthRepo will be called with the ssh.Session public key and the repo name.
 // Implementers return the appropriate AccessLevel.
+//
+// Implementations may also implement AuthHooks to authorize requests with the
+// details of the session, and ReceiveHooks to accept or reject the refs
+// updated by a push.
 type Hooks interface {
 	AuthRepo(string, ssh.PublicKey) AccessLevel
 	Push(string, ssh.PublicKey)
@@ -61,54 +65,71 @@ type Hooks interface {
 }
 
 // Middleware adds Git server functionality to the ssh.Server. Repos are stored
-// in the specified repo directory. The provided Hooks implementation will be
-// checked for access on a per repo basis for a ssh.Session public key.
-// Hooks.Push and Hooks.Fetch will be called on successful completion of
-// their commands.
-func Middleware(repoDir string, gh Hooks) wish.Middleware {
+// in the specified repo directory, at the paths given by the RepoResolver
+// option. The provided Hooks implementation will be checked for access on a
+// per repo basis for a ssh.Session public key. Hooks.Push and Hooks.Fetch will
+// be called on successful completion of their commands. Options may be used
+// to configure how the commands are served.
+func Middleware(repoDir string, gh Hooks, opts ...Option) wish.Middleware {
+	o := newOptions(opts)
 	return func(sh ssh.Handler) ssh.Handler {
 		return func(s ssh.Session) {
 			cmd := s.Command()
-			if len(cmd) == 2 {
+			if o.lfs != nil && len(cmd) > 0 {
+				switch cmd[0] {
+				case "git-lfs-transfer":
+					if len(cmd) == 3 {
+						serveLFS(s, gh, o, repoDir, cmd[1:])
+						return
+					}
+				case "git-lfs-authenticate":
+					// git-lfs only falls back to it when git-lfs-transfer fails.
+					wish.Fatalln(s, "git-lfs-authenticate is not supported, use git-lfs-transfer instead")
+					return
+				}
+			}
+			if len(cmd) == 2 && isGitCommand(cmd[0]) {
 				gc := cmd[0]
-				// repo should be in the form of "repo.git" or "user/repo.git"
-				repo := strings.TrimSuffix(strings.TrimPrefix(cmd[1], "/"), "/")
-				repo = filepath.Clean(repo)
-				if n := strings.Count(repo, "/"); n > 1 {
-					Fatal(s, ErrInvalidRepo)
+				repo, rp, err := o.resolver.ResolveRepo(s, repoDir, cmd[1])
+				if err != nil {
+					fatalErr(s, err)
 					return
 				}
 				pk := s.PublicKey()
-				access := gh.AuthRepo(repo, pk)
+				access, err := authRepo(s, gh, operations[gc], repo)
+				if err != nil {
+					fatalErr(s, err)
+					return
+				}
 				switch gc {
 				case "git-receive-pack":
 					switch access {
 					case ReadWriteAccess, AdminAccess:
-						err := gitPack(s, gc, repoDir, repo)
+						if err := createRepo(s, o, repo, rp); err != nil {
+							fatalErr(s, err)
+							return
+						}
+						err := limitedPack(s, gh, o, gc, repo, rp, access)
 						if err != nil {
-							Fatal(s, ErrSystemMalfunction)
+							fatalPack(s, err)
 						} else {
 							gh.Push(repo, pk)
 						}
 					default:
-						Fatal(s, ErrNotAuthed)
+						fatalErr(s, ErrNotAuthed)
 					}
 					return
 				case "git-upload-archive", "git-upload-pack":
 					switch access {
 					case ReadOnlyAccess, ReadWriteAccess, AdminAccess:
-						err := gitPack(s, gc, repoDir, repo)
-						switch err {
-						case ErrInvalidRepo:
-							Fatal(s, ErrInvalidRepo)
-						case nil:
+						err := limitedPack(s, gh, o, gc, repo, rp, access)
+						if err != nil {
+							fatalPack(s, err)
+						} else {
 							gh.Fetch(repo, pk)
-						default:
-							log.Error("unknown git error", "error", err)
-							Fatal(s, ErrSystemMalfunction)
 						}
 					default:
-						Fatal(s, ErrNotAuthed)
+						fatalErr(s, ErrNotAuthed)
 					}
 					return
 				}
@@ -118,9 +139,45 @@ func Middleware(repoDir string, gh Hooks) wish.Middleware {
 	}
 }
 
-func gitPack(s ssh.Session, gitCmd string, repoDir string, repo string) error {
+// fatalPack reports the error of a git command to the client.
+func fatalPack(s ssh.Session, err error) {
+	switch {
+	case errors.Is(err, ErrTimeout), errors.Is(err, ErrPackTooLarge):
+		// already reported when the limit was exceeded.
+	case errors.Is(err, errReported):
+		// already sent to the client.
+	case errors.Is(err, ErrInvalidRepo), errors.Is(err, ErrTooBusy):
+		fatalErr(s, err)
+	default:
+		log.Error("unknown git error", "error", err)
+		fatalErr(s, ErrSystemMalfunction)
+	}
+}
+
+// createRepo creates the repo at rp if it doesn't exist and the create policy
+// allows it.
+func createRepo(s ssh.Session, o options, repo, rp string) error {
+	exists, err := fileExists(rp)
+	if err != nil {
+		log.Error("git repo", "repo", repo, "error", err)
+		return ErrSystemMalfunction
+	}
+	if exists {
+		return nil
+	}
+	if err := o.createPolicy(s, repo); err != nil {
+		return err
+	}
+	if err := EnsureRepo(filepath.Dir(rp), filepath.Base(rp)); err != nil {
+		log.Error("create git repo", "repo", repo, "error", err)
+		return ErrSystemMalfunction
+	}
+	return nil
+}
+
+func gitPack(s ssh.Session, gh Hooks, o options, gitCmd string, repo string, rp string, access AccessLevel) error {
 	cmd := strings.TrimPrefix(gitCmd, "git-")
-	rp := filepath.Join(repoDir, repo)
+	env := hookEnv(s, repo, access)
 	switch gitCmd {
 	case "git-upload-archive", "git-upload-pack":
 		exists, err := fileExists(rp)
@@ -130,22 +187,51 @@ func gitPack(s ssh.Session, gitCmd string, repoDir string, repo string) error {
 		if err != nil {
 			return err
 		}
-		return runGit(s, "", cmd, rp)
+		switch {
+		case o.backend == GoBackend && gitCmd == "git-upload-pack":
+			return goUploadPack(s, rp)
+		case o.backend == GoBackend:
+			return goUploadArchive(s, rp)
+		case gitCmd == "git-upload-pack":
+			// allows partial clones, e.g. git clone --filter=blob:none.
+			return runGit(s, env, "", "-c", "uploadpack.allowFilter=true", cmd, rp)
+		}
+		return runGit(s, env, "", cmd, rp)
 	case "git-receive-pack":
-		err := EnsureRepo(repoDir, repo)
+		var err error
+		rh, hasHooks := gh.(ReceiveHooks)
+		switch {
+		case o.backend == GoBackend:
+			err = goReceivePack(s, rp, repo, rh)
+		case hasHooks:
+			err = runReceivePack(s, env, rp, repo, rh)
+		default:
+			err = runGit(s, env, "", cmd, rp)
+		}
 		if err != nil {
 			return err
 		}
-		err = runGit(s, "", cmd, rp)
+		r, err := git.PlainOpen(rp)
 		if err != nil {
-			return err
+			return fmt.Errorf("open repo %s: %w", rp, err)
 		}
-		err = ensureDefaultBranch(s, rp)
+		err = ensureDefaultBranch(r)
 		if err != nil {
 			return err
 		}
 		// Needed for git dumb http server
-		return runGit(s, rp, "update-server-info")
+		if o.backend == GoBackend {
+			err = updateServerInfo(r, rp)
+		} else {
+			err = runGit(s, env, rp, "update-server-info")
+		}
+		if err != nil {
+			return err
+		}
+		if o.replicator != nil {
+			o.replicator.Replicate(repo, rp)
+		}
+		return nil
 	default:
 		return fmt.Errorf("unknown git command: %s", gitCmd)
 	}
@@ -171,6 +257,12 @@ func Fatal(s ssh.Session, v ...any) {
 	_ = s.Exit(1)
 }
 
+// fatalErr is like Fatal, sending the message as an ERR packet, which git
+// clients show as a remote error.
+func fatalErr(s ssh.Session, v ...any) {
+	Fatal(s, "ERR "+fmt.Sprint(v...))
+}
+
 // EnsureRepo makes sure the given repo exists within the given dir, and that
 // it is git repository.
 //
@@ -201,22 +293,54 @@ func EnsureRepo(dir, repo string) error {
 	return nil
 }
 
-func runGit(s ssh.Session, dir string, args ...string) error {
-	usi := exec.CommandContext(s.Context(), "git", args...)
-	usi.Dir = dir
-	usi.Stdout = s
-	usi.Stdin = s
+func runGit(s ssh.Session, env []string, dir string, args ...string) error {
+	usi := gitCommand(s, env, dir, args...)
 	if err := usi.Run(); err != nil {
 		return fmt.Errorf("git %v: %w", args, err)
 	}
 	return nil
 }
 
-func ensureDefaultBranch(s ssh.Session, repoPath string) error {
-	r, err := git.PlainOpen(repoPath)
-	if err != nil {
-		return fmt.Errorf("open repo %s: %w", repoPath, err)
+// gitCommand returns a git command reading from and writing to the session,
+// with the given variables added to its environment.
+//
+// The GIT_PROTOCOL variable sent by the client is passed along, so git can
+// negotiate protocol v2 with clients supporting it.
+func gitCommand(s ssh.Session, env []string, dir string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(s.Context(), "git", args...)
+	cmd.Dir = dir
+	cmd.Stdout = s
+	cmd.Stdin = s
+	cmd.Env = append(os.Environ(), env...)
+	if proto := gitProtocol(s); proto != "" {
+		cmd.Env = append(cmd.Env, "GIT_PROTOCOL="+proto)
+	}
+	return cmd
+}
+
+// gitProtocol returns the GIT_PROTOCOL value sent by the client, if valid.
+func gitProtocol(s ssh.Session) string {
+	var proto string
+	for _, env := range s.Environ() {
+		if v, ok := strings.CutPrefix(env, "GIT_PROTOCOL="); ok {
+			proto = v
+		}
+	}
+	// it's a colon separated list of key=value pairs, e.g. version=2.
+	for _, r := range proto {
+		if !isProtocolChar(r) {
+			return ""
+		}
 	}
+	return proto
+}
+
+func isProtocolChar(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' ||
+		strings.ContainsRune(":=.-_", r)
+}
+
+func ensureDefaultBranch(r *git.Repository) error {
 	brs, err := r.Branches()
 	if err != nil {
 		return fmt.Errorf("list branches: %w", err)
@@ -229,13 +353,14 @@ func ensureDefaultBranch(s ssh.Session, repoPath string) error {
 	// Rename the default branch to the first branch available
 	_, err = r.Head()
 	if err == plumbing.ErrReferenceNotFound {
-		err = runGit(s, repoPath, "branch", "-M", fb.Name().Short())
-		if err != nil {
-			return err
+		head := plumbing.NewSymbolicReference(plumbing.HEAD, fb.Name())
+		if err := r.Storer.SetReference(head); err != nil {
+			return fmt.Errorf("set HEAD: %w", err)
 		}
+		return nil
 	}
-	if err != nil && err != plumbing.ErrReferenceNotFound {
-		return err
+	if err != nil {
+		return fmt.Errorf("HEAD: %w", err)
 	}
 	return nil
 }
@@ -142,8 +142,43 @@ func TestGitMiddleware(t *testing.T) {
 	})
 }
 
+// newTestServer starts a git server using the given hooks, returning its
+// address and the path of a private key to access it.
+func newTestServer(t *testing.T, repoDir string, hooks Hooks, opts ...Option) (remote string, pkPath string) {
+	t.Helper()
+
+	_, pkPath = createKeyPair(t)
+	hkPath := filepath.Join(t.TempDir(), "id_ed25519")
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	requireNoError(t, err)
+
+	srv, err := wish.NewServer(
+		wish.WithHostKeyPath(hkPath),
+		wish.WithMiddleware(Middleware(repoDir, hooks, opts...)),
+		wish.WithPublicKeyAuth(func(ctx ssh.Context, key ssh.PublicKey) bool {
+			return true
+		}),
+	)
+	requireNoError(t, err)
+	go func() { srv.Serve(l) }()
+	t.Cleanup(func() { _ = srv.Close() })
+	return "ssh://" + l.Addr().String(), pkPath
+}
+
 func runGitHelper(t *testing.T, pk, cwd string, args ...string) error {
 	t.Helper()
+	_, err := runGitOutput(t, pk, cwd, args...)
+	return err
+}
+
+func runGitOutput(t *testing.T, pk, cwd string, args ...string) (string, error) {
+	t.Helper()
+	return runGitEnv(t, pk, cwd, nil, args...)
+}
+
+func runGitEnv(t *testing.T, pk, cwd string, env []string, args ...string) (string, error) {
+	t.Helper()
 
 	allArgs := []string{
 		"-c", "user.name='wish'",
@@ -158,11 +193,12 @@ func runGitHelper(t *testing.T, pk, cwd string, args ...string) error {
 	cmd := exec.Command("git", allArgs...)
 	cmd.Dir = cwd
 	cmd.Env = []string{fmt.Sprintf(`GIT_SSH_COMMAND=ssh -o UserKnownHostsFile=/dev/null -o StrictHostKeyChecking=no -i "%s" -F /dev/null`, pk)}
+	cmd.Env = append(cmd.Env, env...)
 	out, err := cmd.CombinedOutput()
 	if err != nil {
 		t.Log("git out:", string(out))
 	}
-	return err
+	return string(out), err
 }
 
 func requireNoError(t *testing.T, err error) {
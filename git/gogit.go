@@ -0,0 +1,582 @@
+package git
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"charm.land/ssh"
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/sideband"
+	"github.com/go-git/go-git/v5/plumbing/revlist"
+	"github.com/go-git/go-git/v5/plumbing/serverinfo"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/server"
+)
+
+// storerLoader is a server.Loader always returning the same storer.
+type storerLoader struct{ storer.Storer }
+
+func (l storerLoader) Load(*transport.Endpoint) (storer.Storer, error) { return l.Storer, nil }
+
+func newGoServer(rp string) (*git.Repository, transport.Transport, error) {
+	r, err := git.PlainOpen(rp)
+	if err != nil {
+		return nil, nil, fmt.Errorf("open repo %s: %w", rp, err)
+	}
+	return r, server.NewServer(storerLoader{r.Storer}), nil
+}
+
+// goUploadPack serves git-upload-pack with go-git.
+//
+// The negotiation is done here rather than by go-git, which doesn't reply to
+// the client between batches of haves.
+func goUploadPack(s ssh.Session, rp string) error {
+	r, srv, err := newGoServer(rp)
+	if err != nil {
+		return err
+	}
+	sess, err := srv.NewUploadPackSession(&transport.Endpoint{}, nil)
+	if err != nil {
+		return fmt.Errorf("upload-pack: %w", err)
+	}
+	ar, err := sess.AdvertisedReferences()
+	if err != nil {
+		return fmt.Errorf("upload-pack: %w", err)
+	}
+	if err := ar.Encode(s); err != nil {
+		return fmt.Errorf("upload-pack: %w", err)
+	}
+
+	sc := pktline.NewScanner(s)
+	var wants []plumbing.Hash
+	for sc.Scan() {
+		line := string(bytes.TrimSuffix(sc.Bytes(), []byte("\n")))
+		if line == "" {
+			break
+		}
+		switch {
+		case strings.HasPrefix(line, "want "):
+			fields := strings.Fields(line)
+			wants = append(wants, plumbing.NewHash(fields[1]))
+		case strings.HasPrefix(line, "shallow "), strings.HasPrefix(line, "deepen"):
+			return errors.New("upload-pack: shallow clones are not supported")
+		case strings.HasPrefix(line, "filter "):
+			return errors.New("upload-pack: partial clones are not supported")
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("upload-pack: %w", err)
+	}
+	if len(wants) == 0 {
+		// the client only wanted the refs, e.g. git ls-remote.
+		return nil
+	}
+
+	// without multi_ack, only the first common have is acked, and the
+	// client is only answered NAK until there is one.
+	enc := pktline.NewEncoder(s)
+	var haves []plumbing.Hash
+	for sc.Scan() {
+		line := string(bytes.TrimSuffix(sc.Bytes(), []byte("\n")))
+		switch {
+		case line == "":
+			if len(haves) > 0 {
+				continue
+			}
+			if err := enc.EncodeString("NAK\n"); err != nil {
+				return fmt.Errorf("upload-pack: %w", err)
+			}
+		case strings.HasPrefix(line, "have "):
+			h := plumbing.NewHash(strings.TrimPrefix(line, "have "))
+			if r.Storer.HasEncodedObject(h) != nil {
+				continue
+			}
+			haves = append(haves, h)
+			if len(haves) > 1 {
+				continue
+			}
+			if err := enc.EncodeString("ACK " + h.String() + "\n"); err != nil {
+				return fmt.Errorf("upload-pack: %w", err)
+			}
+		case line == "done":
+			if len(haves) == 0 {
+				if err := enc.EncodeString("NAK\n"); err != nil {
+					return fmt.Errorf("upload-pack: %w", err)
+				}
+			}
+			return sendPack(s, r.Storer, wants, haves)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("upload-pack: %w", err)
+	}
+	return nil
+}
+
+func sendPack(w io.Writer, sto storer.Storer, wants, haves []plumbing.Hash) error {
+	common, err := revlist.Objects(sto, haves, nil)
+	if err != nil {
+		return fmt.Errorf("upload-pack: %w", err)
+	}
+	objs, err := revlist.Objects(sto, wants, common)
+	if err != nil {
+		return fmt.Errorf("upload-pack: %w", err)
+	}
+	if _, err := packfile.NewEncoder(w, sto, false).Encode(objs, 10); err != nil {
+		return fmt.Errorf("upload-pack: %w", err)
+	}
+	return nil
+}
+
+// goReceivePack serves git-receive-pack with go-git, calling the given
+// ReceiveHooks, if any.
+func goReceivePack(s ssh.Session, rp, repo string, rh ReceiveHooks) error {
+	r, srv, err := newGoServer(rp)
+	if err != nil {
+		return err
+	}
+	sess, err := srv.NewReceivePackSession(&transport.Endpoint{}, nil)
+	if err != nil {
+		return fmt.Errorf("receive-pack: %w", err)
+	}
+	ar, err := sess.AdvertisedReferences()
+	if err != nil {
+		return fmt.Errorf("receive-pack: %w", err)
+	}
+	if err := ar.Encode(s); err != nil {
+		return fmt.Errorf("receive-pack: %w", err)
+	}
+
+	req := packp.NewReferenceUpdateRequest()
+	if err := req.Decode(s); err != nil {
+		if errors.Is(err, packp.ErrEmpty) {
+			// nothing to push.
+			return nil
+		}
+		return fmt.Errorf("receive-pack: %w", err)
+	}
+
+	// the objects are stored before the refs are checked, so the hooks can
+	// look at them.
+	rs := packp.NewReportStatus()
+	rs.UnpackStatus = "ok"
+	if hasPack(req) {
+		// the pack is read from the session, which must not be closed.
+		err := packfile.UpdateObjectStorage(r.Storer, req.Packfile)
+		if err != nil {
+			rs.UnpackStatus = err.Error()
+			for _, cmd := range req.Commands {
+				rs.CommandStatuses = append(rs.CommandStatuses, &packp.CommandStatus{
+					ReferenceName: cmd.Name,
+					Status:        "unpacker error",
+				})
+			}
+			return reportStatus(s, req, rs, err)
+		}
+	}
+	req.Packfile = nil
+
+	updates := make([]RefUpdate, 0, len(req.Commands))
+	for _, cmd := range req.Commands {
+		updates = append(updates, RefUpdate{
+			Ref:    cmd.Name,
+			Old:    cmd.Old,
+			New:    cmd.New,
+			Forced: isForced(r, cmd.Old, cmd.New),
+		})
+	}
+
+	rejected := map[plumbing.ReferenceName]string{}
+	if rh != nil {
+		if err := rh.PreReceive(s, repo, updates); err != nil {
+			for _, u := range updates {
+				rejected[u.Ref] = err.Error()
+			}
+		}
+	}
+	cmds := req.Commands
+	var accepted []RefUpdate
+	var acceptedCmds []*packp.Command
+	for i, cmd := range cmds {
+		if _, ok := rejected[cmd.Name]; ok {
+			continue
+		}
+		if !isCurrent(r, cmd.Name, cmd.Old) {
+			rejected[cmd.Name] = "stale info"
+			continue
+		}
+		if rh != nil {
+			if err := rh.Update(s, repo, updates[i]); err != nil {
+				rejected[cmd.Name] = err.Error()
+				continue
+			}
+		}
+		acceptedCmds = append(acceptedCmds, cmd)
+		accepted = append(accepted, updates[i])
+	}
+
+	var rerr error
+	statuses := map[plumbing.ReferenceName]string{}
+	if err := s.Context().Err(); err != nil {
+		// the hooks were interrupted, e.g. by the timeout.
+		return fmt.Errorf("receive-pack: %w", err)
+	}
+	if len(acceptedCmds) > 0 {
+		req.Commands = acceptedCmds
+		res, err := sess.ReceivePack(s.Context(), req)
+		if res == nil {
+			// failed before updating any ref, e.g. unsupported capabilities.
+			rerr = err
+		} else {
+			for _, cs := range res.CommandStatuses {
+				statuses[cs.ReferenceName] = cs.Status
+			}
+		}
+	}
+	for _, cmd := range cmds {
+		status, ok := rejected[cmd.Name]
+		if !ok {
+			status = statuses[cmd.Name]
+		}
+		rs.CommandStatuses = append(rs.CommandStatuses, &packp.CommandStatus{
+			ReferenceName: cmd.Name,
+			Status:        strings.ReplaceAll(status, "\n", " "),
+		})
+	}
+	if err := reportStatus(s, req, rs, nil); err != nil {
+		return err
+	}
+	if rerr != nil {
+		return fmt.Errorf("receive-pack: %w", rerr)
+	}
+	if rh == nil {
+		return nil
+	}
+	var applied []RefUpdate
+	for _, u := range accepted {
+		if isCurrent(r, u.Ref, u.New) {
+			applied = append(applied, u)
+		}
+	}
+	if len(applied) > 0 {
+		rh.PostReceive(s, repo, applied)
+	}
+	return nil
+}
+
+// hasPack reports whether the client sends a pack after the commands, which
+// it doesn't if it only deletes refs.
+func hasPack(req *packp.ReferenceUpdateRequest) bool {
+	for _, cmd := range req.Commands {
+		if !cmd.New.IsZero() {
+			return true
+		}
+	}
+	return false
+}
+
+// reportStatus sends the report status to the client, if it asked for it.
+func reportStatus(w io.Writer, req *packp.ReferenceUpdateRequest, rs *packp.ReportStatus, err error) error {
+	if req.Capabilities.Supports(capability.ReportStatus) {
+		if eerr := rs.Encode(w); eerr != nil {
+			return fmt.Errorf("receive-pack: %w", eerr)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("receive-pack: %w", err)
+	}
+	return nil
+}
+
+// isCurrent reports whether the given ref currently points to the given
+// hash, which is zero for refs that don't exist.
+func isCurrent(r *git.Repository, name plumbing.ReferenceName, h plumbing.Hash) bool {
+	ref, err := r.Storer.Reference(name)
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return h.IsZero()
+	}
+	return err == nil && ref.Hash() == h
+}
+
+// isForced reports whether updating a ref from old to new is not a
+// fast-forward.
+func isForced(r *git.Repository, old, new plumbing.Hash) bool {
+	if old.IsZero() || new.IsZero() {
+		return false
+	}
+	oc, err := r.CommitObject(old)
+	if err != nil {
+		return false
+	}
+	nc, err := r.CommitObject(new)
+	if err != nil {
+		return false
+	}
+	ok, err := oc.IsAncestor(nc)
+	return err == nil && !ok
+}
+
+// errReported wraps the errors already sent to the client, which aren't
+// reported again.
+var errReported = errors.New("reported to the client")
+
+// goUploadArchive serves git-upload-archive with go-git. Only the tar and
+// zip formats are supported.
+func goUploadArchive(s ssh.Session, rp string) error {
+	r, err := git.PlainOpen(rp)
+	if err != nil {
+		return fmt.Errorf("open repo %s: %w", rp, err)
+	}
+
+	var args []string
+	sc := pktline.NewScanner(s)
+	for sc.Scan() {
+		line := string(bytes.TrimSuffix(sc.Bytes(), []byte("\n")))
+		if line == "" {
+			break
+		}
+		if arg, ok := strings.CutPrefix(line, "argument "); ok {
+			args = append(args, arg)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("upload-archive: %w", err)
+	}
+
+	enc := pktline.NewEncoder(s)
+	a, err := parseArchiveArgs(r, args)
+	if err != nil {
+		_ = enc.EncodeString("NACK " + err.Error() + "\n")
+		return fmt.Errorf("upload-archive: %w: %w", errReported, err)
+	}
+	if err := enc.EncodeString("ACK\n"); err != nil {
+		return fmt.Errorf("upload-archive: %w", err)
+	}
+	if err := enc.Flush(); err != nil {
+		return fmt.Errorf("upload-archive: %w", err)
+	}
+
+	mux := sideband.NewMuxer(sideband.Sideband64k, s)
+	if err := a.write(r, mux); err != nil {
+		_, _ = mux.WriteChannel(sideband.ErrorMessage, []byte(err.Error()))
+		return fmt.Errorf("upload-archive: %w: %w", errReported, err)
+	}
+	if err := enc.Flush(); err != nil {
+		return fmt.Errorf("upload-archive: %w", err)
+	}
+	return nil
+}
+
+type archive struct {
+	format string
+	prefix string
+	tree   *object.Tree
+	mtime  time.Time
+	paths  []string
+}
+
+func parseArchiveArgs(r *git.Repository, args []string) (*archive, error) {
+	a := &archive{format: "tar"}
+	var rev string
+	for _, arg := range args {
+		switch {
+		case strings.HasPrefix(arg, "--format="):
+			a.format = strings.TrimPrefix(arg, "--format=")
+		case strings.HasPrefix(arg, "--prefix="):
+			a.prefix = strings.TrimPrefix(arg, "--prefix=")
+		case strings.HasPrefix(arg, "-"):
+			// ignores other options, e.g. compression levels.
+		case rev == "":
+			rev = arg
+		default:
+			a.paths = append(a.paths, strings.TrimSuffix(arg, "/"))
+		}
+	}
+	if a.format != "tar" && a.format != "zip" {
+		return nil, fmt.Errorf("unsupported archive format: %q", a.format)
+	}
+	if rev == "" {
+		return nil, errors.New("missing tree-ish")
+	}
+
+	h, err := r.ResolveRevision(plumbing.Revision(rev))
+	if err != nil {
+		return nil, fmt.Errorf("not a valid object name: %q", rev)
+	}
+	a.mtime = time.Now()
+	if c, err := r.CommitObject(*h); err == nil {
+		a.mtime = c.Committer.When
+		a.tree, err = c.Tree()
+		if err != nil {
+			return nil, fmt.Errorf("tree: %w", err)
+		}
+		return a, nil
+	}
+	a.tree, err = r.TreeObject(*h)
+	if err != nil {
+		return nil, fmt.Errorf("not a tree object: %q", rev)
+	}
+	return a, nil
+}
+
+func (a *archive) includes(name string) bool {
+	if len(a.paths) == 0 {
+		return true
+	}
+	for _, p := range a.paths {
+		if name == p || strings.HasPrefix(name, p+"/") || strings.HasPrefix(p, name+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *archive) write(r *git.Repository, w io.Writer) error {
+	var tw *tar.Writer
+	var zw *zip.Writer
+	if a.format == "zip" {
+		zw = zip.NewWriter(w)
+	} else {
+		tw = tar.NewWriter(w)
+	}
+
+	writeEntry := func(name string, mode filemode.FileMode, content []byte) error {
+		if zw != nil {
+			return a.writeZip(zw, name, mode, content)
+		}
+		return a.writeTar(tw, name, mode, content)
+	}
+
+	// like git, the prefix is prepended as is, and gets its own entry if it's
+	// a directory.
+	if dir, ok := strings.CutSuffix(a.prefix, "/"); ok && dir != "" {
+		if err := writeEntry(dir, filemode.Dir, nil); err != nil {
+			return err
+		}
+	}
+
+	walker := object.NewTreeWalker(a.tree, true, nil)
+	defer walker.Close()
+	for {
+		name, entry, err := walker.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("walk tree: %w", err)
+		}
+		if !a.includes(name) || entry.Mode == filemode.Submodule {
+			continue
+		}
+		name = a.prefix + name
+
+		var content []byte
+		if entry.Mode != filemode.Dir {
+			blob, err := r.BlobObject(entry.Hash)
+			if err != nil {
+				return fmt.Errorf("blob %s: %w", name, err)
+			}
+			rd, err := blob.Reader()
+			if err != nil {
+				return fmt.Errorf("blob %s: %w", name, err)
+			}
+			content, err = io.ReadAll(rd)
+			_ = rd.Close()
+			if err != nil {
+				return fmt.Errorf("blob %s: %w", name, err)
+			}
+		}
+
+		if err := writeEntry(name, entry.Mode, content); err != nil {
+			return err
+		}
+	}
+
+	if zw != nil {
+		return zw.Close() //nolint:wrapcheck
+	}
+	return tw.Close() //nolint:wrapcheck
+}
+
+func (a *archive) writeTar(tw *tar.Writer, name string, mode filemode.FileMode, content []byte) error {
+	hdr := &tar.Header{
+		Name:    name,
+		ModTime: a.mtime,
+		Mode:    0o644,
+		Size:    int64(len(content)),
+	}
+	switch mode {
+	case filemode.Dir:
+		hdr.Typeflag = tar.TypeDir
+		hdr.Name += "/"
+		hdr.Mode = 0o755
+		hdr.Size = 0
+	case filemode.Symlink:
+		hdr.Typeflag = tar.TypeSymlink
+		hdr.Linkname = string(content)
+		hdr.Mode = 0o777
+		hdr.Size = 0
+	case filemode.Executable:
+		hdr.Mode = 0o755
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("write archive: %w", err)
+	}
+	if hdr.Size > 0 {
+		if _, err := tw.Write(content); err != nil {
+			return fmt.Errorf("write archive: %w", err)
+		}
+	}
+	return nil
+}
+
+func (a *archive) writeZip(zw *zip.Writer, name string, mode filemode.FileMode, content []byte) error {
+	hdr := &zip.FileHeader{
+		Name:     name,
+		Modified: a.mtime,
+		Method:   zip.Deflate,
+	}
+	switch mode {
+	case filemode.Dir:
+		hdr.Name += "/"
+		hdr.Method = zip.Store
+		hdr.SetMode(0o755 | 1<<31) // os.ModeDir
+	case filemode.Symlink:
+		hdr.SetMode(0o777 | 1<<27) // os.ModeSymlink
+	case filemode.Executable:
+		hdr.SetMode(0o755)
+	default:
+		hdr.SetMode(0o644)
+	}
+	fw, err := zw.CreateHeader(hdr)
+	if err != nil {
+		return fmt.Errorf("write archive: %w", err)
+	}
+	if _, err := fw.Write(content); err != nil {
+		return fmt.Errorf("write archive: %w", err)
+	}
+	return nil
+}
+
+// updateServerInfo is the go-git equivalent of git update-server-info.
+func updateServerInfo(r *git.Repository, rp string) error {
+	if err := serverinfo.UpdateServerInfo(r.Storer, osfs.New(rp)); err != nil {
+		return fmt.Errorf("update server info: %w", err)
+	}
+	return nil
+}
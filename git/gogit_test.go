@@ -0,0 +1,169 @@
+package git
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
+)
+
+func TestBackends(t *testing.T) {
+	for name, backend := range map[string]Backend{
+		"exec": ExecBackend,
+		"go":   GoBackend,
+	} {
+		t.Run(name, func(t *testing.T) {
+			testBackend(t, backend)
+		})
+	}
+}
+
+func testBackend(t *testing.T, backend Backend) {
+	repoDir := t.TempDir()
+	remote, pk := newTestServer(t, repoDir, &protectingHooks{}, WithBackend(backend))
+
+	cwd := t.TempDir()
+	requireNoError(t, runGitHelper(t, pk, cwd, "init", "-b", "main"))
+	requireNoError(t, runGitHelper(t, pk, cwd, "remote", "add", "origin", remote+"/repo1"))
+	requireNoError(t, os.MkdirAll(filepath.Join(cwd, "dir"), 0o755))
+	requireNoError(t, os.WriteFile(filepath.Join(cwd, "README"), []byte("hello\n"), 0o644))       //nolint:gosec
+	requireNoError(t, os.WriteFile(filepath.Join(cwd, "dir", "run.sh"), []byte("echo\n"), 0o755)) //nolint:gosec
+	requireNoError(t, runGitHelper(t, pk, cwd, "add", "."))
+	requireNoError(t, runGitHelper(t, pk, cwd, "commit", "-m", "initial commit"))
+
+	t.Run("push", func(t *testing.T) {
+		requireNoError(t, runGitHelper(t, pk, cwd, "push", "origin", "main"))
+	})
+
+	clone := filepath.Join(t.TempDir(), "clone")
+	t.Run("clone", func(t *testing.T) {
+		requireNoError(t, runGitHelper(t, pk, filepath.Dir(clone), "clone", remote+"/repo1", clone))
+		bts, err := os.ReadFile(filepath.Join(clone, "dir", "run.sh"))
+		requireNoError(t, err)
+		if string(bts) != "echo\n" {
+			t.Fatalf("unexpected content: %q", bts)
+		}
+		requireSameRev(t, pk, cwd, clone, "HEAD")
+	})
+
+	t.Run("fetch", func(t *testing.T) {
+		requireNoError(t, os.WriteFile(filepath.Join(cwd, "README"), []byte("hello again\n"), 0o644)) //nolint:gosec
+		requireNoError(t, runGitHelper(t, pk, cwd, "commit", "-am", "second commit"))
+		requireNoError(t, runGitHelper(t, pk, cwd, "push", "origin", "main"))
+		out, err := runGitEnv(t, pk, clone, []string{"GIT_TRACE_PACKET=1"}, "fetch", "origin")
+		requireNoError(t, err)
+		// the first commit is common.
+		requireContains(t, out, "< ACK ")
+		requireSameRev(t, pk, cwd, clone, "origin/main")
+	})
+
+	t.Run("ls-remote", func(t *testing.T) {
+		requireNoError(t, runGitHelper(t, pk, cwd, "tag", "v1"))
+		requireNoError(t, runGitHelper(t, pk, cwd, "push", "origin", "v1"))
+		out, err := runGitOutput(t, pk, cwd, "ls-remote", "origin")
+		requireNoError(t, err)
+		requireContains(t, out, "\tHEAD")
+		requireContains(t, out, "\trefs/heads/main")
+		requireContains(t, out, "\trefs/tags/v1")
+	})
+
+	t.Run("archive", func(t *testing.T) {
+		out := filepath.Join(t.TempDir(), "archive.tar")
+		requireNoError(t, runGitHelper(t, pk, cwd, "archive", "--remote", "origin", "-o", out, "main"))
+		requireEntries(t, tarEntries(t, out), "README", "dir/", "dir/run.sh")
+	})
+
+	t.Run("archive zip with prefix and path", func(t *testing.T) {
+		out := filepath.Join(t.TempDir(), "archive.zip")
+		requireNoError(t, runGitHelper(t, pk, cwd, "archive", "--remote", "origin", "--format=zip", "--prefix=p/", "-o", out, "v1", "dir"))
+		requireEntries(t, zipEntries(t, out), "p/", "p/dir/", "p/dir/run.sh")
+	})
+
+	t.Run("archive unknown rev", func(t *testing.T) {
+		out := filepath.Join(t.TempDir(), "archive.tar")
+		requireError(t, runGitHelper(t, pk, cwd, "archive", "--remote", "origin", "-o", out, "nope"))
+
+		if backend != GoBackend {
+			return
+		}
+		// git stops reading at the NACK, so check that nothing follows it.
+		var in bytes.Buffer
+		enc := pktline.NewEncoder(&in)
+		requireNoError(t, enc.EncodeString("argument nope\n"))
+		requireNoError(t, enc.Flush())
+		host, port, err := net.SplitHostPort(strings.TrimPrefix(remote, "ssh://"))
+		requireNoError(t, err)
+		cmd := exec.Command("ssh", "-o", "UserKnownHostsFile=/dev/null", "-o", "StrictHostKeyChecking=no",
+			"-i", pk, "-F", "/dev/null", "-p", port, host, "git-upload-archive 'repo1'")
+		cmd.Stdin = &in
+		res, _ := cmd.Output()
+		if !strings.Contains(string(res), "NACK ") || strings.Contains(string(res), "ERR ") {
+			t.Fatalf("expected only a NACK, got %q", res)
+		}
+	})
+}
+
+func requireSameRev(t *testing.T, pk, a, b, rev string) {
+	t.Helper()
+	ra, err := runGitOutput(t, pk, a, "rev-parse", "main")
+	requireNoError(t, err)
+	rb, err := runGitOutput(t, pk, b, "rev-parse", rev)
+	requireNoError(t, err)
+	if ra != rb {
+		t.Fatalf("expected %s to be %q, got %q", rev, ra, rb)
+	}
+}
+
+func requireEntries(t *testing.T, got []string, want ...string) {
+	t.Helper()
+	sort.Strings(got)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected entries %q, got %q", want, got)
+	}
+}
+
+func tarEntries(t *testing.T, path string) []string {
+	t.Helper()
+	f, err := os.Open(path)
+	requireNoError(t, err)
+	defer f.Close() //nolint:errcheck
+
+	var names []string
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return names
+		}
+		requireNoError(t, err)
+		// git adds a global header with the commit id.
+		if hdr.Typeflag == tar.TypeXGlobalHeader {
+			continue
+		}
+		names = append(names, hdr.Name)
+	}
+}
+
+func zipEntries(t *testing.T, path string) []string {
+	t.Helper()
+	zr, err := zip.OpenReader(path)
+	requireNoError(t, err)
+	defer zr.Close() //nolint:errcheck
+
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, strings.TrimPrefix(f.Name, "./"))
+	}
+	return names
+}
@@ -0,0 +1,558 @@
+package git
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"charm.land/log/v2"
+	"charm.land/ssh"
+	"charm.land/wish/v2"
+)
+
+// ErrLFSMismatch is returned by the reader given to LFSStore.Put when the
+// uploaded data doesn't match the object's id or size.
+var ErrLFSMismatch = errors.New("object doesn't match its id or size")
+
+// LFSStore stores the git LFS objects of the repos, identified by their
+// SHA-256 ids.
+type LFSStore interface {
+	// Stat returns the size of the object, or an error matching
+	// fs.ErrNotExist if it isn't stored.
+	Stat(repo, oid string) (int64, error)
+
+	// Get returns a reader for the object's data.
+	Get(repo, oid string) (io.ReadCloser, error)
+
+	// Put stores the object read from r. Reading fails if the data doesn't
+	// match the object, in which case it must not be stored.
+	Put(repo, oid string, r io.Reader) error
+}
+
+// LocalLFSStore is a LFSStore keeping the objects in a local directory, laid
+// out like git-lfs does, e.g. <dir>/<repo>/ab/cd/abcd….
+type LocalLFSStore struct {
+	Dir string
+}
+
+var _ LFSStore = LocalLFSStore{}
+
+func (l LocalLFSStore) path(repo, oid string) string {
+	return filepath.Join(l.Dir, filepath.FromSlash(repo), oid[0:2], oid[2:4], oid)
+}
+
+// Stat implements LFSStore.
+func (l LocalLFSStore) Stat(repo, oid string) (int64, error) {
+	fi, err := os.Stat(l.path(repo, oid))
+	if err != nil {
+		return 0, fmt.Errorf("stat lfs object: %w", err)
+	}
+	return fi.Size(), nil
+}
+
+// Get implements LFSStore.
+func (l LocalLFSStore) Get(repo, oid string) (io.ReadCloser, error) {
+	f, err := os.Open(l.path(repo, oid))
+	if err != nil {
+		return nil, fmt.Errorf("open lfs object: %w", err)
+	}
+	return f, nil
+}
+
+// Put implements LFSStore.
+func (l LocalLFSStore) Put(repo, oid string, r io.Reader) error {
+	p := l.path(repo, oid)
+	if err := os.MkdirAll(filepath.Dir(p), 0o700); err != nil {
+		return fmt.Errorf("create lfs dir: %w", err)
+	}
+	// writes to a temporary file first, so incomplete objects are never seen.
+	f, err := os.CreateTemp(filepath.Dir(p), "incomplete-*")
+	if err != nil {
+		return fmt.Errorf("create lfs object: %w", err)
+	}
+	defer os.Remove(f.Name()) //nolint:errcheck
+	if _, err := io.Copy(f, r); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("write lfs object: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("write lfs object: %w", err)
+	}
+	if err := os.Rename(f.Name(), p); err != nil {
+		return fmt.Errorf("write lfs object: %w", err)
+	}
+	return nil
+}
+
+// serveLFS serves git-lfs-transfer, the pure SSH protocol of git LFS.
+//
+// See https://github.com/git-lfs/git-lfs/blob/main/docs/proposals/ssh_adapter.md
+func serveLFS(s ssh.Session, gh Hooks, o options, repoDir string, args []string) {
+	repo, rp, err := o.resolver.ResolveRepo(s, repoDir, args[0])
+	if err != nil {
+		wish.Fatalln(s, err)
+		return
+	}
+	op := FetchOperation
+	switch args[1] {
+	case "download":
+	case "upload":
+		op = PushOperation
+	default:
+		wish.Fatalln(s, fmt.Sprintf("invalid operation: %q", args[1]))
+		return
+	}
+
+	access, err := authRepo(s, gh, op, repo)
+	if err != nil {
+		wish.Fatalln(s, err)
+		return
+	}
+	if access < ReadOnlyAccess || op == PushOperation && access < ReadWriteAccess {
+		wish.Fatalln(s, ErrNotAuthed)
+		return
+	}
+	exists, err := fileExists(rp)
+	if err != nil {
+		log.Error("git lfs", "repo", repo, "error", err)
+		wish.Fatalln(s, ErrSystemMalfunction)
+		return
+	}
+	// objects are uploaded before the push creating the repo.
+	if !exists && op == PushOperation {
+		err = o.createPolicy(s, repo)
+	} else if !exists {
+		err = ErrInvalidRepo
+	}
+	if err != nil {
+		wish.Fatalln(s, err)
+		return
+	}
+
+	err = limited(s, o, repo, 0, reportLFS, func(s ssh.Session) error {
+		t := &lfsTransfer{
+			pkt:    lfsConn{r: bufio.NewReader(s), w: s},
+			store:  o.lfs,
+			repo:   repo,
+			upload: op == PushOperation,
+		}
+		return t.serve()
+	})
+	switch {
+	case errors.Is(err, ErrTimeout):
+		// already reported when the limit was exceeded.
+	case errors.Is(err, ErrTooBusy):
+		wish.Fatalln(s, err)
+	case err != nil:
+		log.Error("git lfs", "repo", repo, "error", err)
+		_ = s.Exit(1)
+	default:
+		_ = s.Exit(0)
+	}
+}
+
+// reportLFS reports the exceeded limit of a git-lfs-transfer on STDERR, like
+// its other fatal errors.
+func reportLFS(s *limitedSession, err error) {
+	wish.Fatalln(s.Session, err)
+}
+
+type lfsTransfer struct {
+	pkt    lfsConn
+	store  LFSStore
+	repo   string
+	upload bool
+}
+
+// lfsRequest is a request of the git-lfs-transfer protocol: a command,
+// arguments, and data following a delimiter packet if hasData is set.
+type lfsRequest struct {
+	command string
+	args    map[string]string
+	hasData bool
+}
+
+func (t *lfsTransfer) serve() error {
+	if err := t.pkt.writeLines("version=1"); err != nil {
+		return err
+	}
+	if err := t.pkt.flush(); err != nil {
+		return err
+	}
+	for {
+		req, err := t.pkt.readRequest()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name, arg, _ := strings.Cut(req.command, " ")
+		switch {
+		case name == "version":
+			if arg != "1" {
+				err = t.fail(req, 400, "unsupported version: "+arg)
+				break
+			}
+			err = t.pkt.status(200)
+		case name == "batch":
+			err = t.batch(req)
+		case name == "put-object" && t.upload:
+			err = t.putObject(req, arg)
+		case name == "verify-object" && t.upload:
+			err = t.verifyObject(req, arg)
+		case name == "get-object" && !t.upload:
+			err = t.getObject(req, arg)
+		case name == "quit":
+			return t.pkt.status(200)
+		case name == "lock", name == "list-lock", name == "unlock":
+			err = t.fail(req, 501, "locking is not supported")
+		default:
+			err = t.fail(req, 400, "unsupported command: "+name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// fail skips the request's data, if any, and sends an error status.
+func (t *lfsTransfer) fail(req lfsRequest, code int, msg string) error {
+	if req.hasData {
+		if _, err := io.Copy(io.Discard, t.pkt.dataReader()); err != nil {
+			return err
+		}
+	}
+	return t.pkt.error(code, msg)
+}
+
+func (t *lfsTransfer) batch(req lfsRequest) error {
+	if algo, ok := req.args["hash-algo"]; ok && algo != "sha256" {
+		return t.fail(req, 400, "unsupported hash algorithm: "+algo)
+	}
+	var lines []string
+	if req.hasData {
+		var err error
+		if lines, err = t.pkt.readLines(); err != nil {
+			return err
+		}
+	}
+
+	resp := make([]string, 0, len(lines))
+	for _, line := range lines {
+		oid, sizeStr, _ := strings.Cut(line, " ")
+		size, err := strconv.ParseInt(sizeStr, 10, 64)
+		if !isOid(oid) || err != nil || size < 0 {
+			return t.pkt.error(400, "invalid object: "+line)
+		}
+		stored, err := t.store.Stat(t.repo, oid)
+		exists := err == nil && stored == size
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Error("git lfs", "repo", t.repo, "oid", oid, "error", err)
+			return t.pkt.error(500, ErrSystemMalfunction.Error())
+		}
+		action := "noop"
+		switch {
+		case t.upload && !exists:
+			action = "upload"
+		case !t.upload && exists:
+			action = "download"
+		}
+		resp = append(resp, fmt.Sprintf("%s %d %s", oid, size, action))
+	}
+
+	if err := t.pkt.writeLines("status 200"); err != nil {
+		return err
+	}
+	if err := t.pkt.delim(); err != nil {
+		return err
+	}
+	if err := t.pkt.writeLines(resp...); err != nil {
+		return err
+	}
+	return t.pkt.flush()
+}
+
+func (t *lfsTransfer) putObject(req lfsRequest, oid string) error {
+	size, err := strconv.ParseInt(req.args["size"], 10, 64)
+	if !isOid(oid) || err != nil || size < 0 || !req.hasData {
+		return t.fail(req, 400, "invalid object: "+oid)
+	}
+	data := t.pkt.dataReader()
+	err = t.store.Put(t.repo, oid, &lfsVerifier{r: data, h: sha256.New(), oid: oid, size: size})
+	// keeps the stream in sync if the store stopped reading early.
+	if _, derr := io.Copy(io.Discard, data); derr != nil {
+		return derr
+	}
+	switch {
+	case errors.Is(err, ErrLFSMismatch):
+		return t.pkt.error(400, err.Error())
+	case err != nil:
+		log.Error("git lfs", "repo", t.repo, "oid", oid, "error", err)
+		return t.pkt.error(500, ErrSystemMalfunction.Error())
+	}
+	return t.pkt.status(200)
+}
+
+func (t *lfsTransfer) verifyObject(req lfsRequest, oid string) error {
+	size, err := strconv.ParseInt(req.args["size"], 10, 64)
+	if !isOid(oid) || err != nil {
+		return t.fail(req, 400, "invalid object: "+oid)
+	}
+	stored, err := t.store.Stat(t.repo, oid)
+	if err != nil || stored != size {
+		return t.fail(req, 404, "object not found: "+oid)
+	}
+	return t.pkt.status(200)
+}
+
+func (t *lfsTransfer) getObject(req lfsRequest, oid string) error {
+	if !isOid(oid) {
+		return t.fail(req, 400, "invalid object: "+oid)
+	}
+	size, err := t.store.Stat(t.repo, oid)
+	if err != nil {
+		return t.fail(req, 404, "object not found: "+oid)
+	}
+	r, err := t.store.Get(t.repo, oid)
+	if err != nil {
+		log.Error("git lfs", "repo", t.repo, "oid", oid, "error", err)
+		return t.fail(req, 500, ErrSystemMalfunction.Error())
+	}
+	defer r.Close() //nolint:errcheck
+
+	if err := t.pkt.writeLines("status 200", "size="+strconv.FormatInt(size, 10)); err != nil {
+		return err
+	}
+	if err := t.pkt.delim(); err != nil {
+		return err
+	}
+	buf := make([]byte, lfsMaxPayload)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if werr := t.pkt.write(buf[:n]); werr != nil {
+				return werr
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			// the client notices the short object.
+			return fmt.Errorf("read lfs object: %w", err)
+		}
+	}
+	return t.pkt.flush()
+}
+
+func isOid(s string) bool {
+	if len(s) != 64 {
+		return false
+	}
+	for _, r := range s {
+		if !(r >= '0' && r <= '9' || r >= 'a' && r <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// lfsVerifier fails reading if the data doesn't match its id and size.
+type lfsVerifier struct {
+	r    io.Reader
+	h    hash.Hash
+	oid  string
+	size int64
+	n    int64
+}
+
+func (v *lfsVerifier) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	v.n += int64(n)
+	v.h.Write(p[:n])
+	if v.n > v.size {
+		return n, ErrLFSMismatch
+	}
+	if errors.Is(err, io.EOF) && (v.n != v.size || hex.EncodeToString(v.h.Sum(nil)) != v.oid) {
+		return n, ErrLFSMismatch
+	}
+	return n, err //nolint:wrapcheck
+}
+
+const lfsMaxPayload = 65516
+
+// lfsConn reads and writes the pkt-lines of git-lfs-transfer, which unlike
+// the git protocol v0 uses delimiter packets.
+type lfsConn struct {
+	r *bufio.Reader
+	w io.Writer
+}
+
+type packetKind int
+
+const (
+	flushPacket packetKind = iota
+	delimPacket
+	dataPacket
+)
+
+func (c lfsConn) read() (packetKind, []byte, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(c.r, hdr[:]); err != nil {
+		return 0, nil, err //nolint:wrapcheck
+	}
+	n, err := strconv.ParseUint(string(hdr[:]), 16, 16)
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid pkt-len: %q", hdr)
+	}
+	switch {
+	case n == 0:
+		return flushPacket, nil, nil
+	case n == 1:
+		return delimPacket, nil, nil
+	case n < 4:
+		return 0, nil, fmt.Errorf("invalid pkt-len: %q", hdr)
+	}
+	p := make([]byte, n-4)
+	if _, err := io.ReadFull(c.r, p); err != nil {
+		return 0, nil, fmt.Errorf("read pkt-line: %w", err)
+	}
+	return dataPacket, p, nil
+}
+
+// readRequest reads a command and its arguments, up to the delimiter
+// announcing data or the final flush.
+func (c lfsConn) readRequest() (lfsRequest, error) {
+	kind, p, err := c.read()
+	if err != nil {
+		return lfsRequest{}, err
+	}
+	if kind != dataPacket {
+		return lfsRequest{}, errors.New("expected a command")
+	}
+	req := lfsRequest{command: strings.TrimSuffix(string(p), "\n"), args: map[string]string{}}
+	for {
+		kind, p, err := c.read()
+		if err != nil {
+			return lfsRequest{}, err
+		}
+		switch kind {
+		case flushPacket:
+			return req, nil
+		case delimPacket:
+			req.hasData = true
+			return req, nil
+		}
+		k, v, _ := strings.Cut(strings.TrimSuffix(string(p), "\n"), "=")
+		req.args[k] = v
+	}
+}
+
+// readLines reads text lines up to a flush.
+func (c lfsConn) readLines() ([]string, error) {
+	var lines []string
+	for {
+		kind, p, err := c.read()
+		if err != nil {
+			return nil, err
+		}
+		switch kind {
+		case flushPacket:
+			return lines, nil
+		case delimPacket:
+			return nil, errors.New("unexpected delimiter")
+		}
+		lines = append(lines, strings.TrimSuffix(string(p), "\n"))
+	}
+}
+
+// dataReader reads the data packets up to a flush.
+func (c lfsConn) dataReader() io.Reader {
+	return &lfsDataReader{c: c}
+}
+
+type lfsDataReader struct {
+	c    lfsConn
+	buf  []byte
+	done bool
+}
+
+func (r *lfsDataReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		kind, data, err := r.c.read()
+		if err != nil {
+			return 0, err
+		}
+		if kind != dataPacket {
+			r.done = true
+			continue
+		}
+		r.buf = data
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (c lfsConn) write(p []byte) error {
+	if _, err := fmt.Fprintf(c.w, "%04x%s", len(p)+4, p); err != nil {
+		return fmt.Errorf("write pkt-line: %w", err)
+	}
+	return nil
+}
+
+func (c lfsConn) writeLines(lines ...string) error {
+	for _, line := range lines {
+		if err := c.write([]byte(line + "\n")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c lfsConn) flush() error {
+	if _, err := io.WriteString(c.w, "0000"); err != nil {
+		return fmt.Errorf("write pkt-line: %w", err)
+	}
+	return nil
+}
+
+func (c lfsConn) delim() error {
+	if _, err := io.WriteString(c.w, "0001"); err != nil {
+		return fmt.Errorf("write pkt-line: %w", err)
+	}
+	return nil
+}
+
+func (c lfsConn) status(code int) error {
+	if err := c.writeLines(fmt.Sprintf("status %03d", code)); err != nil {
+		return err
+	}
+	return c.flush()
+}
+
+func (c lfsConn) error(code int, msg string) error {
+	if err := c.writeLines(fmt.Sprintf("status %03d", code)); err != nil {
+		return err
+	}
+	if err := c.delim(); err != nil {
+		return err
+	}
+	if err := c.writeLines(msg); err != nil {
+		return err
+	}
+	return c.flush()
+}
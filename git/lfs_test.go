@@ -0,0 +1,259 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"charm.land/ssh"
+	"charm.land/wish/v2/testsession"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+type lfsResponse struct {
+	status string
+	args   []string
+	data   []byte
+}
+
+// lfsClient speaks git-lfs-transfer like git-lfs does.
+type lfsClient struct {
+	t    *testing.T
+	sess *gossh.Session
+	c    lfsConn
+}
+
+func newLFSClient(t *testing.T, addr, user, cmd string) *lfsClient {
+	t.Helper()
+	sess, err := testsession.NewClientSession(t, addr, &gossh.ClientConfig{
+		User:            user,
+		HostKeyCallback: gossh.InsecureIgnoreHostKey(), //nolint:gosec
+	})
+	requireNoError(t, err)
+	in, err := sess.StdinPipe()
+	requireNoError(t, err)
+	out, err := sess.StdoutPipe()
+	requireNoError(t, err)
+	requireNoError(t, sess.Start(cmd))
+	return &lfsClient{t: t, sess: sess, c: lfsConn{r: bufio.NewReader(out), w: in}}
+}
+
+func (c *lfsClient) send(lines []string, data []byte) {
+	c.t.Helper()
+	requireNoError(c.t, c.c.writeLines(lines...))
+	if data != nil {
+		requireNoError(c.t, c.c.delim())
+		for len(data) > 0 {
+			n := min(len(data), lfsMaxPayload)
+			requireNoError(c.t, c.c.write(data[:n]))
+			data = data[n:]
+		}
+	}
+	requireNoError(c.t, c.c.flush())
+}
+
+func (c *lfsClient) batch(args []string, objects ...string) lfsResponse {
+	c.t.Helper()
+	requireNoError(c.t, c.c.writeLines(append([]string{"batch"}, args...)...))
+	requireNoError(c.t, c.c.delim())
+	requireNoError(c.t, c.c.writeLines(objects...))
+	requireNoError(c.t, c.c.flush())
+	return c.response()
+}
+
+func (c *lfsClient) response() lfsResponse {
+	c.t.Helper()
+	var resp lfsResponse
+	for {
+		kind, p, err := c.c.read()
+		requireNoError(c.t, err)
+		switch kind {
+		case flushPacket:
+			return resp
+		case delimPacket:
+			resp.data, err = io.ReadAll(c.c.dataReader())
+			requireNoError(c.t, err)
+			return resp
+		}
+		if resp.status == "" {
+			resp.status = string(p)
+			continue
+		}
+		resp.args = append(resp.args, string(p))
+	}
+}
+
+func (c *lfsClient) request(lines []string, data []byte) lfsResponse {
+	c.t.Helper()
+	c.send(lines, data)
+	return c.response()
+}
+
+func requireStatus(t *testing.T, resp lfsResponse, status string) {
+	t.Helper()
+	if resp.status != "status "+status+"\n" {
+		t.Fatalf("expected status %s, got %q %q", status, resp.status, resp.data)
+	}
+}
+
+func oidOf(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestLFSTransfer(t *testing.T) {
+	repoDir := t.TempDir()
+	store := LocalLFSStore{Dir: t.TempDir()}
+	srv := &ssh.Server{
+		Handler: Middleware(repoDir, namespaceHooks{}, WithLFS(store))(func(s ssh.Session) {
+			_, _ = s.Write([]byte("next\n"))
+		}),
+	}
+	addr := testsession.Listen(t, srv)
+
+	small := []byte("hello world\n")
+	big := bytes.Repeat([]byte("0123456789"), 10_000)
+	missing := oidOf([]byte("missing"))
+
+	t.Run("upload", func(t *testing.T) {
+		c := newLFSClient(t, addr, "alice", "git-lfs-transfer alice/repo1 upload")
+		if caps := c.response(); caps.status != "version=1\n" {
+			t.Fatalf("unexpected capabilities: %+v", caps)
+		}
+		requireStatus(t, c.request([]string{"version 1"}, nil), "200")
+
+		requireStatus(t, c.batch([]string{"hash-algo=sha256"}), "200")
+		requireStatus(t, c.batch([]string{"hash-algo=sha1"}), "400")
+
+		resp := c.batch([]string{"transfer=ssh", "refname=refs/heads/main"}, oidOf(small)+" 12", oidOf(big)+" 100000")
+		requireStatus(t, resp, "200")
+		expected := oidOf(small) + " 12 upload\n" + oidOf(big) + " 100000 upload\n"
+		if string(resp.data) != expected {
+			t.Fatalf("expected %q, got %q", expected, resp.data)
+		}
+
+		requireStatus(t, c.request([]string{"put-object " + oidOf(small), "size=12"}, small), "200")
+		requireStatus(t, c.request([]string{"put-object " + oidOf(big), "size=100000"}, big), "200")
+
+		resp = c.request([]string{"put-object " + missing, "size=12"}, small)
+		requireStatus(t, resp, "400")
+		requireContains(t, string(resp.data), ErrLFSMismatch.Error())
+
+		requireStatus(t, c.request([]string{"verify-object " + oidOf(small), "size=12"}, nil), "200")
+		requireStatus(t, c.request([]string{"verify-object " + missing, "size=12"}, nil), "404")
+		requireStatus(t, c.request([]string{"get-object " + oidOf(small)}, nil), "400")
+
+		requireStatus(t, c.request([]string{"quit"}, nil), "200")
+		requireNoError(t, c.sess.Wait())
+	})
+
+	t.Run("download", func(t *testing.T) {
+		requireNoError(t, EnsureRepo(repoDir, "alice/repo1"))
+		c := newLFSClient(t, addr, "bob", "git-lfs-transfer alice/repo1 download")
+		c.response()
+		requireStatus(t, c.request([]string{"version 1"}, nil), "200")
+
+		resp := c.batch(nil, oidOf(big)+" 100000", missing+" 7")
+		requireStatus(t, resp, "200")
+		expected := oidOf(big) + " 100000 download\n" + missing + " 7 noop\n"
+		if string(resp.data) != expected {
+			t.Fatalf("expected %q, got %q", expected, resp.data)
+		}
+
+		resp = c.request([]string{"get-object " + oidOf(big)}, nil)
+		requireStatus(t, resp, "200")
+		if !reflect.DeepEqual(resp.args, []string{"size=100000\n"}) || !bytes.Equal(resp.data, big) {
+			t.Fatalf("unexpected object: %q, %d bytes", resp.args, len(resp.data))
+		}
+		requireStatus(t, c.request([]string{"get-object " + missing}, nil), "404")
+		requireStatus(t, c.request([]string{"put-object " + missing, "size=4"}, []byte("nope")), "400")
+		requireStatus(t, c.request([]string{"nope"}, nil), "400")
+		resp = c.request([]string{"list-lock", "limit=100"}, nil)
+		requireStatus(t, resp, "501")
+		requireContains(t, string(resp.data), "locking is not supported")
+
+		requireStatus(t, c.request([]string{"quit"}, nil), "200")
+		requireNoError(t, c.sess.Wait())
+	})
+
+	t.Run("unauthorized", func(t *testing.T) {
+		sess, err := testsession.NewClientSession(t, addr, &gossh.ClientConfig{
+			User:            "bob",
+			HostKeyCallback: gossh.InsecureIgnoreHostKey(), //nolint:gosec
+		})
+		requireNoError(t, err)
+		out, err := sess.CombinedOutput("git-lfs-transfer alice/repo1 upload")
+		var exitErr *gossh.ExitError
+		if !errors.As(err, &exitErr) || exitErr.ExitStatus() != 1 {
+			t.Fatalf("expected exit status 1, got %v", err)
+		}
+		requireContains(t, string(out), "bob can't push to alice/repo1")
+	})
+
+	t.Run("missing repo", func(t *testing.T) {
+		sess, err := testsession.NewClientSession(t, addr, &gossh.ClientConfig{
+			User:            "bob",
+			HostKeyCallback: gossh.InsecureIgnoreHostKey(), //nolint:gosec
+		})
+		requireNoError(t, err)
+		out, err := sess.CombinedOutput("git-lfs-transfer bob/nope download")
+		requireError(t, err)
+		requireContains(t, string(out), ErrInvalidRepo.Error())
+	})
+
+	t.Run("stored", func(t *testing.T) {
+		size, err := store.Stat("alice/repo1", oidOf(big))
+		requireNoError(t, err)
+		if size != int64(len(big)) {
+			t.Fatalf("expected %d bytes, got %d", len(big), size)
+		}
+		if _, err := store.Stat("alice/repo1", missing); err == nil {
+			t.Fatal("expected the mismatched object not to be stored")
+		}
+	})
+}
+
+func TestLFSDisabled(t *testing.T) {
+	srv := &ssh.Server{
+		Handler: Middleware(t.TempDir(), namespaceHooks{})(func(s ssh.Session) {
+			_, _ = s.Write([]byte(strings.Join(s.Command(), " ")))
+		}),
+	}
+	out, err := testsession.New(t, srv, nil).Output("git-lfs-transfer repo1 upload")
+	requireNoError(t, err)
+	if string(out) != "git-lfs-transfer repo1 upload" {
+		t.Fatalf("expected the next handler to run, got %q", out)
+	}
+}
+
+func TestLFSLimits(t *testing.T) {
+	srv := &ssh.Server{
+		Handler: Middleware(t.TempDir(), namespaceHooks{}, WithLFS(LocalLFSStore{Dir: t.TempDir()}),
+			WithMaxConcurrency(1, 0), WithTimeout(500*time.Millisecond))(func(ssh.Session) {}),
+	}
+	addr := testsession.Listen(t, srv)
+
+	c := newLFSClient(t, addr, "alice", "git-lfs-transfer alice/repo1 upload")
+	c.response()
+
+	sess, err := testsession.NewClientSession(t, addr, &gossh.ClientConfig{
+		User:            "alice",
+		HostKeyCallback: gossh.InsecureIgnoreHostKey(), //nolint:gosec
+	})
+	requireNoError(t, err)
+	out, err := sess.CombinedOutput("git-lfs-transfer alice/repo2 upload")
+	requireError(t, err)
+	requireContains(t, string(out), ErrTooBusy.Error())
+
+	var exitErr *gossh.ExitError
+	if err := c.sess.Wait(); !errors.As(err, &exitErr) || exitErr.ExitStatus() != 1 {
+		t.Fatalf("expected the transfer to time out, got %v", err)
+	}
+}
@@ -0,0 +1,251 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"charm.land/ssh"
+	"charm.land/wish/v2"
+)
+
+// ErrTimeout represents a git operation taking longer than allowed.
+var ErrTimeout = errors.New("operation timed out")
+
+// ErrTooBusy represents a git operation rejected because too many are in
+// progress.
+var ErrTooBusy = errors.New("too many operations in progress, try again later")
+
+// ErrPackTooLarge represents a push larger than allowed.
+var ErrPackTooLarge = errors.New("pack exceeds the maximum size")
+
+// limiter limits the number of concurrent operations, globally and per repo.
+type limiter struct {
+	mu         sync.Mutex
+	max        int
+	maxPerRepo int
+	total      int
+	repos      map[string]int
+}
+
+func newLimiter(max, maxPerRepo int) *limiter {
+	return &limiter{max: max, maxPerRepo: maxPerRepo, repos: map[string]int{}}
+}
+
+// acquire reports whether an operation on the repo may start, in which case
+// release must be called when it's done.
+func (l *limiter) acquire(repo string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.max > 0 && l.total >= l.max || l.maxPerRepo > 0 && l.repos[repo] >= l.maxPerRepo {
+		return false
+	}
+	l.total++
+	l.repos[repo]++
+	return true
+}
+
+func (l *limiter) release(repo string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.total--
+	if l.repos[repo]--; l.repos[repo] <= 0 {
+		delete(l.repos, repo)
+	}
+}
+
+// limitedSession is a session failing once it exceeds a limit.
+type limitedSession struct {
+	ssh.Session
+	ctx    ssh.Context
+	r      io.Reader
+	report func(*limitedSession, error)
+
+	// head is the beginning of the client request, holding its capabilities.
+	mu   sync.Mutex
+	head []byte
+
+	// wmu serializes the writes of the operation and the failure report, so
+	// they don't interleave. Nothing is written once the session failed.
+	wmu    sync.Mutex
+	failed bool
+
+	once sync.Once
+	err  error
+}
+
+// fail reports the exceeded limit to the client and closes the session,
+// which stops the operation.
+func (s *limitedSession) fail(err error) {
+	s.once.Do(func() {
+		s.wmu.Lock()
+		defer s.wmu.Unlock()
+		s.err = err
+		s.failed = true
+		s.report(s, err)
+	})
+}
+
+// reportPack reports the exceeded limit of a git command as a git error.
+func reportPack(s *limitedSession, err error) {
+	if s.sideband() {
+		// the client demultiplexes the response, and would fail to read an
+		// ERR packet.
+		fatalSideband(s.Session, err)
+		return
+	}
+	fatalErr(s.Session, err)
+}
+
+// failure returns the exceeded limit, if any.
+func (s *limitedSession) failure() error {
+	// waits for a concurrent fail to be done.
+	s.once.Do(func() {})
+	return s.err
+}
+
+// sideband reports whether the client asked for a side-band response, which
+// protocol v2 always uses.
+func (s *limitedSession) sideband() bool {
+	if slices.Contains(strings.Split(gitProtocol(s.Session), ":"), "version=2") {
+		return true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return bytes.Contains(s.head, []byte(" side-band"))
+}
+
+func (s *limitedSession) Context() ssh.Context { return s.ctx }
+
+func (s *limitedSession) Write(p []byte) (int, error) {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	if s.failed {
+		return 0, s.err
+	}
+	return s.Session.Write(p) //nolint:wrapcheck
+}
+
+func (s *limitedSession) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	s.mu.Lock()
+	if len(s.head) < maxHead {
+		s.head = append(s.head, p[:min(n, maxHead-len(s.head))]...)
+	}
+	s.mu.Unlock()
+	return n, err //nolint:wrapcheck
+}
+
+// maxHead is the length of the beginning of the requests kept to find the
+// capabilities of the clients, which are sent in the first lines.
+const maxHead = 4096
+
+// fatalSideband is like fatalErr, sending the message on the error band of a
+// side-band response.
+func fatalSideband(s ssh.Session, v ...any) {
+	msg := "\x03" + fmt.Sprint(v...) + "\n"
+	_, _ = wish.WriteString(s, fmt.Sprintf("%04x%s0000", len(msg)+4, msg))
+	_ = s.Exit(1)
+}
+
+// timeoutContext is a ssh.Context with a deadline.
+type timeoutContext struct {
+	ssh.Context
+	ctx context.Context
+}
+
+func (c timeoutContext) Deadline() (time.Time, bool) { return c.ctx.Deadline() }
+func (c timeoutContext) Done() <-chan struct{}       { return c.ctx.Done() }
+func (c timeoutContext) Err() error                  { return c.ctx.Err() } //nolint:wrapcheck
+func (c timeoutContext) Value(key any) any           { return c.ctx.Value(key) }
+
+// maxSizeReader calls exceeded once more than max bytes are read. For
+// pushes, they include the ref updates and push options sent before the
+// pack, which are small in comparison.
+type maxSizeReader struct {
+	r        io.Reader
+	n        int64
+	exceeded func()
+}
+
+func (r *maxSizeReader) Read(p []byte) (int, error) {
+	if r.n < 0 {
+		return 0, ErrPackTooLarge
+	}
+	if int64(len(p)) > r.n+1 {
+		p = p[:r.n+1]
+	}
+	n, err := r.r.Read(p)
+	r.n -= int64(n)
+	if r.n < 0 {
+		r.exceeded()
+		return 0, ErrPackTooLarge
+	}
+	return n, err //nolint:wrapcheck
+}
+
+// limitedPack runs gitPack within the limits set by the options.
+func limitedPack(s ssh.Session, gh Hooks, o options, gitCmd, repo, rp string, access AccessLevel) error {
+	var maxSize int64
+	if gitCmd == "git-receive-pack" {
+		maxSize = o.maxPackSize
+	}
+	return limited(s, o, repo, maxSize, reportPack, func(s ssh.Session) error {
+		return gitPack(s, gh, o, gitCmd, repo, rp, access)
+	})
+}
+
+// limited runs fn on the repo within the concurrency and timeout limits set
+// by the options, and reading at most maxSize bytes, if not zero. Exceeding
+// the timeout or the size is reported to the client with report as soon as
+// it happens, and then returned.
+//
+// The session given to fn is closed once the timeout is exceeded, and its
+// context is done, so fn must stop. Its slot is released right away, rather
+// than once it returns.
+func limited(s ssh.Session, o options, repo string, maxSize int64, report func(*limitedSession, error), fn func(ssh.Session) error) error {
+	release := func() {}
+	if o.limiter != nil {
+		if !o.limiter.acquire(repo) {
+			return ErrTooBusy
+		}
+		release = sync.OnceFunc(func() { o.limiter.release(repo) })
+		defer release()
+	}
+
+	ls := &limitedSession{Session: s, ctx: s.Context(), r: s, report: report}
+	timeout := func() {
+		release()
+		ls.fail(ErrTimeout)
+	}
+	if maxSize > 0 {
+		ls.r = &maxSizeReader{r: s, n: maxSize, exceeded: func() { ls.fail(ErrPackTooLarge) }}
+	}
+	if o.timeout > 0 {
+		ctx, cancel := context.WithTimeout(s.Context(), o.timeout)
+		defer cancel()
+		ls.ctx = timeoutContext{Context: s.Context(), ctx: ctx}
+		stop := context.AfterFunc(ctx, func() {
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				timeout()
+			}
+		})
+		defer stop()
+	}
+
+	err := fn(ls)
+	if errors.Is(ls.ctx.Err(), context.DeadlineExceeded) {
+		// fn may return before the timeout is reported.
+		timeout()
+	}
+	if lerr := ls.failure(); lerr != nil {
+		return lerr
+	}
+	return err
+}
@@ -0,0 +1,146 @@
+package git
+
+import (
+	"crypto/rand"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"charm.land/ssh"
+)
+
+// blockingHooks blocks the pushes until released.
+type blockingHooks struct {
+	protectingHooks
+	started chan struct{}
+	release chan struct{}
+}
+
+func (h *blockingHooks) PreReceive(s ssh.Session, _ string, _ []RefUpdate) error {
+	h.started <- struct{}{}
+	select {
+	case <-h.release:
+		return nil
+	case <-s.Context().Done():
+		return s.Context().Err()
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	l := newLimiter(2, 1)
+	if !l.acquire("repo1") || l.acquire("repo1") {
+		t.Fatal("expected a single operation on repo1")
+	}
+	if !l.acquire("repo2") || l.acquire("repo3") {
+		t.Fatal("expected two operations in total")
+	}
+	l.release("repo1")
+	if !l.acquire("repo3") {
+		t.Fatal("expected an operation on repo3 once repo1 is released")
+	}
+}
+
+func TestMaxPackSize(t *testing.T) {
+	t.Run("exec", func(t *testing.T) {
+		testMaxPackSize(t, ExecBackend)
+	})
+	t.Run("go", func(t *testing.T) {
+		testMaxPackSize(t, GoBackend)
+	})
+}
+
+func testMaxPackSize(t *testing.T, backend Backend) {
+	remote, pk := newTestServer(t, t.TempDir(), &protectingHooks{}, WithBackend(backend), WithMaxPackSize(64<<10))
+
+	cwd := t.TempDir()
+	requireNoError(t, runGitHelper(t, pk, cwd, "init", "-b", "main"))
+	requireNoError(t, runGitHelper(t, pk, cwd, "commit", "--allow-empty", "-m", "initial commit"))
+	requireNoError(t, runGitHelper(t, pk, cwd, "push", remote+"/repo1", "main"))
+
+	// random data doesn't compress.
+	data := make([]byte, 256<<10)
+	_, _ = rand.Read(data)
+	requireNoError(t, os.WriteFile(filepath.Join(cwd, "big"), data, 0o600))
+	requireNoError(t, runGitHelper(t, pk, cwd, "add", "big"))
+	requireNoError(t, runGitHelper(t, pk, cwd, "commit", "-m", "big"))
+	out, err := runGitOutput(t, pk, cwd, "push", remote+"/repo1", "main")
+	requireError(t, err)
+	requireContains(t, out, ErrPackTooLarge.Error())
+
+	// fetches are not limited.
+	requireNoError(t, runGitHelper(t, pk, t.TempDir(), "clone", remote+"/repo1", "."))
+}
+
+func TestMaxConcurrency(t *testing.T) {
+	hooks := &blockingHooks{started: make(chan struct{}), release: make(chan struct{})}
+	remote, pk := newTestServer(t, t.TempDir(), hooks, WithBackend(GoBackend), WithMaxConcurrency(2, 1))
+
+	cwd := t.TempDir()
+	requireNoError(t, runGitHelper(t, pk, cwd, "init", "-b", "main"))
+	requireNoError(t, runGitHelper(t, pk, cwd, "commit", "--allow-empty", "-m", "initial commit"))
+
+	errs := make(chan error, 2)
+	for _, repo := range []string{"repo1", "repo2"} {
+		go func() {
+			errs <- runGitHelper(t, pk, cwd, "push", remote+"/"+repo, "main")
+		}()
+		<-hooks.started
+	}
+
+	out, err := runGitOutput(t, pk, t.TempDir(), "clone", remote+"/repo1", ".")
+	requireError(t, err)
+	requireContains(t, out, ErrTooBusy.Error())
+	out, err = runGitOutput(t, pk, cwd, "push", remote+"/repo3", "main")
+	requireError(t, err)
+	requireContains(t, out, ErrTooBusy.Error())
+
+	close(hooks.release)
+	requireNoError(t, <-errs)
+	requireNoError(t, <-errs)
+	requireNoError(t, runGitHelper(t, pk, t.TempDir(), "clone", remote+"/repo1", "."))
+}
+
+func TestTimeout(t *testing.T) {
+	hooks := &blockingHooks{started: make(chan struct{}, 1), release: make(chan struct{})}
+	remote, pk := newTestServer(t, t.TempDir(), hooks, WithBackend(GoBackend), WithTimeout(500*time.Millisecond),
+		WithMaxConcurrency(1, 0))
+
+	cwd := t.TempDir()
+	requireNoError(t, runGitHelper(t, pk, cwd, "init", "-b", "main"))
+	requireNoError(t, runGitHelper(t, pk, cwd, "commit", "--allow-empty", "-m", "initial commit"))
+	out, err := runGitOutput(t, pk, cwd, "push", remote+"/repo1", "main")
+	requireError(t, err)
+	requireContains(t, out, ErrTimeout.Error())
+
+	// the slot of the push is released.
+	requireNoError(t, runGitHelper(t, pk, cwd, "ls-remote", remote+"/repo1"))
+}
+
+func TestLimitedSession(t *testing.T) {
+	for env, sideband := range map[string]bool{
+		"":                       false,
+		"GIT_PROTOCOL=version=1": false,
+		"GIT_PROTOCOL=version=2": true,
+		"GIT_PROTOCOL=object-format=sha256:version=2": true,
+	} {
+		ls := &limitedSession{Session: envSession{env: []string{env}}}
+		if got := ls.sideband(); got != sideband {
+			t.Errorf("%q: expected %v, got %v", env, sideband, got)
+		}
+	}
+
+	ls := &limitedSession{
+		Session: envSession{},
+		head:    []byte("00a0want 1234 multi_ack side-band-64k ofs-delta\n"),
+		report:  func(*limitedSession, error) {},
+	}
+	if !ls.sideband() {
+		t.Error("expected a side-band response")
+	}
+	ls.fail(ErrTimeout)
+	if _, err := ls.Write([]byte("0008NAK\n")); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("expected writes to fail once timed out, got %v", err)
+	}
+}
@@ -0,0 +1,245 @@
+package git
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"charm.land/log/v2"
+)
+
+// Mirror is a replication target of the pushed repos.
+type Mirror interface {
+	// Mirror replicates all the refs of the repo stored at the given path.
+	Mirror(ctx context.Context, repo, path string) error
+}
+
+// MirrorFunc is a function implementing Mirror.
+type MirrorFunc func(ctx context.Context, repo, path string) error
+
+// Mirror implements Mirror.
+func (f MirrorFunc) Mirror(ctx context.Context, repo, path string) error {
+	return f(ctx, repo, path)
+}
+
+// GitMirror is a Mirror running git push --mirror, which requires git to be
+// installed on the server.
+type GitMirror struct {
+	// URL returns the URL of the mirror of the given repo, e.g. a local path
+	// or an ssh URL.
+	URL func(repo string) string
+
+	// Init creates the mirrors as bare repos if they don't exist, for URLs
+	// that are local paths.
+	Init bool
+
+	// Env is added to the environment of git, e.g. to set GIT_SSH_COMMAND.
+	Env []string
+}
+
+// Mirror implements Mirror.
+func (m GitMirror) Mirror(ctx context.Context, repo, path string) error {
+	url := m.URL(repo)
+	if m.Init {
+		if err := EnsureRepo(filepath.Dir(url), filepath.Base(url)); err != nil {
+			return err
+		}
+	}
+	cmd := exec.CommandContext(ctx, "git", "push", "--mirror", "--quiet", url)
+	cmd.Dir = path
+	cmd.Env = append(os.Environ(), m.Env...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("git push --mirror: %w: %s", err, out)
+	}
+	return nil
+}
+
+// ReplicationStatus is the status of the replication of a repo to a mirror.
+type ReplicationStatus struct {
+	// Repo is the name of the replicated repo.
+	Repo string
+
+	// Mirror is the name of the mirror.
+	Mirror string
+
+	// Attempts is the number of attempts of the last replication.
+	Attempts int
+
+	// Err is the error of the last attempt, nil if it succeeded.
+	Err error
+
+	// Time is the time of the last attempt.
+	Time time.Time
+
+	// Pending is true while the repo is being replicated, or waiting to be.
+	Pending bool
+}
+
+// Replicator mirrors the pushed repos to its Mirrors in the background, so
+// pushes are not delayed, retrying the failed attempts.
+//
+// Pushes made while a repo is being replicated to a mirror are coalesced in a
+// single replication, starting when the current one is done.
+type Replicator struct {
+	// Mirrors are the replication targets, by name.
+	Mirrors map[string]Mirror
+
+	// Retries is the number of retries of a failed replication.
+	Retries int
+
+	// RetryDelay is the delay before the first retry, doubling with each
+	// retry. Defaults to a second.
+	RetryDelay time.Duration
+
+	// Timeout is the timeout of each attempt, none if zero.
+	Timeout time.Duration
+
+	// OnStatus, if set, is called with the status of each attempt.
+	OnStatus func(ReplicationStatus)
+
+	mu     sync.Mutex
+	wg     sync.WaitGroup
+	status map[replicationKey]*ReplicationStatus
+	queued map[replicationKey]bool
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+type replicationKey struct {
+	repo, mirror string
+}
+
+// Replicate replicates the repo stored at the given path to every mirror.
+func (r *Replicator) Replicate(repo, path string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.init()
+	if r.ctx.Err() != nil {
+		// closed.
+		return
+	}
+	for name, m := range r.Mirrors {
+		key := replicationKey{repo, name}
+		st, ok := r.status[key]
+		if ok && st.Pending {
+			r.queued[key] = true
+			continue
+		}
+		if !ok {
+			st = &ReplicationStatus{Repo: repo, Mirror: name}
+			r.status[key] = st
+		}
+		st.Pending = true
+		r.wg.Add(1)
+		go r.run(key, m, path)
+	}
+}
+
+// init initializes the zero Replicator, with r.mu held.
+func (r *Replicator) init() {
+	if r.status != nil {
+		return
+	}
+	r.status = map[replicationKey]*ReplicationStatus{}
+	r.queued = map[replicationKey]bool{}
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+}
+
+func (r *Replicator) run(key replicationKey, m Mirror, path string) {
+	defer r.wg.Done()
+	for {
+		r.replicate(key, m, path)
+
+		r.mu.Lock()
+		if !r.queued[key] || r.ctx.Err() != nil {
+			r.status[key].Pending = false
+			r.mu.Unlock()
+			return
+		}
+		delete(r.queued, key)
+		r.mu.Unlock()
+	}
+}
+
+// replicate replicates the repo to the mirror, retrying on failures.
+func (r *Replicator) replicate(key replicationKey, m Mirror, path string) {
+	delay := r.RetryDelay
+	if delay == 0 {
+		delay = time.Second
+	}
+	for attempt := 1; ; attempt++ {
+		err := r.attempt(m, key.repo, path)
+
+		r.mu.Lock()
+		st := r.status[key]
+		st.Attempts = attempt
+		st.Err = err
+		st.Time = time.Now()
+		report := *st
+		r.mu.Unlock()
+		if err != nil {
+			log.Error("git replication", "repo", key.repo, "mirror", key.mirror, "attempt", attempt, "error", err)
+		}
+		if r.OnStatus != nil {
+			r.OnStatus(report)
+		}
+
+		if err == nil || attempt > r.Retries {
+			return
+		}
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-time.After(delay):
+			delay *= 2
+		}
+	}
+}
+
+func (r *Replicator) attempt(m Mirror, repo, path string) error {
+	ctx := r.ctx
+	if r.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.Timeout)
+		defer cancel()
+	}
+	return m.Mirror(ctx, repo, path) //nolint:wrapcheck
+}
+
+// Status returns the replication status of every repo and mirror, sorted by
+// repo and mirror.
+func (r *Replicator) Status() []ReplicationStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	status := make([]ReplicationStatus, 0, len(r.status))
+	for _, st := range r.status {
+		status = append(status, *st)
+	}
+	sort.Slice(status, func(i, j int) bool {
+		if status[i].Repo != status[j].Repo {
+			return status[i].Repo < status[j].Repo
+		}
+		return status[i].Mirror < status[j].Mirror
+	})
+	return status
+}
+
+// Wait waits for the pending replications to be done.
+func (r *Replicator) Wait() {
+	r.wg.Wait()
+}
+
+// Close cancels the pending replications and waits for them to return.
+// Pushes are not replicated anymore afterwards.
+func (r *Replicator) Close() {
+	r.mu.Lock()
+	r.init()
+	r.cancel()
+	r.mu.Unlock()
+	r.wg.Wait()
+}
@@ -0,0 +1,152 @@
+package git
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+func TestReplicatorGitMirror(t *testing.T) {
+	backupDir := t.TempDir()
+	r := &Replicator{
+		Mirrors: map[string]Mirror{
+			"backup": GitMirror{
+				URL:  func(repo string) string { return filepath.Join(backupDir, repo) },
+				Init: true,
+			},
+		},
+	}
+	t.Cleanup(r.Close)
+	remote, pk := newTestServer(t, t.TempDir(), &protectingHooks{}, WithReplicator(r))
+
+	cwd := t.TempDir()
+	requireNoError(t, runGitHelper(t, pk, cwd, "init", "-b", "main"))
+	requireNoError(t, runGitHelper(t, pk, cwd, "commit", "--allow-empty", "-m", "initial commit"))
+	requireNoError(t, runGitHelper(t, pk, cwd, "tag", "v1"))
+	requireNoError(t, runGitHelper(t, pk, cwd, "push", remote+"/a/repo1", "main", "v1"))
+	r.Wait()
+
+	head, err := runGitOutput(t, pk, cwd, "rev-parse", "main")
+	requireNoError(t, err)
+	mirror, err := git.PlainOpen(filepath.Join(backupDir, "a", "repo1"))
+	requireNoError(t, err)
+	for _, name := range []plumbing.ReferenceName{"refs/heads/main", "refs/tags/v1"} {
+		ref, err := mirror.Reference(name, true)
+		requireNoError(t, err)
+		if ref.Hash().String() != strings.TrimSpace(head) {
+			t.Fatalf("expected %s to be %s, got %s", name, head, ref.Hash())
+		}
+	}
+
+	status := r.Status()
+	if len(status) != 1 || status[0].Repo != "a/repo1" || status[0].Mirror != "backup" ||
+		status[0].Attempts != 1 || status[0].Err != nil || status[0].Pending {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+}
+
+func TestReplicatorRetries(t *testing.T) {
+	var calls atomic.Int32
+	var reports []ReplicationStatus
+	r := &Replicator{
+		Mirrors: map[string]Mirror{
+			"flaky": MirrorFunc(func(context.Context, string, string) error {
+				if calls.Add(1) < 3 {
+					return errors.New("unavailable")
+				}
+				return nil
+			}),
+			"down": MirrorFunc(func(context.Context, string, string) error {
+				return errors.New("down")
+			}),
+		},
+		Retries:    2,
+		RetryDelay: time.Millisecond,
+		OnStatus: func(st ReplicationStatus) {
+			if st.Mirror == "flaky" {
+				reports = append(reports, st)
+			}
+		},
+	}
+	r.Replicate("repo1", "")
+	r.Wait()
+
+	if len(reports) != 3 || reports[0].Err == nil || reports[2].Err != nil || reports[2].Attempts != 3 {
+		t.Fatalf("unexpected reports: %+v", reports)
+	}
+	status := r.Status()
+	if len(status) != 2 || status[0].Mirror != "down" || status[0].Attempts != 3 || status[0].Err == nil {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+}
+
+func TestReplicatorCoalesces(t *testing.T) {
+	var calls atomic.Int32
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var once sync.Once
+	r := &Replicator{
+		Mirrors: map[string]Mirror{
+			"slow": MirrorFunc(func(context.Context, string, string) error {
+				calls.Add(1)
+				once.Do(func() {
+					close(started)
+					<-release
+				})
+				return nil
+			}),
+		},
+	}
+	r.Replicate("repo1", "")
+	<-started
+	r.Replicate("repo1", "")
+	r.Replicate("repo1", "")
+	if st := r.Status(); !st[0].Pending {
+		t.Fatalf("expected a pending replication, got %+v", st)
+	}
+	close(release)
+	r.Wait()
+
+	if n := calls.Load(); n != 2 {
+		t.Fatalf("expected 2 replications, got %d", n)
+	}
+}
+
+func TestReplicatorClose(t *testing.T) {
+	var calls atomic.Int32
+	r := &Replicator{
+		Mirrors: map[string]Mirror{
+			"down": MirrorFunc(func(context.Context, string, string) error {
+				calls.Add(1)
+				return errors.New("down")
+			}),
+		},
+		Retries:    10,
+		RetryDelay: time.Hour,
+	}
+	r.Replicate("repo1", "")
+	done := make(chan struct{})
+	go func() {
+		r.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected Close to cancel the retries")
+	}
+
+	r.Replicate("repo1", "")
+	r.Wait()
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("expected a single attempt, got %d", n)
+	}
+}
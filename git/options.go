@@ -0,0 +1,111 @@
+package git
+
+import "time"
+
+// Backend is the implementation serving the git commands.
+type Backend int
+
+const (
+	// ExecBackend runs the git binary, which must be installed on the server.
+	ExecBackend Backend = iota
+
+	// GoBackend serves the git commands with go-git, without requiring the
+	// git binary. It only supports the version 0 of the git protocol, without
+	// shallow or partial clones, and it doesn't run the hook scripts of the
+	// repositories.
+	GoBackend
+)
+
+// Option configures the git Middleware.
+type Option func(*options)
+
+type options struct {
+	backend      Backend
+	resolver     RepoResolver
+	createPolicy CreatePolicy
+	lfs          LFSStore
+	replicator   *Replicator
+	timeout      time.Duration
+	maxPackSize  int64
+	limiter      *limiter
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		resolver:     DefaultResolver,
+		createPolicy: AllowCreate,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithBackend sets the Backend serving the git commands. Defaults to
+// ExecBackend.
+func WithBackend(b Backend) Option {
+	return func(o *options) {
+		o.backend = b
+	}
+}
+
+// WithResolver sets the RepoResolver mapping the requested repos to their
+// paths. Defaults to DefaultResolver.
+func WithResolver(r RepoResolver) Option {
+	return func(o *options) {
+		o.resolver = r
+	}
+}
+
+// WithCreatePolicy sets the CreatePolicy deciding whether pushes may create
+// repos. Defaults to AllowCreate.
+func WithCreatePolicy(p CreatePolicy) Option {
+	return func(o *options) {
+		o.createPolicy = p
+	}
+}
+
+// WithLFS enables git LFS over SSH, storing the objects in the given
+// LFSStore. Only the git-lfs-transfer protocol is supported, which requires
+// git-lfs 3.0 or newer on the clients, and file locking is not. Transfers
+// are subject to the timeout and concurrency limits of the git commands.
+func WithLFS(store LFSStore) Option {
+	return func(o *options) {
+		o.lfs = store
+	}
+}
+
+// WithReplicator replicates the repos with the given Replicator after each
+// push.
+func WithReplicator(r *Replicator) Option {
+	return func(o *options) {
+		o.replicator = r
+	}
+}
+
+// WithTimeout limits the duration of each git operation. Operations taking
+// longer are stopped with ErrTimeout: the session is closed and the context
+// of the session given to the ReceiveHooks is done, so they should return.
+func WithTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.timeout = d
+	}
+}
+
+// WithMaxConcurrency limits the number of concurrent git operations, in
+// total and per repo, unlimited if zero. Operations over the limits are
+// rejected with ErrTooBusy.
+func WithMaxConcurrency(max, maxPerRepo int) Option {
+	return func(o *options) {
+		o.limiter = newLimiter(max, maxPerRepo)
+	}
+}
+
+// WithMaxPackSize limits the size in bytes of the data sent by pushes, i.e.
+// the pack and the ref updates and push options preceding it. Larger pushes
+// are stopped with ErrPackTooLarge.
+func WithMaxPackSize(n int64) Option {
+	return func(o *options) {
+		o.maxPackSize = n
+	}
+}
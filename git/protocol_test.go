@@ -0,0 +1,83 @@
+package git
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"charm.land/ssh"
+)
+
+func TestProtocolV2(t *testing.T) {
+	repoDir := t.TempDir()
+	remote, pk := newTestServer(t, repoDir, &protectingHooks{})
+
+	cwd := t.TempDir()
+	requireNoError(t, runGitHelper(t, pk, cwd, "init", "-b", "main"))
+	requireNoError(t, runGitHelper(t, pk, cwd, "remote", "add", "origin", remote+"/repo1"))
+	requireNoError(t, os.WriteFile(filepath.Join(cwd, "README.md"), []byte("# repo1\n"), 0o644)) //nolint:gosec
+	requireNoError(t, runGitHelper(t, pk, cwd, "add", "README.md"))
+	requireNoError(t, runGitHelper(t, pk, cwd, "commit", "-m", "initial commit"))
+	requireNoError(t, runGitHelper(t, pk, cwd, "push", "origin", "main"))
+
+	t.Run("ls-refs", func(t *testing.T) {
+		out, err := runGitEnv(t, pk, t.TempDir(), []string{"GIT_TRACE_PACKET=1"},
+			"-c", "protocol.version=2", "ls-remote", remote+"/repo1", "refs/heads/main")
+		requireNoError(t, err)
+		requireContains(t, out, "version 2")
+		requireContains(t, out, "command=ls-refs")
+		requireContains(t, out, "refs/heads/main")
+	})
+
+	t.Run("v0", func(t *testing.T) {
+		out, err := runGitEnv(t, pk, t.TempDir(), []string{"GIT_TRACE_PACKET=1"},
+			"-c", "protocol.version=0", "ls-remote", remote+"/repo1")
+		requireNoError(t, err)
+		if strings.Contains(out, "command=ls-refs") {
+			t.Fatalf("expected protocol v0, got %q", out)
+		}
+		requireContains(t, out, "refs/heads/main")
+	})
+
+	t.Run("partial clone", func(t *testing.T) {
+		cwd := t.TempDir()
+		requireNoError(t, runGitHelper(t, pk, cwd,
+			"-c", "protocol.version=2", "clone", "--filter=blob:none", "--no-checkout", remote+"/repo1", "."))
+
+		out, err := runGitOutput(t, pk, cwd, "config", "remote.origin.partialclonefilter")
+		requireNoError(t, err)
+		requireContains(t, out, "blob:none")
+
+		// the blob is fetched lazily.
+		out, err = runGitOutput(t, pk, cwd, "rev-list", "--objects", "--missing=print", "HEAD")
+		requireNoError(t, err)
+		requireContains(t, out, "?")
+		requireNoError(t, runGitHelper(t, pk, cwd, "-c", "protocol.version=2", "checkout", "main"))
+		bts, err := os.ReadFile(filepath.Join(cwd, "README.md"))
+		requireNoError(t, err)
+		requireContains(t, string(bts), "# repo1")
+	})
+}
+
+type envSession struct {
+	ssh.Session
+	env []string
+}
+
+func (s envSession) Environ() []string { return s.env }
+
+func TestGitProtocol(t *testing.T) {
+	for env, expected := range map[string]string{
+		"":                       "",
+		"GIT_PROTOCOL=version=2": "version=2",
+		"GIT_PROTOCOL=version=2:object-format=sha256": "version=2:object-format=sha256",
+		"GIT_PROTOCOL=version=2\nFOO=bar":             "",
+		"GIT_PROTOCOL=$(reboot)":                      "",
+		"OTHER=version=2":                             "",
+	} {
+		if got := gitProtocol(envSession{env: []string{env}}); got != expected {
+			t.Errorf("%q: expected %q, got %q", env, expected, got)
+		}
+	}
+}
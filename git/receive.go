@@ -0,0 +1,229 @@
+package git
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"charm.land/log/v2"
+	"charm.land/ssh"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// RefUpdate is a ref update requested by a push.
+type RefUpdate struct {
+	// Ref is the full name of the updated ref, e.g. refs/heads/main.
+	Ref plumbing.ReferenceName
+
+	// Old is the current value of the ref, zero if the ref is being created.
+	Old plumbing.Hash
+
+	// New is the new value of the ref, zero if the ref is being deleted.
+	New plumbing.Hash
+
+	// Forced is true if the update is not a fast-forward, i.e. the old
+	// commit is not an ancestor of the new one.
+	Forced bool
+}
+
+// IsCreate reports whether the update creates the ref.
+func (u RefUpdate) IsCreate() bool { return u.Old.IsZero() }
+
+// IsDelete reports whether the update deletes the ref.
+func (u RefUpdate) IsDelete() bool { return u.New.IsZero() }
+
+// ReceiveHooks can be implemented by a Hooks implementation to take part in
+// pushes, mirroring git's server-side hooks. Errors are shown to the client.
+//
+// The repository's own hooks still run, after the ones defined here.
+type ReceiveHooks interface {
+	// PreReceive is called with all the ref updates of a push before any of
+	// them is applied. Returning an error rejects the whole push.
+	PreReceive(s ssh.Session, repo string, updates []RefUpdate) error
+
+	// Update is called for each ref update before it is applied. Returning an
+	// error rejects that update only.
+	Update(s ssh.Session, repo string, update RefUpdate) error
+
+	// PostReceive is called with the ref updates that were applied.
+	PostReceive(s ssh.Session, repo string, updates []RefUpdate)
+}
+
+// hookScript forwards git hooks to the middleware through file descriptors 3
+// (requests) and 4 (replies), and then runs the repository's own hook.
+const hookScript = `#!/bin/sh
+hook=$(basename "$0")
+zero() { case $1 in *[!0]*) return 1 ;; esac; }
+send() {
+	forced=0
+	if ! zero "$1" && ! zero "$2" && ! git merge-base --is-ancestor "$1" "$2" 2>/dev/null; then
+		forced=1
+	fi
+	echo "ref $1 $2 $forced $3" >&3
+}
+if [ "$hook" = update ]; then
+	send "$2" "$3" "$1"
+else
+	input=$(cat)
+	printf '%s\n' "$input" | while read -r old new ref; do
+		[ -n "$ref" ] && send "$old" "$new" "$ref"
+	done
+fi
+echo "$hook" >&3
+read -r status msg <&4
+if [ "$status" != ok ]; then
+	echo "$msg" >&2
+	exit 1
+fi
+own="$WISH_GIT_HOOKS_DIR/$hook"
+[ -x "$own" ] || exit 0
+if [ "$hook" = update ]; then
+	exec "$own" "$@"
+fi
+printf '%s\n' "$input" | "$own"
+`
+
+var receiveHookNames = []string{"pre-receive", "update", "post-receive"}
+
+// runReceivePack runs git receive-pack, forwarding its hooks to the given
+// ReceiveHooks.
+func runReceivePack(s ssh.Session, env []string, rp, repo string, h ReceiveHooks) error {
+	hooksDir, err := os.MkdirTemp("", "wish-hooks-*")
+	if err != nil {
+		return fmt.Errorf("create hooks dir: %w", err)
+	}
+	defer os.RemoveAll(hooksDir) //nolint:errcheck
+	if err := writeHooks(hooksDir, filepath.Join(rp, "hooks")); err != nil {
+		return err
+	}
+
+	reqR, reqW, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("create pipe: %w", err)
+	}
+	defer reqR.Close() //nolint:errcheck
+	respR, respW, err := os.Pipe()
+	if err != nil {
+		_ = reqW.Close()
+		return fmt.Errorf("create pipe: %w", err)
+	}
+	defer respW.Close() //nolint:errcheck
+
+	cmd := gitCommand(s, env, "", "-c", "core.hooksPath="+hooksDir, "receive-pack", rp)
+	cmd.Env = append(cmd.Env, "WISH_GIT_HOOKS_DIR="+filepath.Join(rp, "hooks"))
+	cmd.ExtraFiles = []*os.File{reqW, respR}
+	err = cmd.Start()
+	// the child has its own copies now.
+	_ = reqW.Close()
+	_ = respR.Close()
+	if err != nil {
+		return fmt.Errorf("git receive-pack: %w", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		serveHooks(s, repo, h, reqR, respW)
+	}()
+	err = cmd.Wait()
+	// unblocks the hooks server in case a hook process is still around.
+	_ = reqR.Close()
+	<-done
+	if err != nil {
+		return fmt.Errorf("git receive-pack: %w", err)
+	}
+	return nil
+}
+
+// writeHooks writes the forwarding hooks to dir, along with links to the
+// other hooks of the repository, which would be ignored otherwise.
+func writeHooks(dir, repoHooksDir string) error {
+	for _, name := range receiveHookNames {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(hookScript), 0o700); err != nil { //nolint:gosec
+			return fmt.Errorf("write hook: %w", err)
+		}
+	}
+	entries, err := os.ReadDir(repoHooksDir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("read hooks: %w", err)
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasSuffix(name, ".sample") || isReceiveHook(name) {
+			continue
+		}
+		if err := os.Symlink(filepath.Join(repoHooksDir, name), filepath.Join(dir, name)); err != nil {
+			return fmt.Errorf("link hook: %w", err)
+		}
+	}
+	return nil
+}
+
+func isReceiveHook(name string) bool {
+	for _, n := range receiveHookNames {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// serveHooks answers the requests of the hook scripts until r is closed.
+func serveHooks(s ssh.Session, repo string, h ReceiveHooks, r io.Reader, w io.Writer) {
+	var updates []RefUpdate
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := sc.Text()
+		if args, ok := strings.CutPrefix(line, "ref "); ok {
+			update, err := parseRefUpdate(args)
+			if err != nil {
+				log.Error("invalid ref update from hook", "line", line, "error", err)
+				continue
+			}
+			updates = append(updates, update)
+			continue
+		}
+
+		var err error
+		switch line {
+		case "pre-receive":
+			err = h.PreReceive(s, repo, updates)
+		case "update":
+			if len(updates) != 1 {
+				err = ErrSystemMalfunction
+				break
+			}
+			err = h.Update(s, repo, updates[0])
+		case "post-receive":
+			h.PostReceive(s, repo, updates)
+		default:
+			err = fmt.Errorf("unknown hook: %q", line)
+		}
+		updates = nil
+
+		reply := "ok\n"
+		if err != nil {
+			reply = "reject " + strings.ReplaceAll(err.Error(), "\n", " ") + "\n"
+		}
+		if _, err := io.WriteString(w, reply); err != nil {
+			return
+		}
+	}
+}
+
+func parseRefUpdate(s string) (RefUpdate, error) {
+	parts := strings.SplitN(s, " ", 4)
+	if len(parts) != 4 {
+		return RefUpdate{}, fmt.Errorf("expected 4 fields, got %d", len(parts))
+	}
+	return RefUpdate{
+		Old:    plumbing.NewHash(parts[0]),
+		New:    plumbing.NewHash(parts[1]),
+		Forced: parts[2] == "1",
+		Ref:    plumbing.ReferenceName(parts[3]),
+	}, nil
+}
@@ -0,0 +1,166 @@
+package git
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	"charm.land/ssh"
+)
+
+// protectingHooks allow everything, but protect main from force pushes and
+// deletion, and reject the "blocked" branch and the "forbidden" tag.
+type protectingHooks struct {
+	sync.Mutex
+	received []RefUpdate
+}
+
+func (h *protectingHooks) AuthRepo(string, ssh.PublicKey) AccessLevel { return ReadWriteAccess }
+func (h *protectingHooks) Push(string, ssh.PublicKey)                 {}
+func (h *protectingHooks) Fetch(string, ssh.PublicKey)                {}
+
+func (h *protectingHooks) PreReceive(_ ssh.Session, _ string, updates []RefUpdate) error {
+	for _, u := range updates {
+		if u.Ref == "refs/tags/forbidden" {
+			return errors.New("forbidden tags are forbidden")
+		}
+	}
+	return nil
+}
+
+func (h *protectingHooks) Update(_ ssh.Session, _ string, u RefUpdate) error {
+	switch {
+	case u.Ref == "refs/heads/blocked":
+		return errors.New("blocked is blocked")
+	case u.Ref == "refs/heads/main" && (u.Forced || u.IsDelete()):
+		return errors.New("main is protected")
+	}
+	return nil
+}
+
+func (h *protectingHooks) PostReceive(_ ssh.Session, _ string, updates []RefUpdate) {
+	h.Lock()
+	defer h.Unlock()
+	h.received = append(h.received, updates...)
+}
+
+func (h *protectingHooks) last(t *testing.T) RefUpdate {
+	t.Helper()
+	h.Lock()
+	defer h.Unlock()
+	if len(h.received) == 0 {
+		t.Fatal("expected a received update, got none")
+	}
+	return h.received[len(h.received)-1]
+}
+
+func TestReceiveHooks(t *testing.T) {
+	t.Run("exec", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("hooks require a shell")
+		}
+		testReceiveHooks(t, ExecBackend)
+	})
+	t.Run("go", func(t *testing.T) {
+		testReceiveHooks(t, GoBackend)
+	})
+}
+
+func testReceiveHooks(t *testing.T, backend Backend) {
+	repoDir := t.TempDir()
+	hooks := &protectingHooks{}
+	remote, pk := newTestServer(t, repoDir, hooks, WithBackend(backend))
+
+	cwd := t.TempDir()
+	requireNoError(t, runGitHelper(t, pk, cwd, "init", "-b", "main"))
+	requireNoError(t, runGitHelper(t, pk, cwd, "remote", "add", "origin", remote+"/repo1"))
+	requireNoError(t, runGitHelper(t, pk, cwd, "commit", "--allow-empty", "-m", "initial commit"))
+
+	t.Run("create", func(t *testing.T) {
+		requireNoError(t, runGitHelper(t, pk, cwd, "push", "origin", "main"))
+		u := hooks.last(t)
+		if u.Ref != "refs/heads/main" || !u.IsCreate() || u.Forced {
+			t.Fatalf("unexpected update: %+v", u)
+		}
+	})
+
+	t.Run("fast-forward", func(t *testing.T) {
+		requireNoError(t, runGitHelper(t, pk, cwd, "commit", "--allow-empty", "-m", "second commit"))
+		requireNoError(t, runGitHelper(t, pk, cwd, "push", "origin", "main"))
+		u := hooks.last(t)
+		if u.IsCreate() || u.IsDelete() || u.Forced {
+			t.Fatalf("unexpected update: %+v", u)
+		}
+	})
+
+	t.Run("force push", func(t *testing.T) {
+		requireNoError(t, runGitHelper(t, pk, cwd, "commit", "--amend", "--allow-empty", "-m", "amended"))
+		out, err := runGitOutput(t, pk, cwd, "push", "--force", "origin", "main")
+		requireError(t, err)
+		requireContains(t, out, "main is protected")
+		requireNoError(t, runGitHelper(t, pk, cwd, "reset", "--hard", "origin/main"))
+	})
+
+	t.Run("reject a single ref", func(t *testing.T) {
+		requireNoError(t, runGitHelper(t, pk, cwd, "branch", "blocked"))
+		requireNoError(t, runGitHelper(t, pk, cwd, "branch", "other"))
+		out, err := runGitOutput(t, pk, cwd, "push", "origin", "blocked", "other")
+		requireError(t, err)
+		requireContains(t, out, "blocked is blocked")
+
+		out, err = runGitOutput(t, pk, cwd, "ls-remote", "origin")
+		requireNoError(t, err)
+		requireContains(t, out, "refs/heads/other")
+		if strings.Contains(out, "refs/heads/blocked") {
+			t.Fatalf("expected blocked to be rejected, got %q", out)
+		}
+	})
+
+	t.Run("reject the whole push", func(t *testing.T) {
+		requireNoError(t, runGitHelper(t, pk, cwd, "tag", "forbidden"))
+		requireNoError(t, runGitHelper(t, pk, cwd, "tag", "allowed"))
+		out, err := runGitOutput(t, pk, cwd, "push", "origin", "forbidden", "allowed")
+		requireError(t, err)
+		requireContains(t, out, "forbidden tags are forbidden")
+
+		out, err = runGitOutput(t, pk, cwd, "ls-remote", "origin")
+		requireNoError(t, err)
+		if strings.Contains(out, "refs/tags/") {
+			t.Fatalf("expected no tags, got %q", out)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		requireNoError(t, runGitHelper(t, pk, cwd, "push", "origin", "--delete", "other"))
+		u := hooks.last(t)
+		if u.Ref != "refs/heads/other" || !u.IsDelete() {
+			t.Fatalf("unexpected update: %+v", u)
+		}
+	})
+
+	t.Run("repository hooks still run", func(t *testing.T) {
+		if backend == GoBackend {
+			t.Skip("the go backend doesn't run repository hooks")
+		}
+		hook := filepath.Join(repoDir, "repo1", "hooks", "post-receive")
+		requireNoError(t, os.MkdirAll(filepath.Dir(hook), 0o755))
+		requireNoError(t, os.WriteFile(hook, []byte("#!/bin/sh\ncat > received\n"), 0o755)) //nolint:gosec
+		requireNoError(t, runGitHelper(t, pk, cwd, "push", "origin", "allowed"))
+
+		bts, err := os.ReadFile(filepath.Join(repoDir, "repo1", "received"))
+		requireNoError(t, err)
+		requireContains(t, string(bts), "refs/tags/allowed")
+	})
+}
+
+func requireContains(t *testing.T, s, substr string) {
+	t.Helper()
+
+	if !strings.Contains(s, substr) {
+		t.Fatalf("expected %q to contain %q", s, substr)
+	}
+}
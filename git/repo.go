@@ -0,0 +1,124 @@
+package git
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+
+	"charm.land/ssh"
+)
+
+// RepoResolver maps the repo requested by a client to the name of the repo,
+// passed to the Hooks, and its path on disk.
+type RepoResolver interface {
+	// ResolveRepo resolves the repo requested by the session within repoDir.
+	// Returned errors are shown to the client.
+	ResolveRepo(s ssh.Session, repoDir, repo string) (name, path string, err error)
+}
+
+// PathResolver is a RepoResolver storing repos in the repo directory, at the
+// path requested by the client.
+//
+// The zero value allows arbitrary nesting and uses the requested repos as is,
+// so e.g. "repo" and "repo.git" are different repos.
+type PathResolver struct {
+	// MaxDepth is the maximum number of path elements of a repo, e.g. 2 for
+	// "user/repo". Zero means no limit.
+	MaxDepth int
+
+	// GitSuffix makes the .git suffix optional: it's trimmed from the repo
+	// names, and added to the repo paths on disk.
+	GitSuffix bool
+
+	// UserDir enables per-user namespaces, e.g. "~alice/repo", stored in the
+	// given directory of the repo directory. "~/repo" is in the namespace of
+	// the session's user.
+	UserDir string
+}
+
+// DefaultResolver is the RepoResolver used by default, allowing repos in the
+// form of "repo" or "user/repo".
+var DefaultResolver RepoResolver = PathResolver{MaxDepth: 2}
+
+// ResolveRepo implements RepoResolver.
+func (r PathResolver) ResolveRepo(s ssh.Session, repoDir, repo string) (string, string, error) {
+	name := path.Clean(strings.Trim(repo, "/"))
+	if r.GitSuffix {
+		name = strings.TrimSuffix(name, ".git")
+	}
+	elems := strings.Split(name, "/")
+	for _, elem := range elems {
+		if elem == "" || elem == "." || elem == ".." {
+			return "", "", ErrInvalidRepo
+		}
+	}
+	if r.MaxDepth > 0 && len(elems) > r.MaxDepth {
+		return "", "", ErrInvalidRepo
+	}
+
+	dir, rel := repoDir, name
+	if user, ok := strings.CutPrefix(elems[0], "~"); ok && r.UserDir != "" {
+		if user == "" {
+			user = s.User()
+			name = "~" + user + strings.TrimPrefix(name, "~")
+		}
+		if len(elems) < 2 || user == "" || user == "." || user == ".." || strings.ContainsAny(user, `/\`) {
+			return "", "", ErrInvalidRepo
+		}
+		dir = filepath.Join(repoDir, r.UserDir, user)
+		rel = path.Join(elems[1:]...)
+	} else if r.UserDir != "" && isWithin(name, path.Clean(filepath.ToSlash(r.UserDir))) {
+		// the namespaces can only be accessed with their ~user names.
+		return "", "", ErrInvalidRepo
+	}
+	if r.GitSuffix {
+		rel += ".git"
+	}
+	return name, filepath.Join(dir, filepath.FromSlash(rel)), nil
+}
+
+// RepoNamer can be implemented by a RepoResolver to name the repos found on
+// disk, e.g. to list them.
+type RepoNamer interface {
+	// RepoName returns the name of the repo at the given path within repoDir,
+	// and false if the path can't be requested by clients.
+	RepoName(repoDir, path string) (string, bool)
+}
+
+// RepoName implements RepoNamer.
+func (r PathResolver) RepoName(repoDir, p string) (string, bool) {
+	rel, err := filepath.Rel(repoDir, p)
+	if err != nil {
+		return "", false
+	}
+	name := filepath.ToSlash(rel)
+	if r.GitSuffix {
+		var ok bool
+		if name, ok = strings.CutSuffix(name, ".git"); !ok {
+			return "", false
+		}
+	}
+	if ud := path.Clean(filepath.ToSlash(r.UserDir)); r.UserDir != "" && isWithin(name, ud) {
+		name = "~" + strings.TrimPrefix(name, ud+"/")
+	}
+	// the resolver is the source of truth on which names are valid.
+	if got, _, err := r.ResolveRepo(nil, repoDir, name); err != nil || got != name {
+		return "", false
+	}
+	return name, true
+}
+
+func isWithin(name, dir string) bool {
+	return name == dir || strings.HasPrefix(name, dir+"/")
+}
+
+// CreatePolicy decides whether a push may create a repo that doesn't exist
+// yet. Returning an error denies it, and its message is shown to the client.
+type CreatePolicy func(s ssh.Session, repo string) error
+
+// AllowCreate is a CreatePolicy allowing pushes to create any repo. It's the
+// default.
+func AllowCreate(ssh.Session, string) error { return nil }
+
+// DenyCreate is a CreatePolicy denying the creation of repos by pushes.
+func DenyCreate(ssh.Session, string) error { return ErrInvalidRepo }
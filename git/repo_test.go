@@ -0,0 +1,129 @@
+package git
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"charm.land/ssh"
+)
+
+type userSession struct {
+	ssh.Session
+	user string
+}
+
+func (s userSession) User() string { return s.user }
+
+func TestPathResolver(t *testing.T) {
+	dir := filepath.FromSlash("/srv/git")
+	for name, tc := range map[string]struct {
+		resolver PathResolver
+		repo     string
+		name     string
+		path     string
+	}{
+		"default":              {DefaultResolver.(PathResolver), "/repo1.git", "repo1.git", "repo1.git"},
+		"default user":         {DefaultResolver.(PathResolver), "abc/repo1", "abc/repo1", "abc/repo1"},
+		"default too deep":     {DefaultResolver.(PathResolver), "a/b/repo1", "", ""},
+		"nested":               {PathResolver{}, "/a/b/c/repo1/", "a/b/c/repo1", "a/b/c/repo1"},
+		"traversal":            {PathResolver{}, "../repo1", "", ""},
+		"inner traversal":      {PathResolver{}, "a/../../repo1", "", ""},
+		"empty":                {PathResolver{}, "/", "", ""},
+		"suffix":               {PathResolver{GitSuffix: true}, "a/repo1.git", "a/repo1", "a/repo1.git"},
+		"no suffix":            {PathResolver{GitSuffix: true}, "a/repo1", "a/repo1", "a/repo1.git"},
+		"only suffix":          {PathResolver{GitSuffix: true}, "a/.git", "", ""},
+		"namespace":            {PathResolver{UserDir: "users"}, "~bob/repo1", "~bob/repo1", "users/bob/repo1"},
+		"own namespace":        {PathResolver{UserDir: "users"}, "~/a/repo1", "~alice/a/repo1", "users/alice/a/repo1"},
+		"namespace and suffix": {PathResolver{UserDir: "users", GitSuffix: true}, "~bob/repo1.git", "~bob/repo1", "users/bob/repo1.git"},
+		"namespace only":       {PathResolver{UserDir: "users"}, "~bob", "", ""},
+		"namespace traversal":  {PathResolver{UserDir: "users"}, "~../repo1", "", ""},
+		"namespace dir":        {PathResolver{UserDir: "users"}, "users/bob/repo1", "", ""},
+		"namespaces disabled":  {PathResolver{}, "~bob/repo1", "~bob/repo1", "~bob/repo1"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			gotName, gotPath, err := tc.resolver.ResolveRepo(userSession{user: "alice"}, dir, tc.repo)
+			if tc.name == "" {
+				if !errors.Is(err, ErrInvalidRepo) {
+					t.Fatalf("expected ErrInvalidRepo, got %q %q %v", gotName, gotPath, err)
+				}
+				return
+			}
+			requireNoError(t, err)
+			if gotName != tc.name {
+				t.Errorf("expected name %q, got %q", tc.name, gotName)
+			}
+			if want := filepath.Join(dir, filepath.FromSlash(tc.path)); gotPath != want {
+				t.Errorf("expected path %q, got %q", want, gotPath)
+			}
+		})
+	}
+}
+
+func TestResolverAndCreatePolicy(t *testing.T) {
+	repoDir := t.TempDir()
+	remote, pk := newTestServer(t, repoDir, &protectingHooks{},
+		WithResolver(PathResolver{GitSuffix: true, UserDir: "users"}),
+		WithCreatePolicy(func(s ssh.Session, repo string) error {
+			if !strings.HasPrefix(repo, "~"+s.User()+"/") {
+				return errors.New("repos can only be created in your namespace")
+			}
+			return nil
+		}),
+	)
+	alice := strings.Replace(remote, "ssh://", "ssh://alice@", 1)
+
+	cwd := t.TempDir()
+	requireNoError(t, runGitHelper(t, pk, cwd, "init", "-b", "main"))
+	requireNoError(t, runGitHelper(t, pk, cwd, "commit", "--allow-empty", "-m", "initial commit"))
+
+	t.Run("create in own namespace", func(t *testing.T) {
+		requireNoError(t, runGitHelper(t, pk, cwd, "push", alice+"/~/a/repo1", "main"))
+		exists, err := fileExists(filepath.Join(repoDir, "users", "alice", "a", "repo1.git"))
+		requireNoError(t, err)
+		if !exists {
+			t.Fatal("expected the repo to be created")
+		}
+	})
+
+	t.Run("fetch with another name", func(t *testing.T) {
+		requireNoError(t, runGitHelper(t, pk, cwd, "ls-remote", alice+"/~alice/a/repo1.git"))
+	})
+
+	t.Run("create elsewhere", func(t *testing.T) {
+		out, err := runGitOutput(t, pk, cwd, "push", alice+"/a/repo1", "main")
+		requireError(t, err)
+		requireContains(t, out, "repos can only be created in your namespace")
+	})
+
+	t.Run("invalid repo", func(t *testing.T) {
+		out, err := runGitOutput(t, pk, cwd, "push", alice+"/users/alice/a/repo1", "main")
+		requireError(t, err)
+		requireContains(t, out, ErrInvalidRepo.Error())
+	})
+
+	t.Run("deny", func(t *testing.T) {
+		remote, pk := newTestServer(t, repoDir, &protectingHooks{}, WithCreatePolicy(DenyCreate))
+		out, err := runGitOutput(t, pk, cwd, "push", remote+"/repo2", "main")
+		requireError(t, err)
+		requireContains(t, out, ErrInvalidRepo.Error())
+	})
+}
+
+func TestPathResolverRepoName(t *testing.T) {
+	dir := filepath.FromSlash("/srv/git")
+	r := PathResolver{GitSuffix: true, UserDir: "users"}
+	for path, expected := range map[string]string{
+		"a/repo1.git":         "a/repo1",
+		"users/bob/repo1.git": "~bob/repo1",
+		"a/repo1":             "",
+		"users/repo1.git":     "",
+		"../repo1.git":        "",
+	} {
+		name, ok := r.RepoName(dir, filepath.Join(dir, filepath.FromSlash(path)))
+		if name != expected || ok != (expected != "") {
+			t.Errorf("%s: expected %q, got %q %v", path, expected, name, ok)
+		}
+	}
+}
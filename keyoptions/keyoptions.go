@@ -0,0 +1,166 @@
+// Package keyoptions enforces the options of the authorized_keys file used by
+// wish.WithAuthorizedKeys or wish.WithKeyring, and of the certificates allowed
+// by wish.WithTrustedUserCAKeys, as sshd does, so existing authorized_keys
+// files and certificates can be reused as is.
+//
+// The from and expiry-time key options, and the source-address certificate
+// option, are enforced during authentication. The command key option and the
+// force-command certificate option, allowed with WithForceCommand, are
+// enforced by Middleware, and the
+// restrict, no-pty, pty, no-port-forwarding and port-forwarding key options,
+// and the permit-pty and permit-port-forwarding certificate extensions, by
+// WithRestrictions.
+//
+// The options are those of the public key that authenticated the session.
+// Sessions authenticated with a certificate that wasn't allowed by
+// wish.WithTrustedUserCAKeys are denied, as its options are unknown.
+package keyoptions
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+
+	"charm.land/ssh"
+	"charm.land/wish/v2"
+	"github.com/anmitsu/go-shlex"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// Middleware runs the command forced by the command option of the session key,
+// or by the force-command option of its certificate, instead of the requested
+// one, which is available in the SSH_ORIGINAL_COMMAND environment variable, as
+// with sshd.
+//
+// It should be the last middleware, so it's executed first. Subsystems, e.g.
+// SFTP, are not handled by middlewares, and are not forced.
+func Middleware() wish.Middleware {
+	return func(next ssh.Handler) ssh.Handler {
+		return func(s ssh.Session) {
+			o, ok := resolve(s.Context(), s.PublicKey())
+			if !ok {
+				wish.Fatalln(s, errUnknownCertificate)
+				return
+			}
+			if o.forced {
+				s = forcedSession{Session: s, cmd: o.command}
+			}
+			next(s)
+		}
+	}
+}
+
+// WithForceCommand allows the certificates with the force-command critical
+// option in wish.WithTrustedUserCAKeys, which denies them otherwise. The
+// option is enforced by Middleware, which the server must use.
+func WithForceCommand() wish.CertOption {
+	return wish.WithCriticalOptions(certForceCommand)
+}
+
+const certForceCommand = "force-command"
+
+var errUnknownCertificate = errors.New("certificate not allowed by the server")
+
+// options are the options of the session key, or of its certificate.
+type options struct {
+	command        string
+	forced         bool
+	pty            bool
+	portForwarding bool
+}
+
+// resolve returns the options of the public key that authenticated the
+// session, if any: those of its certificate, when it's the one allowed by
+// wish.WithTrustedUserCAKeys, or those of its authorized key. It reports
+// false when the session used another certificate, whose options are
+// unknown, in which case everything must be denied.
+func resolve(ctx ssh.Context, key ssh.PublicKey) (options, bool) {
+	if cert, ok := key.(*gossh.Certificate); ok {
+		allowed, ok := wish.CertificateFromContext(ctx)
+		if !ok || !bytes.Equal(allowed.Marshal(), cert.Marshal()) {
+			return options{}, false
+		}
+		var o options
+		o.command, o.forced = cert.CriticalOptions[certForceCommand]
+		_, o.pty = cert.Extensions["permit-pty"]
+		_, o.portForwarding = cert.Extensions["permit-port-forwarding"]
+		return o, true
+	}
+
+	o := options{pty: true, portForwarding: true}
+	if ak, ok := wish.AuthorizedKeyFromContext(ctx); ok && key != nil && ssh.KeysEqual(key, ak.Key) {
+		o.command, o.forced = ak.Options.Command, ak.Options.Command != ""
+		o.pty = ak.Options.AllowPTY()
+		o.portForwarding = ak.Options.AllowPortForwarding()
+	}
+	return o, true
+}
+
+func sessionKey(ctx ssh.Context) ssh.PublicKey {
+	key, _ := ctx.Value(ssh.ContextKeyPublicKey).(ssh.PublicKey)
+	return key
+}
+
+// forcedSession is a session running a forced command.
+type forcedSession struct {
+	ssh.Session
+	cmd string
+}
+
+func (s forcedSession) RawCommand() string { return s.cmd }
+
+func (s forcedSession) Command() []string {
+	cmd, _ := shlex.Split(s.cmd, true)
+	return cmd
+}
+
+func (s forcedSession) Environ() []string {
+	var env []string
+	for _, kv := range s.Session.Environ() {
+		// clients may not set it themselves.
+		if !strings.HasPrefix(kv, "SSH_ORIGINAL_COMMAND=") {
+			env = append(env, kv)
+		}
+	}
+	if cmd := s.Session.RawCommand(); cmd != "" {
+		env = append(env, "SSH_ORIGINAL_COMMAND="+cmd)
+	}
+	return env
+}
+
+// WithRestrictions returns an ssh.Option denying PTYs and port forwarding to
+// the sessions whose key options disable them, or whose certificate doesn't
+// permit them.
+//
+// It wraps the PTY and port forwarding callbacks of the server, so it must
+// come after the options setting them. Port forwarding stays denied without
+// callbacks.
+func WithRestrictions() ssh.Option {
+	return func(s *ssh.Server) error {
+		ptyCb := s.PtyCallback
+		s.PtyCallback = func(ctx ssh.Context, pty ssh.Pty) bool {
+			return allowPTY(ctx) && (ptyCb == nil || ptyCb(ctx, pty))
+		}
+		if localCb := s.LocalPortForwardingCallback; localCb != nil {
+			s.LocalPortForwardingCallback = func(ctx ssh.Context, host string, port uint32) bool {
+				return allowPortForwarding(ctx) && localCb(ctx, host, port)
+			}
+		}
+		if reverseCb := s.ReversePortForwardingCallback; reverseCb != nil {
+			s.ReversePortForwardingCallback = func(ctx ssh.Context, host string, port uint32) bool {
+				return allowPortForwarding(ctx) && reverseCb(ctx, host, port)
+			}
+		}
+		return nil
+	}
+}
+
+func allowPTY(ctx ssh.Context) bool {
+	o, ok := resolve(ctx, sessionKey(ctx))
+	return ok && o.pty
+}
+
+func allowPortForwarding(ctx ssh.Context) bool {
+	o, ok := resolve(ctx, sessionKey(ctx))
+	return ok && o.portForwarding
+}
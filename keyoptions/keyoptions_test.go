@@ -0,0 +1,247 @@
+package keyoptions_test
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"charm.land/ssh"
+	"charm.land/wish/v2"
+	"charm.land/wish/v2/keyoptions"
+	"charm.land/wish/v2/testsession"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func TestKeyOptions(t *testing.T) {
+	t.Run("no options", func(t *testing.T) {
+		addr, cc := setup(t, "")
+		out := run(t, addr, cc, "ls -la", false)
+		requireEqual(t, `["ls" "-la"] "spoofed" false`, out)
+		requireForwarding(t, addr, cc, true)
+	})
+
+	t.Run("command", func(t *testing.T) {
+		addr, cc := setup(t, `command="echo \"hello world\""`)
+		requireEqual(t, `["echo" "hello world"] "ls -la" false`, run(t, addr, cc, "ls -la", false))
+		requireEqual(t, `["echo" "hello world"] "" false`, run(t, addr, cc, "", false))
+	})
+
+	t.Run("no-pty", func(t *testing.T) {
+		addr, cc := setup(t, "no-pty")
+		requirePTYDenied(t, addr, cc)
+		requireForwarding(t, addr, cc, true)
+	})
+
+	t.Run("no-port-forwarding", func(t *testing.T) {
+		addr, cc := setup(t, "no-port-forwarding")
+		requireEqual(t, `[] "spoofed" true`, run(t, addr, cc, "", true))
+		requireForwarding(t, addr, cc, false)
+	})
+
+	t.Run("restrict", func(t *testing.T) {
+		addr, cc := setup(t, "restrict")
+		requirePTYDenied(t, addr, cc)
+		requireForwarding(t, addr, cc, false)
+	})
+
+	t.Run("restrict,pty,port-forwarding", func(t *testing.T) {
+		addr, cc := setup(t, "restrict,pty,port-forwarding")
+		requireEqual(t, `[] "spoofed" true`, run(t, addr, cc, "", true))
+		requireForwarding(t, addr, cc, true)
+	})
+
+	t.Run("from", func(t *testing.T) {
+		addr, cc := setup(t, `from="192.0.2.0/24"`)
+		if _, err := testsession.NewClientSession(t, addr, cc); err == nil {
+			t.Fatal("expected the key to be denied")
+		}
+	})
+}
+
+// newCertSigner returns the signer of a certificate of foo forcing the echo
+// forced command.
+func newCertSigner(tb testing.TB) gossh.Signer {
+	tb.Helper()
+	ca, err := gossh.ParsePrivateKey(readFile(tb, "../testdata/ca"))
+	requireNoError(tb, err)
+	signer, err := gossh.ParsePrivateKey(readFile(tb, "../testdata/foo"))
+	requireNoError(tb, err)
+	cert := &gossh.Certificate{
+		Key:             signer.PublicKey(),
+		CertType:        gossh.UserCert,
+		ValidPrincipals: []string{"foo"},
+		ValidBefore:     gossh.CertTimeInfinity,
+		Permissions: gossh.Permissions{
+			CriticalOptions: map[string]string{"force-command": "echo forced"},
+		},
+	}
+	requireNoError(tb, cert.SignCert(rand.Reader, ca))
+	certSigner, err := gossh.NewCertSigner(cert, signer)
+	requireNoError(tb, err)
+	return certSigner
+}
+
+func TestCertificateOptions(t *testing.T) {
+	certSigner := newCertSigner(t)
+	srv, err := wish.NewServer(
+		wish.WithHostKeyPath(filepath.Join(t.TempDir(), "id_ed25519")),
+		wish.WithTrustedUserCAKeys("../testdata/ca.pub", keyoptions.WithForceCommand()),
+		wish.WithMiddleware(report, keyoptions.Middleware()),
+		keyoptions.WithRestrictions(),
+	)
+	requireNoError(t, err)
+	addr := testsession.Listen(t, srv)
+	cc := &gossh.ClientConfig{
+		User:            "foo",
+		Auth:            []gossh.AuthMethod{gossh.PublicKeys(certSigner)},
+		HostKeyCallback: gossh.InsecureIgnoreHostKey(), //nolint:gosec
+	}
+
+	requireEqual(t, `["echo" "forced"] "ls" false`, run(t, addr, cc, "ls", false))
+	// without the permit-pty extension.
+	requirePTYDenied(t, addr, cc)
+}
+
+func TestUnknownCertificate(t *testing.T) {
+	// the certificate is accepted, but not by WithTrustedUserCAKeys.
+	srv, err := wish.NewServer(
+		wish.WithHostKeyPath(filepath.Join(t.TempDir(), "id_ed25519")),
+		wish.WithPublicKeyAuth(func(ssh.Context, ssh.PublicKey) bool { return true }),
+		wish.WithMiddleware(report, keyoptions.Middleware()),
+		keyoptions.WithRestrictions(),
+	)
+	requireNoError(t, err)
+	addr := testsession.Listen(t, srv)
+	cc := &gossh.ClientConfig{
+		User:            "foo",
+		Auth:            []gossh.AuthMethod{gossh.PublicKeys(newCertSigner(t))},
+		HostKeyCallback: gossh.InsecureIgnoreHostKey(), //nolint:gosec
+	}
+
+	requirePTYDenied(t, addr, cc)
+	sess, err := testsession.NewClientSession(t, addr, cc)
+	requireNoError(t, err)
+	out, err := sess.CombinedOutput("ls")
+	if err == nil {
+		t.Fatalf("expected the session to be denied, got %q", out)
+	}
+	requireContains(t, string(out), "certificate not allowed")
+}
+
+func setup(tb testing.TB, options string) (string, *gossh.ClientConfig) {
+	tb.Helper()
+	pub, err := os.ReadFile("../testdata/foo.pub")
+	requireNoError(tb, err)
+	path := filepath.Join(tb.TempDir(), "authorized_keys")
+	requireNoError(tb, os.WriteFile(path, []byte(strings.TrimSpace(options+" "+string(pub))), 0o600))
+
+	srv, err := wish.NewServer(
+		wish.WithHostKeyPath(filepath.Join(tb.TempDir(), "id_ed25519")),
+		wish.WithAuthorizedKeys(path),
+		wish.WithMiddleware(report, keyoptions.Middleware()),
+		func(s *ssh.Server) error {
+			s.LocalPortForwardingCallback = func(ssh.Context, string, uint32) bool { return true }
+			s.ChannelHandlers = map[string]ssh.ChannelHandler{
+				"session":      ssh.DefaultSessionHandler,
+				"direct-tcpip": ssh.DirectTCPIPHandler,
+			}
+			return nil
+		},
+		keyoptions.WithRestrictions(),
+	)
+	requireNoError(tb, err)
+	signer, err := gossh.ParsePrivateKey(readFile(tb, "../testdata/foo"))
+	requireNoError(tb, err)
+	return testsession.Listen(tb, srv), &gossh.ClientConfig{
+		User:            "foo",
+		Auth:            []gossh.AuthMethod{gossh.PublicKeys(signer)},
+		HostKeyCallback: gossh.InsecureIgnoreHostKey(), //nolint:gosec
+	}
+}
+
+// report writes the command, the original command and whether the session
+// has a PTY.
+func report(ssh.Handler) ssh.Handler {
+	return func(s ssh.Session) {
+		var original string
+		for _, kv := range s.Environ() {
+			if v, ok := strings.CutPrefix(kv, "SSH_ORIGINAL_COMMAND="); ok {
+				original = v
+			}
+		}
+		_, _, pty := s.Pty()
+		fmt.Fprintf(s, "%q %q %v", s.Command(), original, pty)
+	}
+}
+
+func run(tb testing.TB, addr string, cc *gossh.ClientConfig, cmd string, pty bool) string {
+	tb.Helper()
+	sess, err := testsession.NewClientSession(tb, addr, cc)
+	requireNoError(tb, err)
+	if pty {
+		requireNoError(tb, sess.RequestPty("xterm", 24, 80, gossh.TerminalModes{}))
+	}
+	sess.Setenv("SSH_ORIGINAL_COMMAND", "spoofed") //nolint:errcheck
+	out, err := sess.Output(cmd)
+	requireNoError(tb, err)
+	return strings.ReplaceAll(string(out), "\r", "")
+}
+
+func requirePTYDenied(tb testing.TB, addr string, cc *gossh.ClientConfig) {
+	tb.Helper()
+	sess, err := testsession.NewClientSession(tb, addr, cc)
+	requireNoError(tb, err)
+	if err := sess.RequestPty("xterm", 24, 80, gossh.TerminalModes{}); err == nil {
+		tb.Fatal("expected the PTY to be denied")
+	}
+}
+
+func requireForwarding(tb testing.TB, addr string, cc *gossh.ClientConfig, allowed bool) {
+	tb.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	requireNoError(tb, err)
+	tb.Cleanup(func() { _ = l.Close() })
+
+	client, err := gossh.Dial("tcp", addr, cc)
+	requireNoError(tb, err)
+	defer client.Close() //nolint:errcheck
+	conn, err := client.Dial("tcp", l.Addr().String())
+	if allowed {
+		requireNoError(tb, err)
+		_ = conn.Close()
+	} else if err == nil {
+		tb.Fatal("expected port forwarding to be denied")
+	}
+}
+
+func readFile(tb testing.TB, path string) []byte {
+	tb.Helper()
+	bts, err := os.ReadFile(path)
+	requireNoError(tb, err)
+	return bts
+}
+
+func requireEqual(tb testing.TB, expected, got any) {
+	tb.Helper()
+	if expected != got {
+		tb.Fatalf("expected %v, got %v", expected, got)
+	}
+}
+
+func requireNoError(tb testing.TB, err error) {
+	tb.Helper()
+	if err != nil {
+		tb.Fatalf("expected no error, got %v", err)
+	}
+}
+
+func requireContains(tb testing.TB, s, substr string) {
+	tb.Helper()
+	if !strings.Contains(s, substr) {
+		tb.Fatalf("expected %q to contain %q", s, substr)
+	}
+}
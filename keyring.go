@@ -0,0 +1,314 @@
+package wish
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"path"
+	"strings"
+	"time"
+
+	"charm.land/log/v2"
+	"charm.land/ssh"
+)
+
+// KeyOptions are the OpenSSH options of a key in an authorized_keys file.
+// See the AUTHORIZED_KEYS FILE FORMAT section of sshd(8).
+type KeyOptions struct {
+	// Command is the command forced by the command option.
+	Command string
+
+	// From are the patterns of the from option, e.g. 192.0.2.0/24 or
+	// 198.51.100.*, prefixed with ! if negated.
+	From []string
+
+	// Environment are the variables set by the environment options, as
+	// NAME=value.
+	Environment []string
+
+	// ExpiryTime is the time after which the key is not authorized anymore,
+	// zero if it doesn't expire.
+	ExpiryTime time.Time
+
+	// Restrict disables PTY allocation and forwarding, unless enabled again
+	// by the pty, port-forwarding or agent-forwarding options.
+	Restrict bool
+
+	// NoPTY disables PTY allocation, and PTY enables it again after Restrict.
+	NoPTY, PTY bool
+
+	// NoPortForwarding disables port forwarding, and PortForwarding enables
+	// it again after Restrict.
+	NoPortForwarding, PortForwarding bool
+
+	// NoAgentForwarding disables agent forwarding, and AgentForwarding
+	// enables it again after Restrict.
+	NoAgentForwarding, AgentForwarding bool
+
+	// Other are the options not listed above, as they were written.
+	Other []string
+}
+
+// AllowPTY reports whether the options allow PTY allocation.
+func (o KeyOptions) AllowPTY() bool {
+	return !o.NoPTY && (!o.Restrict || o.PTY)
+}
+
+// AllowPortForwarding reports whether the options allow port forwarding.
+func (o KeyOptions) AllowPortForwarding() bool {
+	return !o.NoPortForwarding && (!o.Restrict || o.PortForwarding)
+}
+
+// AllowAgentForwarding reports whether the options allow agent forwarding.
+func (o KeyOptions) AllowAgentForwarding() bool {
+	return !o.NoAgentForwarding && (!o.Restrict || o.AgentForwarding)
+}
+
+// AllowFrom reports whether the from option allows the given client address.
+// As with sshd, the patterns match IP addresses, with * and ? wildcards, or
+// CIDR ranges, and a negated pattern denies the addresses it matches even if
+// another pattern allows them. Host names are not resolved.
+func (o KeyOptions) AllowFrom(addr net.Addr) bool {
+	if len(o.From) == 0 {
+		return true
+	}
+	if addr == nil {
+		return false
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		host = addr.String()
+	}
+	ip := net.ParseIP(host)
+	allowed := false
+	for _, pattern := range o.From {
+		pattern, negated := strings.CutPrefix(pattern, "!")
+		if !matchAddress(pattern, host, ip) {
+			continue
+		}
+		if negated {
+			return false
+		}
+		allowed = true
+	}
+	return allowed
+}
+
+func matchAddress(pattern, host string, ip net.IP) bool {
+	if _, cidr, err := net.ParseCIDR(pattern); err == nil {
+		return ip != nil && cidr.Contains(ip)
+	}
+	ok, _ := path.Match(pattern, host)
+	return ok
+}
+
+// Expired reports whether the key is expired at the given time.
+func (o KeyOptions) Expired(t time.Time) bool {
+	return !o.ExpiryTime.IsZero() && t.After(o.ExpiryTime)
+}
+
+// AuthorizedKey is a key of an authorized_keys file.
+type AuthorizedKey struct {
+	// Key is the public key.
+	Key ssh.PublicKey
+
+	// Comment is the comment following the key, usually identifying its
+	// owner.
+	Comment string
+
+	// Options are the options preceding the key.
+	Options KeyOptions
+}
+
+// Keyring is the set of keys of an authorized_keys file, parsed once and
+// reloaded whenever the file changes.
+//
+// Invalid lines are skipped with a warning, and a missing or unreadable file
+// authorizes no keys until it's fixed.
+type Keyring struct {
+	file fileCache[[]AuthorizedKey]
+}
+
+// NewKeyring returns the Keyring of the given authorized_keys file, which
+// must exist.
+func NewKeyring(path string) (*Keyring, error) {
+	k := &Keyring{file: fileCache[[]AuthorizedKey]{
+		path: path,
+		parse: func(bts []byte) ([]AuthorizedKey, error) {
+			return parseAuthorizedKeys(path, bts), nil
+		},
+	}}
+	if err := k.Reload(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Keys returns the keys of the file, as of its last change.
+func (k *Keyring) Keys() []AuthorizedKey {
+	keys, _ := k.file.get()
+	return keys
+}
+
+// Lookup returns the first key of the file equal to the given one which is
+// not expired.
+func (k *Keyring) Lookup(key ssh.PublicKey) (AuthorizedKey, bool) {
+	return k.lookup(key, nil)
+}
+
+// lookup is like Lookup, also checking that the from option allows the
+// given address if not nil.
+func (k *Keyring) lookup(key ssh.PublicKey, addr net.Addr) (AuthorizedKey, bool) {
+	now := time.Now()
+	for _, ak := range k.Keys() {
+		if !ssh.KeysEqual(key, ak.Key) || ak.Options.Expired(now) {
+			continue
+		}
+		if addr != nil && !ak.Options.AllowFrom(addr) {
+			continue
+		}
+		return ak, true
+	}
+	return AuthorizedKey{}, false
+}
+
+// Reload parses the file again, even if it didn't change. The keys are
+// swapped at once, so concurrent lookups see either the old or the new ones.
+// On error, no keys are authorized.
+func (k *Keyring) Reload() error {
+	_, err := k.file.reload()
+	return err
+}
+
+// parseAuthorizedKeys parses the lines of an authorized_keys file, skipping
+// the invalid ones with a warning.
+func parseAuthorizedKeys(path string, bts []byte) []AuthorizedKey {
+	var keys []AuthorizedKey
+	sc := bufio.NewScanner(bytes.NewReader(bts))
+	sc.Buffer(nil, 1<<20)
+	for n := 1; sc.Scan(); n++ {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		ak, err := parseAuthorizedKey(line)
+		if err != nil {
+			log.Warn("skipping invalid authorized key", "path", path, "line", n, "error", err)
+			continue
+		}
+		keys = append(keys, ak)
+	}
+	if err := sc.Err(); err != nil {
+		log.Warn("failed to parse", "path", path, "error", err)
+	}
+	return keys
+}
+
+func parseAuthorizedKey(line []byte) (AuthorizedKey, error) {
+	key, comment, opts, _, err := ssh.ParseAuthorizedKey(line)
+	if err != nil {
+		return AuthorizedKey{}, err //nolint:wrapcheck
+	}
+	options, err := parseKeyOptions(opts)
+	if err != nil {
+		return AuthorizedKey{}, err
+	}
+	return AuthorizedKey{Key: key, Comment: comment, Options: options}, nil
+}
+
+func parseKeyOptions(opts []string) (KeyOptions, error) {
+	var o KeyOptions
+	for _, opt := range opts {
+		name, value, hasValue := strings.Cut(opt, "=")
+		if hasValue {
+			var err error
+			value, err = unquoteOption(value)
+			if err != nil {
+				return o, fmt.Errorf("option %s: %w", name, err)
+			}
+		}
+		switch strings.ToLower(name) {
+		case "command":
+			o.Command = value
+		case "from":
+			o.From = strings.Split(value, ",")
+		case "environment":
+			if !strings.Contains(value, "=") {
+				return o, fmt.Errorf("option %s: missing =", name)
+			}
+			o.Environment = append(o.Environment, value)
+		case "expiry-time":
+			t, err := parseExpiryTime(value)
+			if err != nil {
+				return o, fmt.Errorf("option %s: %w", name, err)
+			}
+			o.ExpiryTime = t
+		case "restrict":
+			o.Restrict = true
+		case "no-pty":
+			o.NoPTY = true
+		case "pty":
+			o.PTY = true
+		case "no-port-forwarding":
+			o.NoPortForwarding = true
+		case "port-forwarding":
+			o.PortForwarding = true
+		case "no-agent-forwarding":
+			o.NoAgentForwarding = true
+		case "agent-forwarding":
+			o.AgentForwarding = true
+		default:
+			o.Other = append(o.Other, opt)
+		}
+	}
+	return o, nil
+}
+
+// unquoteOption unquotes the value of an option, where only \" is escaped.
+func unquoteOption(value string) (string, error) {
+	if len(value) < 2 || value[0] != '"' || value[len(value)-1] != '"' {
+		return "", errors.New("value must be quoted")
+	}
+	return strings.ReplaceAll(value[1:len(value)-1], `\"`, `"`), nil
+}
+
+// parseExpiryTime parses a YYYYMMDD[HHMM[SS]] time, in the local time zone
+// unless suffixed with Z.
+func parseExpiryTime(value string) (time.Time, error) {
+	loc := time.Local
+	if v, ok := strings.CutSuffix(value, "Z"); ok {
+		value, loc = v, time.UTC
+	}
+	for _, layout := range []string{"20060102", "200601021504", "20060102150405"} {
+		if len(value) == len(layout) {
+			t, err := time.ParseInLocation(layout, value, loc)
+			if err != nil {
+				return time.Time{}, fmt.Errorf("parse time: %w", err)
+			}
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", value)
+}
+
+type contextKey struct {
+	name string
+}
+
+var contextKeyAuthorizedKey = &contextKey{"authorized-key"}
+
+// AuthorizedKeyFromContext returns the key of the authorized_keys file that
+// authenticated the session, when using WithAuthorizedKeys or WithKeyring.
+// Middlewares may use its options, e.g. to force its command.
+func AuthorizedKeyFromContext(ctx ssh.Context) (AuthorizedKey, bool) {
+	ak, ok := ctx.Value(contextKeyAuthorizedKey).(AuthorizedKey)
+	key, _ := ctx.Value(ssh.ContextKeyPublicKey).(ssh.PublicKey)
+	if !ok || key == nil || !ssh.KeysEqual(key, ak.Key) {
+		// set by an attempt with another key, which may not have been
+		// verified.
+		return AuthorizedKey{}, false
+	}
+	return ak, true
+}
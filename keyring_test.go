@@ -0,0 +1,246 @@
+package wish
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"charm.land/ssh"
+	"charm.land/wish/v2/testsession"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// testContext is a ssh.Context only holding values.
+type testContext struct {
+	ssh.Context
+	values map[any]any
+}
+
+func (c *testContext) Value(key any) any { return c.values[key] }
+
+func (c *testContext) User() string {
+	user, _ := c.values[ssh.ContextKeyUser].(string)
+	return user
+}
+
+func (c *testContext) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 22}
+}
+
+func (c *testContext) SetValue(key, value any) {
+	if c.values == nil {
+		c.values = map[any]any{}
+	}
+	c.values[key] = value
+}
+
+const (
+	k1 = `ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIMJlb/qf2B2kMNdBxfpCQqI2ctPcsOkdZGVh5zTRhKtH k1@test`
+	k2 = `ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOhsthN+zSFSJF7V2HFSO4+2OJYRghuAA43CIbVyvzF8 k2@test`
+)
+
+func parseKey(tb testing.TB, line string) ssh.PublicKey {
+	tb.Helper()
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+	requireNoError(tb, err)
+	return key
+}
+
+func writeKeys(tb testing.TB, path string, lines ...string) {
+	tb.Helper()
+	var b bytes.Buffer
+	for _, line := range lines {
+		fmt.Fprintln(&b, line)
+	}
+	requireNoError(tb, os.WriteFile(path, b.Bytes(), 0o600))
+}
+
+func TestKeyring(t *testing.T) {
+	t.Run("skips invalid lines", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "authorized_keys")
+		writeKeys(t, path, string(getBytes(t, "testdata/invalid_authorized_keys")), k1, `command=nope `+k2, k2)
+		k, err := NewKeyring(path)
+		requireNoError(t, err)
+		keys := k.Keys()
+		requireEqual(t, 2, len(keys))
+		requireEqual(t, "k1@test", keys[0].Comment)
+		requireEqual(t, "k2@test", keys[1].Comment)
+	})
+
+	t.Run("file not found", func(t *testing.T) {
+		if _, err := NewKeyring("testdata/nope_authorized_keys"); err == nil {
+			t.Fatal("expected an error, got nil")
+		}
+	})
+
+	t.Run("reloads", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "authorized_keys")
+		writeKeys(t, path, k1)
+		k, err := NewKeyring(path)
+		requireNoError(t, err)
+		_, ok := k.Lookup(parseKey(t, k1))
+		requireEqual(t, true, ok)
+		_, ok = k.Lookup(parseKey(t, k2))
+		requireEqual(t, false, ok)
+
+		writeKeys(t, path, "# rotated", k2)
+		_, ok = k.Lookup(parseKey(t, k1))
+		requireEqual(t, false, ok)
+		_, ok = k.Lookup(parseKey(t, k2))
+		requireEqual(t, true, ok)
+
+		requireNoError(t, os.Remove(path))
+		_, ok = k.Lookup(parseKey(t, k2))
+		requireEqual(t, false, ok)
+
+		writeKeys(t, path, k1)
+		_, ok = k.Lookup(parseKey(t, k1))
+		requireEqual(t, true, ok)
+	})
+
+	t.Run("from", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "authorized_keys")
+		writeKeys(t, path, `from="10.0.0.0/8" `+k1, `from="10.0.0.0/8" `+k2, `from="127.0.0.1" `+k2)
+		s := &ssh.Server{}
+		requireNoError(t, WithAuthorizedKeys(path)(s))
+		requireEqual(t, false, s.PublicKeyHandler(&testContext{}, parseKey(t, k1)))
+		requireEqual(t, true, s.PublicKeyHandler(&testContext{}, parseKey(t, k2)))
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "authorized_keys")
+		writeKeys(t, path, `expiry-time="20200101" `+k1, `expiry-time="29990101Z" `+k2)
+		k, err := NewKeyring(path)
+		requireNoError(t, err)
+		_, ok := k.Lookup(parseKey(t, k1))
+		requireEqual(t, false, ok)
+		_, ok = k.Lookup(parseKey(t, k2))
+		requireEqual(t, true, ok)
+	})
+}
+
+func TestParseKeyOptions(t *testing.T) {
+	for name, tt := range map[string]struct {
+		opts     []string
+		expected KeyOptions
+	}{
+		"none": {},
+		"command": {
+			opts:     []string{`command="echo \"hi\""`, "no-pty"},
+			expected: KeyOptions{Command: `echo "hi"`, NoPTY: true},
+		},
+		"from": {
+			opts:     []string{`from="192.0.2.0/24,!*.example.com"`},
+			expected: KeyOptions{From: []string{"192.0.2.0/24", "!*.example.com"}},
+		},
+		"environment": {
+			opts:     []string{`environment="A=1"`, `environment="B=2 3"`},
+			expected: KeyOptions{Environment: []string{"A=1", "B=2 3"}},
+		},
+		"expiry time": {
+			opts:     []string{`expiry-time="202401021504Z"`},
+			expected: KeyOptions{ExpiryTime: time.Date(2024, 1, 2, 15, 4, 0, 0, time.UTC)},
+		},
+		"restrict": {
+			opts:     []string{"restrict", "pty", "no-port-forwarding", "no-user-rc"},
+			expected: KeyOptions{Restrict: true, PTY: true, NoPortForwarding: true, Other: []string{"no-user-rc"}},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			o, err := parseKeyOptions(tt.opts)
+			requireNoError(t, err)
+			if !reflect.DeepEqual(tt.expected, o) {
+				t.Fatalf("expected %+v, got %+v", tt.expected, o)
+			}
+		})
+	}
+
+	for _, opts := range [][]string{
+		{"command=echo"},
+		{`expiry-time="tomorrow"`},
+		{`environment="A"`},
+	} {
+		if _, err := parseKeyOptions(opts); err == nil {
+			t.Fatalf("expected an error for %q", opts)
+		}
+	}
+}
+
+func TestKeyOptionsAllowFrom(t *testing.T) {
+	addr := &net.TCPAddr{IP: net.ParseIP("192.0.2.10"), Port: 2222}
+	for from, allowed := range map[string]bool{
+		"":                           true,
+		"192.0.2.10":                 true,
+		"192.0.2.0/24":               true,
+		"192.0.2.*":                  true,
+		"198.51.100.0/24":            false,
+		"192.0.2.0/24,!192.0.2.10":   false,
+		"!192.0.2.10,192.0.2.0/24":   false,
+		"!198.51.100.1,192.0.2.1?":   true,
+		"host.example.com,192.0.3.*": false,
+	} {
+		var o KeyOptions
+		if from != "" {
+			o.From = strings.Split(from, ",")
+		}
+		if got := o.AllowFrom(addr); got != allowed {
+			t.Errorf("from=%q: expected %v, got %v", from, allowed, got)
+		}
+	}
+	requireEqual(t, false, KeyOptions{From: []string{"*"}}.AllowFrom(nil))
+}
+
+func TestKeyOptionsAllow(t *testing.T) {
+	requireEqual(t, true, KeyOptions{}.AllowPTY())
+	requireEqual(t, false, KeyOptions{NoPTY: true}.AllowPTY())
+	requireEqual(t, false, KeyOptions{Restrict: true}.AllowPTY())
+	requireEqual(t, true, KeyOptions{Restrict: true, PTY: true}.AllowPTY())
+	requireEqual(t, false, KeyOptions{Restrict: true}.AllowPortForwarding())
+	requireEqual(t, true, KeyOptions{Restrict: true, PortForwarding: true}.AllowPortForwarding())
+	requireEqual(t, false, KeyOptions{NoAgentForwarding: true}.AllowAgentForwarding())
+}
+
+func TestAuthorizedKeyFromContext(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "authorized_keys")
+	writeKeys(t, path, `command="uptime",no-pty `+string(getBytes(t, "testdata/foo.pub")))
+	s := &ssh.Server{
+		Handler: func(s ssh.Session) {
+			ak, ok := AuthorizedKeyFromContext(s.Context())
+			fmt.Fprintf(s, "%v %s %s %v", ok, ak.Comment, ak.Options.Command, ak.Options.AllowPTY())
+		},
+	}
+	requireNoError(t, WithAuthorizedKeys(path)(s))
+
+	signer, err := gossh.ParsePrivateKey(getBytes(t, "testdata/foo"))
+	requireNoError(t, err)
+	sess := testsession.New(t, s, &gossh.ClientConfig{
+		User: "foo",
+		Auth: []gossh.AuthMethod{gossh.PublicKeys(signer)},
+	})
+	out, err := sess.Output("")
+	requireNoError(t, err)
+	requireEqual(t, "true carlos@darkstar uptime false", string(out))
+}
+
+func TestAuthorizedKeyFromContextStale(t *testing.T) {
+	ctx := &testContext{}
+	ctx.SetValue(contextKeyAuthorizedKey, AuthorizedKey{Key: parseKey(t, k1), Options: KeyOptions{Command: "uptime"}})
+	_, ok := AuthorizedKeyFromContext(ctx)
+	requireEqual(t, false, ok)
+
+	// authenticated with another key, or another method.
+	ctx.SetValue(ssh.ContextKeyPublicKey, parseKey(t, k2))
+	_, ok = AuthorizedKeyFromContext(ctx)
+	requireEqual(t, false, ok)
+
+	ctx.SetValue(ssh.ContextKeyPublicKey, parseKey(t, k1))
+	ak, ok := AuthorizedKeyFromContext(ctx)
+	requireEqual(t, true, ok)
+	requireEqual(t, "uptime", ak.Options.Command)
+}
@@ -0,0 +1,262 @@
+package wish
+
+import (
+	"bytes"
+	"crypto/sha1" //nolint:gosec
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"charm.land/ssh"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// ErrInvalidKRL represents a malformed key revocation list.
+var ErrInvalidKRL = errors.New("invalid key revocation list")
+
+const krlMagic = "SSHKRL\n\x00"
+
+// sections of a KRL, see PROTOCOL.krl in the OpenSSH sources.
+const (
+	krlSectionCertificates      = 1
+	krlSectionExplicitKey       = 2
+	krlSectionFingerprintSHA1   = 3
+	krlSectionSignature         = 4
+	krlSectionFingerprintSHA256 = 5
+
+	krlCertSerialList   = 0x20
+	krlCertSerialRange  = 0x21
+	krlCertSerialBitmap = 0x22
+	krlCertKeyID        = 0x23
+)
+
+// RevocationList is a list of revoked keys and certificates. Analogous to the
+// RevokedKeys OpenSSH option.
+type RevocationList struct {
+	keys   map[string]bool
+	sha1   map[string]bool
+	sha256 map[string]bool
+	certs  []revokedCerts
+}
+
+// revokedCerts are the certificates revoked for a CA, or for any CA if ca is
+// empty.
+type revokedCerts struct {
+	ca      []byte
+	serials map[uint64]bool
+	ranges  [][2]uint64
+	bitmaps []serialBitmap
+	keyIDs  map[string]bool
+}
+
+type serialBitmap struct {
+	offset uint64
+	bits   *big.Int
+}
+
+// ParseRevocationList parses an OpenSSH key revocation list, as generated by
+// ssh-keygen -k, or a list of public keys in the authorized_keys format.
+// The signatures of KRLs are not verified.
+func ParseRevocationList(data []byte) (*RevocationList, error) {
+	l := &RevocationList{
+		keys:   map[string]bool{},
+		sha1:   map[string]bool{},
+		sha256: map[string]bool{},
+	}
+	if !bytes.HasPrefix(data, []byte(krlMagic)) {
+		for _, ak := range parseAuthorizedKeys("revoked keys", data) {
+			l.keys[string(ak.Key.Marshal())] = true
+		}
+		return l, nil
+	}
+	if err := l.parseKRL(data[len(krlMagic):]); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidKRL, err)
+	}
+	return l, nil
+}
+
+func (l *RevocationList) parseKRL(data []byte) error {
+	r := &wireReader{data: data}
+	// format version, KRL version, generation date, flags, reserved, comment.
+	version := r.uint32()
+	r.uint64()
+	r.uint64()
+	r.uint64()
+	r.string()
+	r.string()
+	if r.err != nil {
+		return r.err
+	}
+	if version != 1 {
+		return fmt.Errorf("unsupported format version %d", version)
+	}
+	for len(r.data) > 0 {
+		typ := r.byte()
+		section := &wireReader{data: r.string()}
+		if r.err != nil {
+			return r.err
+		}
+		switch typ {
+		case krlSectionCertificates:
+			if err := l.parseCertificates(section); err != nil {
+				return err
+			}
+		case krlSectionExplicitKey:
+			for len(section.data) > 0 {
+				l.keys[string(section.string())] = true
+			}
+		case krlSectionFingerprintSHA1:
+			for len(section.data) > 0 {
+				l.sha1[string(section.string())] = true
+			}
+		case krlSectionFingerprintSHA256:
+			for len(section.data) > 0 {
+				l.sha256[string(section.string())] = true
+			}
+		case krlSectionSignature:
+			// the signatures come last, and are not verified.
+			return nil
+		default:
+			return fmt.Errorf("unknown section %d", typ)
+		}
+		if section.err != nil {
+			return section.err
+		}
+	}
+	return nil
+}
+
+func (l *RevocationList) parseCertificates(r *wireReader) error {
+	rc := revokedCerts{
+		ca:      r.string(),
+		serials: map[uint64]bool{},
+		keyIDs:  map[string]bool{},
+	}
+	r.string() // reserved
+	for len(r.data) > 0 && r.err == nil {
+		typ := r.byte()
+		section := &wireReader{data: r.string()}
+		switch typ {
+		case krlCertSerialList:
+			for len(section.data) > 0 && section.err == nil {
+				rc.serials[section.uint64()] = true
+			}
+		case krlCertSerialRange:
+			rc.ranges = append(rc.ranges, [2]uint64{section.uint64(), section.uint64()})
+		case krlCertSerialBitmap:
+			offset := section.uint64()
+			bits := new(big.Int).SetBytes(section.string())
+			rc.bitmaps = append(rc.bitmaps, serialBitmap{offset: offset, bits: bits})
+		case krlCertKeyID:
+			for len(section.data) > 0 && section.err == nil {
+				rc.keyIDs[string(section.string())] = true
+			}
+		default:
+			return fmt.Errorf("unknown certificate section %d", typ)
+		}
+		if section.err != nil {
+			return section.err
+		}
+	}
+	l.certs = append(l.certs, rc)
+	return r.err
+}
+
+// IsRevoked reports whether the key is revoked. Certificates are revoked if
+// they are, or if their key or the key of their CA is.
+func (l *RevocationList) IsRevoked(key ssh.PublicKey) bool {
+	if cert, ok := key.(*gossh.Certificate); ok {
+		return l.isCertRevoked(cert) || l.isKeyRevoked(cert.Key) || l.isKeyRevoked(cert.SignatureKey)
+	}
+	return l.isKeyRevoked(key)
+}
+
+func (l *RevocationList) isKeyRevoked(key ssh.PublicKey) bool {
+	blob := key.Marshal()
+	sum1 := sha1.Sum(blob) //nolint:gosec
+	sum256 := sha256.Sum256(blob)
+	return l.keys[string(blob)] || l.sha1[string(sum1[:])] || l.sha256[string(sum256[:])]
+}
+
+func (l *RevocationList) isCertRevoked(cert *gossh.Certificate) bool {
+	ca := cert.SignatureKey.Marshal()
+	for _, rc := range l.certs {
+		if len(rc.ca) > 0 && !bytes.Equal(rc.ca, ca) {
+			continue
+		}
+		if rc.keyIDs[cert.KeyId] {
+			return true
+		}
+		if cert.Serial == 0 {
+			// as with OpenSSH, serials are ignored if unset.
+			continue
+		}
+		if rc.serials[cert.Serial] {
+			return true
+		}
+		for _, r := range rc.ranges {
+			if cert.Serial >= r[0] && cert.Serial <= r[1] {
+				return true
+			}
+		}
+		for _, b := range rc.bitmaps {
+			if cert.Serial >= b.offset && cert.Serial-b.offset < uint64(b.bits.BitLen()) &&
+				b.bits.Bit(int(cert.Serial-b.offset)) == 1 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wireReader reads the SSH wire encoding, keeping the first error.
+type wireReader struct {
+	data []byte
+	err  error
+}
+
+func (r *wireReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if len(r.data) < n {
+		r.err = errors.New("unexpected end of data")
+		r.data = nil
+		return nil
+	}
+	p := r.data[:n]
+	r.data = r.data[n:]
+	return p
+}
+
+func (r *wireReader) byte() byte {
+	if p := r.next(1); p != nil {
+		return p[0]
+	}
+	return 0
+}
+
+func (r *wireReader) uint32() uint32 {
+	if p := r.next(4); p != nil {
+		return binary.BigEndian.Uint32(p)
+	}
+	return 0
+}
+
+func (r *wireReader) uint64() uint64 {
+	if p := r.next(8); p != nil {
+		return binary.BigEndian.Uint64(p)
+	}
+	return 0
+}
+
+func (r *wireReader) string() []byte {
+	n := r.uint32()
+	if uint64(n) > uint64(len(r.data)) {
+		r.next(len(r.data) + 1)
+		return nil
+	}
+	return r.next(int(n))
+}
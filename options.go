@@ -1,13 +1,10 @@
 package wish
 
 import (
-	"bufio"
 	"bytes"
-	"errors"
 	"fmt"
-	"io"
 	"os"
-	"strings"
+	"slices"
 	"time"
 
 	"charm.land/log/v2"
@@ -84,26 +81,53 @@ func WithHostKeyPEM(pem []byte) ssh.Option {
 }
 
 // WithAuthorizedKeys allows the use of an SSH authorized_keys file to allowlist users.
+// The file is reloaded when it changes, and the matching key is available to
+// the middlewares with AuthorizedKeyFromContext. See Keyring.
 func WithAuthorizedKeys(path string) ssh.Option {
 	return func(s *ssh.Server) error {
-		if _, err := os.Stat(path); err != nil {
-			return fmt.Errorf("stat %s: %w", path, err)
+		k, err := NewKeyring(path)
+		if err != nil {
+			return err
 		}
-		return WithPublicKeyAuth(func(_ ssh.Context, key ssh.PublicKey) bool {
-			return isAuthorized(path, func(k ssh.PublicKey) bool {
-				return ssh.KeysEqual(key, k)
-			})
-		})(s)
+		return WithKeyring(k)(s)
 	}
 }
 
+// WithKeyring allows the keys of the given Keyring, like WithAuthorizedKeys.
+// The from and expiry-time options of the keys are enforced; the keyoptions
+// package enforces the others.
+func WithKeyring(k *Keyring) ssh.Option {
+	return WithPublicKeyAuth(func(ctx ssh.Context, key ssh.PublicKey) bool {
+		ak, ok := k.lookup(key, ctx.RemoteAddr())
+		if ok {
+			ctx.SetValue(contextKeyAuthorizedKey, ak)
+		}
+		return ok
+	})
+}
+
 // WithTrustedUserCAKeys authorize certificates that are signed with the given
 // Certificate Authority public key, and are valid.
 // Analogous to the TrustedUserCAKeys OpenSSH option.
-func WithTrustedUserCAKeys(path string) ssh.Option {
+//
+// By default, the certificates must have the user name as principal, which
+// WithPrincipals changes. Revoked certificates are denied with
+// WithRevokedKeys or WithRevokedSerials. The source-address critical option
+// is enforced, and certificates with other critical options are denied,
+// unless allowed with WithCriticalOptions, e.g. force-command with
+// keyoptions.WithForceCommand. The certificate is available to the
+// middlewares with CertificateFromContext.
+func WithTrustedUserCAKeys(path string, opts ...CertOption) ssh.Option {
 	return func(s *ssh.Server) error {
-		if _, err := os.Stat(path); err != nil {
-			return fmt.Errorf("stat %s: %w", path, err)
+		k, err := NewKeyring(path)
+		if err != nil {
+			return err
+		}
+		var o certOptions
+		for _, opt := range opts {
+			if err := opt(&o); err != nil {
+				return err
+			}
 		}
 		return WithPublicKeyAuth(func(ctx ssh.Context, key ssh.PublicKey) bool {
 			cert, ok := key.(*gossh.Certificate)
@@ -112,62 +136,22 @@ func WithTrustedUserCAKeys(path string) ssh.Option {
 				return false
 			}
 
-			return isAuthorized(path, func(k ssh.PublicKey) bool {
-				checker := &gossh.CertChecker{
-					IsUserAuthority: func(auth gossh.PublicKey) bool {
-						// its a cert signed by one of the CAs
-						return bytes.Equal(auth.Marshal(), k.Marshal())
-					},
-				}
-
-				if !checker.IsUserAuthority(cert.SignatureKey) {
-					return false
-				}
-
-				if err := checker.CheckCert(ctx.User(), cert); err != nil {
-					return false
-				}
-
-				return true
-			})
-		})(s)
-	}
-}
-
-func isAuthorized(path string, checker func(k ssh.PublicKey) bool) bool {
-	f, err := os.Open(path)
-	if err != nil {
-		log.Warn("failed to parse", "path", path, "error", err)
-		return false
-	}
-	defer f.Close() //nolint:errcheck
+			// its a cert signed by one of the CAs
+			if !slices.ContainsFunc(k.Keys(), func(ak AuthorizedKey) bool {
+				return bytes.Equal(cert.SignatureKey.Marshal(), ak.Key.Marshal())
+			}) {
+				return false
+			}
 
-	rd := bufio.NewReader(f)
-	for {
-		line, _, err := rd.ReadLine()
-		if err != nil {
-			if errors.Is(err, io.EOF) {
-				break
+			if err := o.checkCert(ctx, cert); err != nil {
+				log.Debug("certificate denied", "user", ctx.User(), "key-id", cert.KeyId, "error", err)
+				return false
 			}
-			log.Warn("failed to parse", "path", path, "error", err)
-			return false
-		}
-		if strings.TrimSpace(string(line)) == "" {
-			continue
-		}
-		if bytes.HasPrefix(line, []byte{'#'}) {
-			continue
-		}
-		upk, _, _, _, err := ssh.ParseAuthorizedKey(line)
-		if err != nil {
-			log.Warn("failed to parse", "path", path, "error", err)
-			return false
-		}
-		if checker(upk) {
+
+			ctx.SetValue(contextKeyCertificate, cert)
 			return true
-		}
+		})(s)
 	}
-	return false
 }
 
 // WithPublicKeyAuth returns an ssh.Option that sets the public key auth handler.
@@ -76,20 +76,6 @@ func TestWithMaxTimeout(t *testing.T) {
 	requireEqual(t, time.Second, s.MaxTimeout)
 }
 
-func TestIsAuthorized(t *testing.T) {
-	t.Run("valid", func(t *testing.T) {
-		requireEqual(t, true, isAuthorized("testdata/authorized_keys", func(k ssh.PublicKey) bool { return true }))
-	})
-
-	t.Run("invalid", func(t *testing.T) {
-		requireEqual(t, false, isAuthorized("testdata/invalid_authorized_keys", func(k ssh.PublicKey) bool { return true }))
-	})
-
-	t.Run("file not found", func(t *testing.T) {
-		requireEqual(t, false, isAuthorized("testdata/nope_authorized_keys", func(k ssh.PublicKey) bool { return true }))
-	})
-}
-
 func TestWithAuthorizedKeys(t *testing.T) {
 	t.Run("valid", func(t *testing.T) {
 		s := ssh.Server{}
@@ -103,7 +89,7 @@ func TestWithAuthorizedKeys(t *testing.T) {
 			t.Run(parts[len(parts)-1], func(t *testing.T) {
 				key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key))
 				requireNoError(t, err)
-				requireEqual(t, authorize, s.PublicKeyHandler(nil, key))
+				requireEqual(t, authorize, s.PublicKeyHandler(&testContext{}, key))
 			})
 		}
 	})
@@ -0,0 +1,351 @@
+// Package router provides a middleware that dispatches session commands to
+// named handlers.
+package router
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"charm.land/ssh"
+	"charm.land/wish/v2"
+)
+
+const (
+	// ExitUsage is the exit status used when a known command is called with
+	// invalid arguments.
+	ExitUsage = 2
+
+	// ExitUnknownCommand is the exit status used when a command is not
+	// registered, matching the one used by most shells.
+	ExitUnknownCommand = 127
+)
+
+// Handler handles a routed command. Args holds the arguments matched by the
+// route's pattern.
+type Handler func(s ssh.Session, args Args)
+
+// Args holds the arguments matched by a route pattern.
+type Args struct {
+	values map[string]string
+	rest   []string
+}
+
+// Get returns the value of the named argument, or an empty string if it was
+// not provided.
+func (a Args) Get(name string) string {
+	return a.values[name]
+}
+
+// Lookup returns the value of the named argument and whether it was provided.
+func (a Args) Lookup(name string) (string, bool) {
+	v, ok := a.values[name]
+	return v, ok
+}
+
+// Rest returns the arguments matched by a variadic argument such as
+// `<files...>`.
+func (a Args) Rest() []string {
+	return a.rest
+}
+
+// Router dispatches sessions to handlers registered by command name.
+//
+// Patterns are made of literal words, which form the command and its
+// subcommands, followed by arguments:
+//
+//	repo create <name>       a required argument
+//	repo list [owner]        an optional argument
+//	cat <files...>           one or more arguments
+//	echo [words...]          zero or more arguments
+//
+// A `help` command, as well as `-h` and `--help` flags on every command, are
+// provided automatically unless registered explicitly. The `help` command is
+// only provided by Handler, as a middleware leaves it to the next handler.
+type Router struct {
+	root     *node
+	fallback ssh.Handler
+}
+
+// New returns an empty Router.
+func New() *Router {
+	return &Router{root: &node{}}
+}
+
+type node struct {
+	name     string
+	children []*node
+	route    *route
+}
+
+type route struct {
+	pattern     string
+	description string
+	params      []param
+	handler     Handler
+}
+
+type param struct {
+	name     string
+	optional bool
+	variadic bool
+}
+
+func (n *node) child(name string) *node {
+	for _, c := range n.children {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// Handle registers the handler for the given pattern. It panics if the pattern
+// is invalid or already registered.
+func (r *Router) Handle(pattern, description string, h Handler) {
+	if h == nil {
+		panic("router: nil handler")
+	}
+	words := strings.Fields(pattern)
+	n := r.root
+	i := 0
+	for ; i < len(words) && !isParam(words[i]); i++ {
+		c := n.child(words[i])
+		if c == nil {
+			c = &node{name: words[i]}
+			n.children = append(n.children, c)
+		}
+		n = c
+	}
+	if n == r.root {
+		panic(fmt.Sprintf("router: pattern %q has no command", pattern))
+	}
+	params, err := parseParams(words[i:])
+	if err != nil {
+		panic(fmt.Sprintf("router: invalid pattern %q: %v", pattern, err))
+	}
+	if n.route != nil {
+		panic(fmt.Sprintf("router: multiple registrations for %q", pattern))
+	}
+	n.route = &route{
+		pattern:     strings.Join(words, " "),
+		description: description,
+		params:      params,
+		handler:     h,
+	}
+}
+
+// HandleFunc registers a handler that ignores the matched arguments, which is
+// convenient for mounting existing ssh.Handlers.
+func (r *Router) HandleFunc(pattern, description string, h ssh.Handler) {
+	r.Handle(pattern, description, func(s ssh.Session, _ Args) { h(s) })
+}
+
+// Fallback sets the handler for sessions without a command, such as
+// interactive shells.
+func (r *Router) Fallback(h ssh.Handler) {
+	r.fallback = h
+}
+
+func isParam(word string) bool {
+	return strings.HasPrefix(word, "<") || strings.HasPrefix(word, "[")
+}
+
+func parseParams(words []string) ([]param, error) {
+	var params []param
+	for i, w := range words {
+		var p param
+		switch {
+		case strings.HasPrefix(w, "<") && strings.HasSuffix(w, ">"):
+			p.name = w[1 : len(w)-1]
+		case strings.HasPrefix(w, "[") && strings.HasSuffix(w, "]"):
+			p.name = w[1 : len(w)-1]
+			p.optional = true
+		default:
+			return nil, fmt.Errorf("unexpected word %q after arguments", w)
+		}
+		if name, ok := strings.CutSuffix(p.name, "..."); ok {
+			if i != len(words)-1 {
+				return nil, fmt.Errorf("variadic argument %q must be last", w)
+			}
+			p.name = name
+			p.variadic = true
+		}
+		if p.name == "" {
+			return nil, fmt.Errorf("empty argument name")
+		}
+		if !p.optional && len(params) > 0 && params[len(params)-1].optional {
+			return nil, fmt.Errorf("required argument %q after optional ones", w)
+		}
+		params = append(params, p)
+	}
+	return params, nil
+}
+
+// match binds the given arguments to the route's params.
+func (rt *route) match(args []string) (Args, bool) {
+	a := Args{values: map[string]string{}}
+	for i, p := range rt.params {
+		if p.variadic {
+			if len(args) < i+1 && !p.optional {
+				return a, false
+			}
+			if i < len(args) {
+				a.rest = args[i:]
+			}
+			return a, true
+		}
+		if i >= len(args) {
+			if p.optional {
+				return a, true
+			}
+			return a, false
+		}
+		a.values[p.name] = args[i]
+	}
+	return a, len(args) <= len(rt.params)
+}
+
+// find walks the tree consuming literal words from cmd. It returns the
+// deepest node matched and the remaining arguments.
+func (r *Router) find(cmd []string) (*node, []string) {
+	n := r.root
+	for len(cmd) > 0 {
+		c := n.child(cmd[0])
+		if c == nil {
+			break
+		}
+		n, cmd = c, cmd[1:]
+	}
+	return n, cmd
+}
+
+// Middleware returns a wish.Middleware that dispatches registered commands
+// and passes everything else to the next handler, including `help` unless
+// registered, and the unknown subcommands of the registered ones, e.g.
+// `repo foo` when only `repo create` exists. Because unknown commands are
+// never consumed, it can be mounted before or after other middlewares that
+// inspect the command, such as git.Middleware and scp.Middleware.
+//
+// Sessions without a command are handled by the fallback handler if one was
+// set, and passed to the next handler otherwise.
+func (r *Router) Middleware() wish.Middleware {
+	return func(sh ssh.Handler) ssh.Handler {
+		return func(s ssh.Session) {
+			if !r.serve(s, false) {
+				sh(s)
+			}
+		}
+	}
+}
+
+// Handler returns an ssh.Handler that dispatches every session. Unknown
+// commands are reported on STDERR and exit with ExitUnknownCommand, and
+// sessions without a command print the help if no fallback was set.
+func (r *Router) Handler() ssh.Handler {
+	return func(s ssh.Session) {
+		if r.serve(s, true) {
+			return
+		}
+		cmd := s.Command()
+		if len(cmd) == 0 {
+			r.writeHelp(s, r.root)
+			_ = s.Exit(0)
+			return
+		}
+		wish.Errorf(s, "unknown command: %q\nRun 'help' for usage.\n", strings.Join(cmd, " "))
+		_ = s.Exit(ExitUnknownCommand)
+	}
+}
+
+// serve handles the session if it is meant for the router, and reports
+// whether it did. Unless all the sessions are, the built-in help and the
+// unknown subcommands are not.
+func (r *Router) serve(s ssh.Session, all bool) bool {
+	cmd := s.Command()
+	if len(cmd) == 0 {
+		if r.fallback == nil {
+			return false
+		}
+		r.fallback(s)
+		return true
+	}
+
+	n, args := r.find(cmd)
+	if n == r.root {
+		if cmd[0] != "help" || !all {
+			return false
+		}
+		// built-in help, as `help` was not registered.
+		target, rest := r.find(cmd[1:])
+		if len(rest) > 0 {
+			wish.Errorf(s, "unknown command: %q\n", strings.Join(cmd[1:], " "))
+			_ = s.Exit(ExitUnknownCommand)
+			return true
+		}
+		r.writeHelp(s, target)
+		_ = s.Exit(0)
+		return true
+	}
+
+	if len(args) > 0 && (args[0] == "-h" || args[0] == "--help") {
+		r.writeHelp(s, n)
+		_ = s.Exit(0)
+		return true
+	}
+
+	if n.route == nil {
+		// a command group, e.g. `repo` when only `repo create` exists.
+		if len(args) > 0 && !all {
+			return false
+		}
+		if len(args) > 0 {
+			wish.Errorf(s, "unknown command: %q\n", strings.Join(cmd, " "))
+		}
+		r.writeHelp(s.Stderr(), n)
+		_ = s.Exit(ExitUsage)
+		return true
+	}
+
+	a, ok := n.route.match(args)
+	if !ok {
+		wish.Errorf(s, "usage: %s\n", n.route.pattern)
+		_ = s.Exit(ExitUsage)
+		return true
+	}
+	n.route.handler(s, a)
+	return true
+}
+
+// writeHelp writes the usage of every route under the given node.
+func (r *Router) writeHelp(w io.Writer, n *node) {
+	var routes []*route
+	var walk func(n *node)
+	walk = func(n *node) {
+		if n.route != nil {
+			routes = append(routes, n.route)
+		}
+		for _, c := range n.children {
+			walk(c)
+		}
+	}
+	walk(n)
+	if n == r.root && r.root.child("help") == nil {
+		routes = append(routes, &route{
+			pattern:     "help [command...]",
+			description: "Show help for a command",
+		})
+	}
+	sort.SliceStable(routes, func(i, j int) bool {
+		return routes[i].pattern < routes[j].pattern
+	})
+
+	_, _ = fmt.Fprintln(w, "Commands:")
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, rt := range routes {
+		_, _ = fmt.Fprintf(tw, "  %s\t%s\n", rt.pattern, rt.description)
+	}
+	_ = tw.Flush()
+}
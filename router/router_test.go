@@ -0,0 +1,214 @@
+package router_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"charm.land/ssh"
+	"charm.land/wish/v2"
+	"charm.land/wish/v2/router"
+	"charm.land/wish/v2/testsession"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func newRouter() *router.Router {
+	r := router.New()
+	r.Handle("echo [words...]", "Print the arguments", func(s ssh.Session, args router.Args) {
+		wish.Println(s, strings.Join(args.Rest(), " "))
+	})
+	r.Handle("repo create <name> [description]", "Create a repository", func(s ssh.Session, args router.Args) {
+		desc, ok := args.Lookup("description")
+		wish.Printf(s, "created %s %q %v\n", args.Get("name"), desc, ok)
+	})
+	r.Handle("repo list", "List repositories", func(s ssh.Session, _ router.Args) {
+		wish.Println(s, "repo1 repo2")
+	})
+	r.Handle("cat <files...>", "Print files", func(s ssh.Session, args router.Args) {
+		wish.Println(s, len(args.Rest()))
+	})
+	return r
+}
+
+func TestRouterHandler(t *testing.T) {
+	for name, tc := range map[string]struct {
+		cmd  string
+		out  string
+		code int
+	}{
+		"variadic":          {"echo hello world", "hello world\n", 0},
+		"variadic empty":    {"echo", "\n", 0},
+		"subcommand":        {"repo list", "repo1 repo2\n", 0},
+		"required":          {"repo create foo", "created foo \"\" false\n", 0},
+		"optional":          {"repo create foo 'a repo'", "created foo \"a repo\" true\n", 0},
+		"missing required":  {"repo create", "usage: repo create <name> [description]\n", router.ExitUsage},
+		"too many":          {"repo create a b c", "usage: repo create <name> [description]\n", router.ExitUsage},
+		"required variadic": {"cat", "usage: cat <files...>\n", router.ExitUsage},
+		"unknown":           {"nope", "unknown command: \"nope\"\nRun 'help' for usage.\n", router.ExitUnknownCommand},
+		"unknown sub":       {"repo nope", "unknown command: \"repo nope\"\n", router.ExitUsage},
+	} {
+		t.Run(name, func(t *testing.T) {
+			out, err := setup(t, newRouter().Handler()).CombinedOutput(tc.cmd)
+			requireExit(t, err, tc.code)
+			if !strings.HasPrefix(string(out), tc.out) {
+				t.Errorf("expected output to start with %q, got %q", tc.out, string(out))
+			}
+		})
+	}
+}
+
+func TestRouterHelp(t *testing.T) {
+	t.Run("all", func(t *testing.T) {
+		out, err := setup(t, newRouter().Handler()).Output("help")
+		requireExit(t, err, 0)
+		expected := "Commands:\n" +
+			"  cat <files...>                    Print files\n" +
+			"  echo [words...]                   Print the arguments\n" +
+			"  help [command...]                 Show help for a command\n" +
+			"  repo create <name> [description]  Create a repository\n" +
+			"  repo list                         List repositories\n"
+		if string(out) != expected {
+			t.Errorf("expected %q, got %q", expected, string(out))
+		}
+	})
+
+	t.Run("command", func(t *testing.T) {
+		out, err := setup(t, newRouter().Handler()).Output("help repo")
+		requireExit(t, err, 0)
+		expected := "Commands:\n" +
+			"  repo create <name> [description]  Create a repository\n" +
+			"  repo list                         List repositories\n"
+		if string(out) != expected {
+			t.Errorf("expected %q, got %q", expected, string(out))
+		}
+	})
+
+	t.Run("flag", func(t *testing.T) {
+		out, err := setup(t, newRouter().Handler()).Output("repo list --help")
+		requireExit(t, err, 0)
+		expected := "Commands:\n  repo list  List repositories\n"
+		if string(out) != expected {
+			t.Errorf("expected %q, got %q", expected, string(out))
+		}
+	})
+
+	t.Run("no command", func(t *testing.T) {
+		out, err := setup(t, newRouter().Handler()).Output("")
+		requireExit(t, err, 0)
+		if !strings.HasPrefix(string(out), "Commands:\n") {
+			t.Errorf("expected help, got %q", string(out))
+		}
+	})
+}
+
+func TestRouterMiddleware(t *testing.T) {
+	next := func(s ssh.Session) {
+		wish.Println(s, "next")
+	}
+	mw := func(r *router.Router) ssh.Handler {
+		return r.Middleware()(next)
+	}
+
+	t.Run("known", func(t *testing.T) {
+		out, err := setup(t, mw(newRouter())).Output("repo list")
+		requireExit(t, err, 0)
+		if string(out) != "repo1 repo2\n" {
+			t.Errorf("unexpected output %q", string(out))
+		}
+	})
+
+	t.Run("unknown falls through", func(t *testing.T) {
+		out, err := setup(t, mw(newRouter())).Output("git-upload-pack repo")
+		requireExit(t, err, 0)
+		if string(out) != "next\n" {
+			t.Errorf("unexpected output %q", string(out))
+		}
+	})
+
+	t.Run("help falls through", func(t *testing.T) {
+		out, err := setup(t, mw(newRouter())).Output("help")
+		requireExit(t, err, 0)
+		if string(out) != "next\n" {
+			t.Errorf("unexpected output %q", string(out))
+		}
+	})
+
+	t.Run("unknown subcommand falls through", func(t *testing.T) {
+		out, err := setup(t, mw(newRouter())).Output("repo foo")
+		requireExit(t, err, 0)
+		if string(out) != "next\n" {
+			t.Errorf("unexpected output %q", string(out))
+		}
+	})
+
+	t.Run("group help", func(t *testing.T) {
+		out, err := setup(t, mw(newRouter())).Output("repo --help")
+		requireExit(t, err, 0)
+		if !strings.Contains(string(out), "repo list") {
+			t.Errorf("unexpected output %q", string(out))
+		}
+	})
+
+	t.Run("no command falls through", func(t *testing.T) {
+		out, err := setup(t, mw(newRouter())).Output("")
+		requireExit(t, err, 0)
+		if string(out) != "next\n" {
+			t.Errorf("unexpected output %q", string(out))
+		}
+	})
+
+	t.Run("fallback", func(t *testing.T) {
+		r := newRouter()
+		r.Fallback(func(s ssh.Session) {
+			wish.Println(s, "fallback")
+		})
+		out, err := setup(t, mw(r)).Output("")
+		requireExit(t, err, 0)
+		if string(out) != "fallback\n" {
+			t.Errorf("unexpected output %q", string(out))
+		}
+	})
+}
+
+func TestRouterInvalidPatterns(t *testing.T) {
+	for name, pattern := range map[string]string{
+		"no command":         "<name>",
+		"variadic not last":  "cmd <a...> <b>",
+		"literal after args": "cmd <a> sub",
+		"required after opt": "cmd [a] <b>",
+		"duplicate":          "repo list",
+	} {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected a panic")
+				}
+			}()
+			newRouter().Handle(pattern, "", func(ssh.Session, router.Args) {})
+		})
+	}
+}
+
+func setup(tb testing.TB, h ssh.Handler) *gossh.Session {
+	tb.Helper()
+	return testsession.New(tb, &ssh.Server{
+		Handler: h,
+	}, nil)
+}
+
+func requireExit(tb testing.TB, err error, code int) {
+	tb.Helper()
+	if code == 0 {
+		if err != nil {
+			tb.Fatalf("expected no error, got %v", err)
+		}
+		return
+	}
+	var exitErr *gossh.ExitError
+	if !errors.As(err, &exitErr) {
+		tb.Fatalf("expected exit error, got %v", err)
+	}
+	if exitErr.ExitStatus() != code {
+		tb.Fatalf("expected exit status %d, got %d", code, exitErr.ExitStatus())
+	}
+}
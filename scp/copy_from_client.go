@@ -28,13 +28,15 @@ func (e parseError) Error() string {
 	return fmt.Sprintf("failed to parse: %q", e.subject)
 }
 
-func copyFromClient(s ssh.Session, info Info, handler CopyFromClientHandler) error {
+func copyFromClient(s ssh.Session, rep *reporter, info Info, handler CopyFromClientHandler, o options) error {
 	// accepts the request
-	_, _ = s.Write(NULL)
+	_ = WriteOK(s)
 
 	var (
 		path  = info.Path
 		r     = bufio.NewReader(s)
+		q     = &quota{limits: o.limits(s)}
+		depth int
 		mtime int64
 		atime int64
 	)
@@ -50,17 +52,22 @@ func copyFromClient(s ssh.Session, info Info, handler CopyFromClientHandler) err
 		line = strings.TrimSuffix(line, "\n")
 
 		if matches := reTimestamp.FindAllStringSubmatch(line, 2); matches != nil {
-			mtime, err = strconv.ParseInt(matches[0][1], 10, 64)
+			mt, err := strconv.ParseInt(matches[0][1], 10, 64)
 			if err != nil {
 				return parseError{line}
 			}
-			atime, err = strconv.ParseInt(matches[0][2], 10, 64)
+			at, err := strconv.ParseInt(matches[0][2], 10, 64)
 			if err != nil {
 				return parseError{line}
 			}
 
+			// times are only kept if the client asked to preserve them.
+			if info.Preserve {
+				mtime, atime = mt, at
+			}
+
 			// accepts the header
-			_, _ = s.Write(NULL)
+			_ = WriteOK(s)
 			continue
 		}
 
@@ -68,7 +75,7 @@ func copyFromClient(s ssh.Session, info Info, handler CopyFromClientHandler) err
 			if len(matches) != 1 || len(matches[0]) != 4 {
 				return parseError{line}
 			}
-			if err := handleNewFile(s, r, handler, path, line, matches[0], mtime, atime); err != nil {
+			if err := handleNewFile(s, rep, r, handler, q, o.observer, path, line, matches[0], mtime, atime); err != nil {
 				return err
 			}
 			mtime = 0
@@ -90,40 +97,56 @@ func copyFromClient(s ssh.Session, info Info, handler CopyFromClientHandler) err
 				return err
 			}
 
-			path = filepath.Join(path, name)
-			if err := handler.Mkdir(s, &DirEntry{
-				Name:     name,
-				Filepath: path,
-				Mode:     fs.FileMode(mode),
-				Mtime:    mtime,
-				Atime:    atime,
-			}); err != nil {
-				return fmt.Errorf("failed to create dir: %q: %w", name, err)
+			err = q.checkDir(name, depth+1)
+			if err == nil {
+				if err = handler.Mkdir(s, &DirEntry{
+					Name:     name,
+					Filepath: filepath.Join(path, name),
+					Mode:     fs.FileMode(mode),
+					Mtime:    mtime,
+					Atime:    atime,
+				}); err != nil {
+					err = fmt.Errorf("failed to create dir: %q: %w", name, err)
+				}
 			}
-
 			mtime = 0
 			atime = 0
+			if err != nil {
+				// the client skips the whole directory on warnings.
+				if err := rep.warn(err); err != nil {
+					return err
+				}
+				continue
+			}
+
+			depth++
+			path = filepath.Join(path, name)
 			// says 'hey im done'
-			_, _ = s.Write(NULL)
+			_ = WriteOK(s)
 			continue
 		}
 
 		if line == "E" {
+			if depth == 0 {
+				// would leave the target directory.
+				return fmt.Errorf("unexpected end of directory: %q", line)
+			}
+			depth--
 			path = filepath.Dir(path)
 
 			// says 'hey im done'
-			_, _ = s.Write(NULL)
+			_ = WriteOK(s)
 			continue
 		}
 
 		return fmt.Errorf("unhandled input: %q", line)
 	}
 
-	_, _ = s.Write(NULL)
+	_ = WriteOK(s)
 	return nil
 }
 
-func handleNewFile(s ssh.Session, r *bufio.Reader, handler CopyFromClientHandler, path, line string, match []string, mtime, atime int64) error {
+func handleNewFile(s ssh.Session, rep *reporter, r *bufio.Reader, handler CopyFromClientHandler, q *quota, obs Observer, path, line string, match []string, mtime, atime int64) error {
 	mode, err := strconv.ParseUint(match[1], 8, 32)
 	if err != nil {
 		return parseError{line}
@@ -138,29 +161,58 @@ func handleNewFile(s ssh.Session, r *bufio.Reader, handler CopyFromClientHandler
 		return err
 	}
 
+	t := &Transfer{
+		Op:   OpCopyFromClient,
+		Path: filepath.Join(path, name),
+		Size: size,
+	}
+	obs.Start(s, *t)
+
+	if err := q.checkFile(name, size); err != nil {
+		obs.Error(s, *t, err)
+		// the client skips the file on warnings.
+		return rep.report(err)
+	}
+
 	// accepts the header
-	_, _ = s.Write(NULL)
+	_ = WriteOK(s)
 
+	reader := newLimitReader(r, int(size))
 	written, err := handler.Write(s, &FileEntry{
 		Name:     name,
-		Filepath: filepath.Join(path, name),
+		Filepath: t.Path,
 		Mode:     fs.FileMode(mode),
 		Size:     size,
 		Mtime:    mtime,
 		Atime:    atime,
-		Reader:   newLimitReader(r, int(size)),
+		Reader:   &observedReader{r: reader, s: s, obs: obs, t: t},
 	})
 	if err != nil {
-		return fmt.Errorf("failed to write file: %q: %w", name, err)
+		err = fmt.Errorf("failed to write file: %q: %w", name, err)
+	} else if written != size {
+		err = fmt.Errorf("failed to write the file: %q: written %d out of %d bytes", name, written, size)
 	}
-	if written != size {
-		return fmt.Errorf("failed to write the file: %q: written %d out of %d bytes", name, written, size)
+	if err != nil {
+		// the client sends the whole file regardless, so we skip whatever
+		// wasn't read before reporting the error.
+		if _, derr := io.Copy(io.Discard, reader); derr != nil {
+			err = fmt.Errorf("failed to read file: %q: %w", name, derr)
+			obs.Error(s, *t, err)
+			return err
+		}
 	}
 
 	// read the trailing nil char
 	_, _ = r.ReadByte()
 
+	if err != nil {
+		obs.Error(s, *t, err)
+		return rep.warn(err)
+	}
+
+	obs.Finish(s, *t)
+
 	// says 'hey im done'
-	_, _ = s.Write(NULL)
+	_ = WriteOK(s)
 	return nil
 }
@@ -1,71 +1,244 @@
 package scp
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
+	"io"
 	"io/fs"
+	"path/filepath"
+	"strings"
 
 	"charm.land/ssh"
 )
 
-func copyToClient(s ssh.Session, info Info, handler CopyToClientHandler) error {
+func copyToClient(s ssh.Session, rep *reporter, info Info, handler CopyToClientHandler, o options) error {
+	snd := &sender{
+		s:             s,
+		r:             bufio.NewReader(s),
+		rep:           rep,
+		onClientError: o.onClientError,
+		observer:      o.observer,
+		preserve:      info.Preserve,
+	}
+
+	// waits for the client to be ready
+	if err := snd.ack(info.Path); err != nil {
+		return err
+	}
+
 	matches, err := handler.Glob(s, info.Path)
 	if err != nil {
 		return err //nolint:wrapcheck
 	}
 	if len(matches) == 0 {
-		return fmt.Errorf("no files matching %q", info.Path)
+		return rep.warn(fmt.Errorf("no files matching %q", info.Path))
 	}
 
-	rootEntry := &RootEntry{}
-	var closers []func() error
-	defer func() {
-		closeAll(closers)
-	}()
-
 	for _, match := range matches {
 		if !info.Recursive {
-			entry, closer, err := handler.NewFileEntry(s, match)
-			closers = append(closers, closer)
-			if err != nil {
-				return err //nolint:wrapcheck
+			if err := snd.sendFile(handler, match); err != nil {
+				return err
 			}
-			rootEntry.Append(entry)
 			continue
 		}
 
+		// open directories, from the outermost to the innermost.
+		var dirs []string
 		if err := handler.WalkDir(s, match, func(path string, d fs.DirEntry, err error) error {
 			if err != nil {
-				return err
+				// skips the unreadable file or directory.
+				return rep.warn(err)
 			}
 
-			if d.IsDir() {
-				entry, err := handler.NewDirEntry(s, path)
-				if err != nil {
-					return err //nolint:wrapcheck
-				}
-				rootEntry.Append(entry)
-			} else {
-				entry, closer, err := handler.NewFileEntry(s, path)
-				if err != nil {
-					return err //nolint:wrapcheck
+			// closes the directories we are no longer in.
+			parent := normalizePath(filepath.Dir(path))
+			for len(dirs) > 0 && dirs[len(dirs)-1] != parent {
+				if err := snd.end(dirs[len(dirs)-1]); err != nil {
+					return err
 				}
-				closers = append(closers, closer)
-				rootEntry.Append(entry)
+				dirs = dirs[:len(dirs)-1]
 			}
 
+			if !d.IsDir() {
+				return snd.sendFile(handler, path)
+			}
+
+			entry, err := handler.NewDirEntry(s, path)
+			if err != nil {
+				if err := rep.warn(err); err != nil {
+					return err
+				}
+				return fs.SkipDir
+			}
+			if err := snd.dir(entry); err != nil {
+				if err := snd.skip(err); err != nil {
+					return err
+				}
+				// the client refused the directory.
+				return fs.SkipDir
+			}
+			dirs = append(dirs, normalizePath(path))
 			return nil
 		}); err != nil {
 			return err //nolint:wrapcheck
 		}
+		for i := len(dirs) - 1; i >= 0; i-- {
+			if err := snd.end(dirs[i]); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// sender streams entries to the client, waiting for its acknowledgement
+// after each record.
+type sender struct {
+	s             ssh.Session
+	r             *bufio.Reader
+	rep           *reporter
+	onClientError func(ssh.Session, *ClientError)
+	observer      Observer
+	preserve      bool
+}
+
+// ack waits for the client acknowledgement of the record about the given
+// path, returning a *ClientError if the client reported one.
+func (snd *sender) ack(path string) error {
+	b, err := snd.r.ReadByte()
+	if err != nil {
+		return fmt.Errorf("failed to read acknowledgement: %w", err)
+	}
+	if b == NULL[0] {
+		return nil
+	}
+
+	msg, err := snd.r.ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to read client error: %w", err)
+	}
+	cerr := &ClientError{
+		Path:    path,
+		Message: strings.TrimSuffix(msg, "\n"),
+		Fatal:   b != '\x01',
+	}
+	if b != '\x01' && b != '\x02' {
+		// unknown responses are part of the message, as in OpenSSH.
+		cerr.Message = string(b) + cerr.Message
+	}
+	if !cerr.Fatal {
+		snd.rep.warnings++
+	}
+	snd.onClientError(snd.s, cerr)
+	return cerr
+}
+
+// skip returns nil if the given error means that the current entry was
+// skipped, by the client or after a warning, but the transfer can go on.
+func (snd *sender) skip(err error) error {
+	var cerr *ClientError
+	if (errors.As(err, &cerr) && !cerr.Fatal) || isWarning(err) {
+		return nil
+	}
+	return err
+}
+
+// record writes a protocol record about the given path and waits for the
+// client acknowledgement.
+func (snd *sender) record(path, format string, v ...any) error {
+	if _, err := fmt.Fprintf(snd.s, format, v...); err != nil {
+		return fmt.Errorf("failed to write: %w", err)
 	}
+	return snd.ack(path)
+}
+
+// times sends the given times, if the client asked to preserve them.
+func (snd *sender) times(path string, mtime, atime int64) error {
+	if snd.preserve && mtime > 0 && atime > 0 {
+		return snd.record(path, "T%d 0 %d 0\n", mtime, atime)
+	}
+	return nil
+}
 
-	return rootEntry.Write(s)
+func (snd *sender) dir(e *DirEntry) error {
+	if err := snd.times(e.Filepath, e.Mtime, e.Atime); err != nil {
+		return err
+	}
+	return snd.record(e.Filepath, "D%s 0 %s\n", octalPerms(e.Mode), e.Name)
+}
+
+func (snd *sender) end(path string) error {
+	return snd.skip(snd.record(path, "E\n"))
+}
+
+// sendFile opens the given file, sends it, and closes it.
+func (snd *sender) sendFile(handler CopyToClientHandler, path string) error {
+	entry, closer, err := handler.NewFileEntry(snd.s, path)
+	if closer != nil {
+		defer closer() //nolint:errcheck
+	}
+	if err != nil {
+		return snd.rep.warn(err)
+	}
+	return snd.skip(snd.file(entry))
 }
 
-func closeAll(closers []func() error) {
-	for _, closer := range closers {
-		if closer != nil {
-			_ = closer()
+func (snd *sender) file(e *FileEntry) error {
+	t := &Transfer{
+		Op:   OpCopyToClient,
+		Path: e.Filepath,
+		Size: e.Size,
+	}
+	snd.observer.Start(snd.s, *t)
+	if err := snd.sendData(e, t); err != nil {
+		snd.observer.Error(snd.s, *t, err)
+		return err
+	}
+	snd.observer.Finish(snd.s, *t)
+	return nil
+}
+
+func (snd *sender) sendData(e *FileEntry, t *Transfer) error {
+	if err := snd.times(e.Filepath, e.Mtime, e.Atime); err != nil {
+		return err
+	}
+	if err := snd.record(e.Filepath, "C%s %d %s\n", octalPerms(e.Mode), e.Size, e.Name); err != nil {
+		return err
+	}
+
+	// the client expects exactly e.Size bytes, so if the file is shorter or
+	// fails to read, we pad it and send a warning instead of the NULL.
+	r := &observedReader{r: e.Reader, s: snd.s, obs: snd.observer, t: t}
+	n, err := io.Copy(snd.s, io.LimitReader(r, e.Size))
+	if err == nil && n < e.Size {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		if _, perr := io.CopyN(snd.s, zeroReader{}, e.Size-n); perr != nil {
+			return fmt.Errorf("failed to write file: %q: %w", e.Filepath, perr)
+		}
+		err = fmt.Errorf("failed to read file: %q: %w", e.Filepath, err)
+		if rerr := snd.rep.warn(err); rerr != nil {
+			return rerr
 		}
+		if aerr := snd.ack(e.Filepath); aerr != nil {
+			return aerr
+		}
+		// the file was skipped, but the transfer goes on.
+		return &WarningError{err}
+	}
+
+	if _, err := snd.s.Write(NULL); err != nil {
+		return fmt.Errorf("failed to write file: %q: %w", e.Filepath, err)
 	}
+	return snd.ack(e.Filepath)
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(b []byte) (int, error) {
+	clear(b)
+	return len(b), nil
 }
@@ -0,0 +1,135 @@
+package scp
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"charm.land/ssh"
+	"github.com/matryer/is"
+)
+
+// countingHandler wraps a Handler, keeping track of open files.
+type countingHandler struct {
+	Handler
+	open, maxOpen int
+}
+
+func (h *countingHandler) NewFileEntry(s ssh.Session, path string) (*FileEntry, func() error, error) {
+	entry, closer, err := h.Handler.NewFileEntry(s, path)
+	if err != nil {
+		return entry, closer, err
+	}
+	h.open++
+	h.maxOpen = max(h.maxOpen, h.open)
+	return entry, func() error {
+		h.open--
+		return closer()
+	}, nil
+}
+
+func TestCopyToClientStreaming(t *testing.T) {
+	t.Run("one file at a time", func(t *testing.T) {
+		is := is.New(t)
+		dir := t.TempDir()
+		for i := range 10 {
+			sub := filepath.Join(dir, "a", fmt.Sprintf("d%d", i%3))
+			is.NoErr(os.MkdirAll(sub, 0o755))
+			is.NoErr(os.WriteFile(filepath.Join(sub, fmt.Sprintf("f%d.txt", i)), []byte("hi"), 0o644))
+		}
+		h := &countingHandler{Handler: NewFileSystemHandler(dir)}
+
+		out, err := setup(t, h, nil).Output("scp -r -p -f a")
+		is.NoErr(err)
+		is.Equal(1, h.maxOpen)
+		is.Equal(0, h.open)
+		is.Equal(10, strings.Count(string(out), "\nC0644 2 "))
+		is.Equal(4, strings.Count(string(out), "D0755 0 "))
+		is.Equal(4, strings.Count(string(out), "E\n"))
+	})
+
+	t.Run("waits for acks", func(t *testing.T) {
+		is := is.New(t)
+		dir := t.TempDir()
+		is.NoErr(os.WriteFile(filepath.Join(dir, "a.txt"), []byte("hello"), 0o644))
+
+		// only acknowledges the start of the transfer and the headers.
+		session := setup(t, NewFileSystemHandler(dir), nil)
+		session.Stdin = bytes.NewReader(bytes.Repeat(NULL, 3))
+		out, err := session.Output("scp -p -f a.txt")
+		requireExitStatus(t, err, 1)
+		is.True(strings.Contains(string(out), "C0644 5 a.txt\nhello\x00"))
+		is.True(strings.HasSuffix(string(out), "\x02failed to read acknowledgement: EOF\n"))
+	})
+
+	t.Run("client error aborts", func(t *testing.T) {
+		is := is.New(t)
+		dir := t.TempDir()
+		is.NoErr(os.WriteFile(filepath.Join(dir, "a.txt"), []byte("hello"), 0o644))
+
+		session := setup(t, NewFileSystemHandler(dir), nil)
+		session.Stdin = strings.NewReader("\x00\x00\x02no space left\n")
+		out, err := session.Output("scp -p -f a.txt")
+		requireExitStatus(t, err, 1)
+		is.True(!strings.Contains(string(out), "hello"))
+	})
+	t.Run("client warning skips file", func(t *testing.T) {
+		is := is.New(t)
+		dir := t.TempDir()
+		is.NoErr(os.WriteFile(filepath.Join(dir, "a.txt"), []byte("aaaaa"), 0o644))
+		is.NoErr(os.WriteFile(filepath.Join(dir, "b.txt"), []byte("bbbbb"), 0o644))
+
+		var errs []*ClientError
+		session := setupWithOptions(t, NewFileSystemHandler(dir), nil, WithClientErrorHandler(func(_ ssh.Session, err *ClientError) {
+			errs = append(errs, err)
+		}))
+		// refuses a.txt after its times, then accepts b.txt.
+		session.Stdin = strings.NewReader("\x00\x00\x01a.txt: permission denied\n\x00\x00\x00")
+		out, err := session.Output("scp -p -f *.txt")
+		requireExitStatus(t, err, 1)
+		is.True(!strings.Contains(string(out), "aaaaa"))
+		is.True(strings.HasSuffix(string(out), "C0644 5 b.txt\nbbbbb\x00"))
+		is.Equal(1, len(errs))
+		is.Equal("a.txt", filepath.Base(errs[0].Path))
+		is.Equal("a.txt: permission denied", errs[0].Message)
+		is.True(!errs[0].Fatal)
+	})
+
+	t.Run("client warning skips dir", func(t *testing.T) {
+		is := is.New(t)
+		dir := t.TempDir()
+		is.NoErr(os.MkdirAll(filepath.Join(dir, "a/b"), 0o755))
+		is.NoErr(os.WriteFile(filepath.Join(dir, "a/b/c.txt"), []byte("ccccc"), 0o644))
+		is.NoErr(os.WriteFile(filepath.Join(dir, "a/d.txt"), []byte("ddddd"), 0o644))
+
+		session := setup(t, NewFileSystemHandler(dir), nil)
+		// accepts a, refuses b, then accepts everything else.
+		session.Stdin = strings.NewReader("\x00\x00\x00\x00\x01b: is not a directory\n" + strings.Repeat("\x00", 10))
+		out, err := session.Output("scp -r -p -f a")
+		requireExitStatus(t, err, 1)
+		is.True(!strings.Contains(string(out), "ccccc"))
+		is.True(strings.HasSuffix(string(out), "C0644 5 d.txt\nddddd\x00E\n"))
+	})
+
+	t.Run("client fatal error", func(t *testing.T) {
+		is := is.New(t)
+		dir := t.TempDir()
+		is.NoErr(os.WriteFile(filepath.Join(dir, "a.txt"), []byte("aaaaa"), 0o644))
+		is.NoErr(os.WriteFile(filepath.Join(dir, "b.txt"), []byte("bbbbb"), 0o644))
+
+		var errs []*ClientError
+		session := setupWithOptions(t, NewFileSystemHandler(dir), nil, WithClientErrorHandler(func(_ ssh.Session, err *ClientError) {
+			errs = append(errs, err)
+		}))
+		session.Stdin = strings.NewReader("\x00\x00\x00\x02disk full\n")
+		out, err := session.Output("scp -p -f *.txt")
+		requireExitStatus(t, err, 1)
+		is.True(strings.HasSuffix(string(out), "C0644 5 a.txt\naaaaa\x00"))
+		is.Equal(1, len(errs))
+		is.Equal("disk full", errs[0].Message)
+		is.True(errs[0].Fatal)
+	})
+}
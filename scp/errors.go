@@ -0,0 +1,115 @@
+package scp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// WarningError is an error reported to the client as a scp warning. The
+// current file or directory is skipped, but the rest of the transfer goes
+// on. The session still exits with status 1 once the transfer is done.
+//
+// Handlers may return it to skip a single entry.
+type WarningError struct {
+	Err error
+}
+
+func (e *WarningError) Error() string { return e.Err.Error() }
+func (e *WarningError) Unwrap() error { return e.Err }
+
+// FatalError is an error reported to the client as a scp fatal error, which
+// aborts the whole transfer.
+//
+// Errors that are neither a WarningError nor a FatalError are considered
+// fatal, except for errors writing files copied from the client, which only
+// skip that file.
+type FatalError struct {
+	Err error
+}
+
+func (e *FatalError) Error() string { return e.Err.Error() }
+func (e *FatalError) Unwrap() error { return e.Err }
+
+// ClientError is an error the client reported while receiving files from the
+// server, e.g. because its disk is full or it can't write to the target path.
+//
+// When the error is not fatal, the client skipped Path and the transfer goes
+// on. Otherwise, the client gave up and the transfer is aborted.
+type ClientError struct {
+	Path    string
+	Message string
+	Fatal   bool
+}
+
+func (e *ClientError) Error() string {
+	return fmt.Sprintf("client error: %s: %s", e.Path, e.Message)
+}
+
+// Warningf returns a new WarningError with the given message.
+func Warningf(format string, v ...any) error {
+	return &WarningError{fmt.Errorf(format, v...)}
+}
+
+// Fatalf returns a new FatalError with the given message.
+func Fatalf(format string, v ...any) error {
+	return &FatalError{fmt.Errorf(format, v...)}
+}
+
+// WriteOK writes a successful acknowledgement to the given writer.
+func WriteOK(w io.Writer) error {
+	_, err := w.Write(NULL)
+	return err //nolint:wrapcheck
+}
+
+// WriteError writes the given error to the given writer using the scp
+// protocol: `\x01message\n` for a WarningError, `\x02message\n` otherwise.
+func WriteError(w io.Writer, err error) error {
+	code := byte('\x02')
+	if isWarning(err) {
+		code = '\x01'
+	}
+	msg := strings.ReplaceAll(err.Error(), "\n", " ")
+	_, werr := fmt.Fprintf(w, "%c%s\n", code, msg)
+	return werr //nolint:wrapcheck
+}
+
+func isWarning(err error) bool {
+	var w *WarningError
+	var f *FatalError
+	return errors.As(err, &w) && !errors.As(err, &f)
+}
+
+// reporter writes errors to the client, keeping track of what was reported.
+type reporter struct {
+	w        io.Writer
+	warnings int
+	fatal    bool
+}
+
+// report writes the given error to the client. Warnings are not returned, as
+// the transfer can go on.
+func (r *reporter) report(err error) error {
+	if err == nil || r.fatal {
+		return err
+	}
+	if werr := WriteError(r.w, err); werr != nil {
+		return fmt.Errorf("failed to write error: %w", werr)
+	}
+	if isWarning(err) {
+		r.warnings++
+		return nil
+	}
+	r.fatal = true
+	return err
+}
+
+// warn reports the given error as a warning, unless it is explicitly fatal.
+func (r *reporter) warn(err error) error {
+	var f *FatalError
+	if err == nil || errors.As(err, &f) || isWarning(err) {
+		return r.report(err)
+	}
+	return r.report(&WarningError{err})
+}
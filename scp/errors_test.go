@@ -0,0 +1,157 @@
+package scp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"charm.land/ssh"
+	"github.com/matryer/is"
+)
+
+func TestWriteError(t *testing.T) {
+	for name, tc := range map[string]struct {
+		err      error
+		expected string
+	}{
+		"warning":          {Warningf("skipped %s", "a.txt"), "\x01skipped a.txt\n"},
+		"fatal":            {Fatalf("aborted"), "\x02aborted\n"},
+		"plain":            {errors.New("plain"), "\x02plain\n"},
+		"wrapped warning":  {fmt.Errorf("ctx: %w", Warningf("skipped")), "\x01ctx: skipped\n"},
+		"fatal in warning": {&WarningError{Fatalf("aborted")}, "\x02aborted\n"},
+		"multiline":        {errors.New("a\nb"), "\x02a b\n"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			is := is.New(t)
+			var b bytes.Buffer
+			is.NoErr(WriteError(&b, tc.err))
+			is.Equal(tc.expected, b.String())
+		})
+	}
+}
+
+// failingHandler wraps a Handler, failing on the given file.
+type failingHandler struct {
+	Handler
+	name string
+	err  error
+}
+
+func (h *failingHandler) NewFileEntry(s ssh.Session, path string) (*FileEntry, func() error, error) {
+	if filepath.Base(path) == h.name {
+		return nil, nil, h.err
+	}
+	return h.Handler.NewFileEntry(s, path)
+}
+
+func (h *failingHandler) Write(s ssh.Session, entry *FileEntry) (int64, error) {
+	if entry.Name == h.name {
+		// reads a bit to make sure the rest is skipped.
+		_, _ = io.CopyN(io.Discard, entry.Reader, 2)
+		return 0, h.err
+	}
+	return h.Handler.Write(s, entry)
+}
+
+func TestErrors(t *testing.T) {
+	t.Run("scp -f warning", func(t *testing.T) {
+		is := is.New(t)
+		dir := t.TempDir()
+		is.NoErr(os.MkdirAll(filepath.Join(dir, "a"), 0o755))
+		is.NoErr(os.WriteFile(filepath.Join(dir, "a/b.txt"), []byte("b text file"), 0o644))
+		is.NoErr(os.WriteFile(filepath.Join(dir, "a/c.txt"), []byte("c text file"), 0o644))
+		h := &failingHandler{NewFileSystemHandler(dir), "b.txt", errors.New("permission denied")}
+
+		session := setup(t, h, nil)
+		out, err := session.Output("scp -r -f a")
+		requireExitStatus(t, err, 1)
+		// entries are streamed, so the warning comes right where b.txt would be.
+		is.True(strings.Contains(string(out), "D0755 0 a\n\x01permission denied\n"))
+		is.True(strings.Contains(string(out), "C0644 11 c.txt\nc text file"))
+	})
+
+	t.Run("scp -f fatal", func(t *testing.T) {
+		is := is.New(t)
+		dir := t.TempDir()
+		is.NoErr(os.WriteFile(filepath.Join(dir, "b.txt"), []byte("b text file"), 0o644))
+		is.NoErr(os.WriteFile(filepath.Join(dir, "c.txt"), []byte("c text file"), 0o644))
+		h := &failingHandler{NewFileSystemHandler(dir), "b.txt", Fatalf("abort")}
+
+		session := setup(t, h, nil)
+		out, err := session.Output("scp -f *.txt")
+		requireExitStatus(t, err, 1)
+		is.Equal("\x02abort\n", string(out))
+	})
+
+	t.Run("scp -f no match", func(t *testing.T) {
+		is := is.New(t)
+		session := setup(t, NewFileSystemHandler(t.TempDir()), nil)
+		out, err := session.Output("scp -f nope")
+		requireExitStatus(t, err, 1)
+		is.Equal("\x01no files matching \"nope\"\n", string(out))
+	})
+
+	t.Run("scp -t warning", func(t *testing.T) {
+		is := is.New(t)
+		dir := t.TempDir()
+		h := &failingHandler{NewFileSystemHandler(dir), "a.txt", errors.New("disk full")}
+
+		var in bytes.Buffer
+		in.WriteString("C0644 5 a.txt\n")
+		in.WriteString("hello")
+		in.Write(NULL)
+		in.WriteString("C0644 5 b.txt\n")
+		in.WriteString("hello")
+		in.Write(NULL)
+
+		session := setup(t, nil, h)
+		session.Stdin = &in
+		out, err := session.Output("scp -t .")
+		requireExitStatus(t, err, 1)
+		is.Equal("\x00\x00\x01failed to write file: \"a.txt\": disk full\n\x00\x00\x00", string(out))
+
+		bts, err := os.ReadFile(filepath.Join(dir, "b.txt"))
+		is.NoErr(err)
+		is.Equal("hello", string(bts))
+	})
+
+	t.Run("scp -t mkdir warning", func(t *testing.T) {
+		is := is.New(t)
+		dir := t.TempDir()
+		is.NoErr(os.WriteFile(filepath.Join(dir, "a"), nil, 0o644))
+
+		var in bytes.Buffer
+		in.WriteString("D0755 0 a\n")
+		in.WriteString("C0644 5 b.txt\n")
+		in.WriteString("hello")
+		in.Write(NULL)
+
+		session := setup(t, nil, NewFileSystemHandler(dir))
+		session.Stdin = &in
+		out, err := session.Output("scp -r -t .")
+		requireExitStatus(t, err, 1)
+		is.True(strings.HasPrefix(string(out), "\x00\x01failed to create dir: \"a\""))
+
+		// the client skips the directory, so the next file lands in its parent.
+		bts, err := os.ReadFile(filepath.Join(dir, "b.txt"))
+		is.NoErr(err)
+		is.Equal("hello", string(bts))
+	})
+
+	t.Run("scp -t fatal", func(t *testing.T) {
+		is := is.New(t)
+		var in bytes.Buffer
+		in.WriteString("X nope\n")
+
+		session := setup(t, nil, NewFileSystemHandler(t.TempDir()))
+		session.Stdin = &in
+		out, err := session.Output("scp -t .")
+		requireExitStatus(t, err, 1)
+		is.Equal("\x00\x02unhandled input: \"X nope\"\n", string(out))
+	})
+}
@@ -24,7 +24,9 @@ func NewFileSystemHandler(root string) Handler {
 	}
 }
 
-func (h *fileSystemHandler) chtimes(path string, mtime, atime int64) error {
+// preserve applies the given mode and times, which are only set if the
+// client asked to preserve them.
+func (h *fileSystemHandler) preserve(path string, mode fs.FileMode, mtime, atime int64) error {
 	if mtime == 0 || atime == 0 {
 		return nil
 	}
@@ -32,6 +34,11 @@ func (h *fileSystemHandler) chtimes(path string, mtime, atime int64) error {
 	if err != nil {
 		return err
 	}
+	// the mode was already used on creation, but the umask may have
+	// changed it.
+	if err := os.Chmod(p, mode.Perm()); err != nil {
+		return fmt.Errorf("failed to chmod: %q: %w", path, err)
+	}
 	if err := os.Chtimes(
 		p,
 		time.Unix(atime, 0),
@@ -145,7 +152,7 @@ func (h *fileSystemHandler) Mkdir(_ ssh.Session, entry *DirEntry) error {
 	if err := os.Mkdir(p, entry.Mode); err != nil {
 		return fmt.Errorf("failed to create dir: %q: %w", entry.Filepath, err)
 	}
-	return h.chtimes(entry.Filepath, entry.Mtime, entry.Atime)
+	return h.preserve(entry.Filepath, entry.Mode, entry.Mtime, entry.Atime)
 }
 
 func (h *fileSystemHandler) Write(_ ssh.Session, entry *FileEntry) (int64, error) {
@@ -165,5 +172,5 @@ func (h *fileSystemHandler) Write(_ ssh.Session, entry *FileEntry) (int64, error
 	if err := f.Close(); err != nil {
 		return 0, fmt.Errorf("failed to close file: %q: %w", entry.Filepath, err)
 	}
-	return written, h.chtimes(entry.Filepath, entry.Mtime, entry.Atime)
+	return written, h.preserve(entry.Filepath, entry.Mode, entry.Mtime, entry.Atime)
 }
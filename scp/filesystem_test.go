@@ -6,6 +6,7 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"testing"
 	"testing/iotest"
@@ -28,7 +29,7 @@ func TestFilesystem(t *testing.T) {
 			chtimesTree(t, dir, atime, mtime)
 
 			session := setup(t, h, nil)
-			bts, err := session.CombinedOutput("scp -f a.txt")
+			bts, err := session.CombinedOutput("scp -p -f a.txt")
 			is.NoErr(err)
 			requireEqualGolden(t, bts)
 		})
@@ -43,7 +44,7 @@ func TestFilesystem(t *testing.T) {
 			chtimesTree(t, dir, atime, mtime)
 
 			session := setup(t, h, nil)
-			bts, err := session.CombinedOutput("scp -f *.txt")
+			bts, err := session.CombinedOutput("scp -p -f *.txt")
 			is.NoErr(err)
 			requireEqualGolden(t, bts)
 		})
@@ -55,7 +56,7 @@ func TestFilesystem(t *testing.T) {
 			h := NewFileSystemHandler(dir)
 
 			session := setup(t, h, nil)
-			_, err := session.CombinedOutput("scp -f a.txt")
+			_, err := session.CombinedOutput("scp -p -f a.txt")
 			is.True(err != nil)
 		})
 
@@ -71,7 +72,7 @@ func TestFilesystem(t *testing.T) {
 			chtimesTree(t, dir, atime, mtime)
 
 			session := setup(t, h, nil)
-			bts, err := session.CombinedOutput("scp -r -f a")
+			bts, err := session.CombinedOutput("scp -r -p -f a")
 			is.NoErr(err)
 			requireEqualGolden(t, bts)
 		})
@@ -88,7 +89,7 @@ func TestFilesystem(t *testing.T) {
 			chtimesTree(t, dir, atime, mtime)
 
 			session := setup(t, h, nil)
-			bts, err := session.CombinedOutput("scp -r -f a/*")
+			bts, err := session.CombinedOutput("scp -r -p -f a/*")
 			is.NoErr(err)
 			requireEqualGolden(t, bts)
 		})
@@ -100,7 +101,7 @@ func TestFilesystem(t *testing.T) {
 			h := NewFileSystemHandler(dir)
 
 			session := setup(t, h, nil)
-			_, err := session.CombinedOutput("scp -r -f a")
+			_, err := session.CombinedOutput("scp -r -p -f a")
 			is.True(err != nil)
 		})
 
@@ -116,7 +117,7 @@ func TestFilesystem(t *testing.T) {
 			chtimesTree(t, dir, atime, mtime)
 
 			session := setup(t, h, nil)
-			bts, err := session.CombinedOutput("scp -r -f /")
+			bts, err := session.CombinedOutput("scp -r -p -f /")
 			is.NoErr(err)
 			requireEqualGolden(t, bts)
 		})
@@ -165,7 +166,7 @@ func TestFilesystem(t *testing.T) {
 
 			session := setup(t, nil, h)
 			session.Stdin = &in
-			_, err := session.CombinedOutput("scp -r -t .")
+			_, err := session.CombinedOutput("scp -r -p -t .")
 			is.NoErr(err)
 
 			mtime := int64(1183832947)
@@ -186,10 +187,54 @@ func TestFilesystem(t *testing.T) {
 		})
 	})
 
+	t.Run("preserve", func(t *testing.T) {
+		for name, tc := range map[string]struct {
+			cmd      string
+			preserve bool
+		}{
+			"scp -t":    {"scp -t .", false},
+			"scp -p -t": {"scp -p -t .", true},
+		} {
+			t.Run(name, func(t *testing.T) {
+				is := is.New(t)
+				dir := t.TempDir()
+
+				var in bytes.Buffer
+				in.WriteString("T1183832947 0 1183833773 0\n")
+				in.WriteString("C0666 6 a.txt\n")
+				in.WriteString("hello\n")
+				in.Write(NULL)
+
+				session := setup(t, nil, NewFileSystemHandler(dir))
+				session.Stdin = &in
+				_, err := session.CombinedOutput(tc.cmd)
+				is.NoErr(err)
+
+				stat, err := os.Stat(filepath.Join(dir, "a.txt"))
+				is.NoErr(err)
+				is.Equal(tc.preserve, stat.ModTime().Unix() == 1183832947)
+				if tc.preserve && runtime.GOOS != "windows" {
+					// bypasses the umask.
+					is.Equal(fs.FileMode(0o666), stat.Mode().Perm())
+				}
+			})
+		}
+
+		t.Run("scp -f", func(t *testing.T) {
+			is := is.New(t)
+			dir := t.TempDir()
+			is.NoErr(os.WriteFile(filepath.Join(dir, "a.txt"), []byte("hello"), 0o644))
+
+			bts, err := setup(t, NewFileSystemHandler(dir), nil).Output("scp -f a.txt")
+			is.NoErr(err)
+			is.Equal("C0644 5 a.txt\nhello\x00", string(bts))
+		})
+	})
+
 	t.Run("errors", func(t *testing.T) {
-		t.Run("chtimes", func(t *testing.T) {
+		t.Run("preserve", func(t *testing.T) {
 			h := &fileSystemHandler{t.TempDir()}
-			is.New(t).True(h.chtimes("nope", 1212212, 323232) != nil) // should err
+			is.New(t).True(h.preserve("nope", 0o644, 1212212, 323232) != nil) // should err
 		})
 
 		t.Run("glob", func(t *testing.T) {
@@ -359,7 +404,7 @@ func TestPathTraversalEndToEnd(t *testing.T) {
 
 		session := setup(t, nil, h)
 		session.Stdin = &in
-		_, err := session.CombinedOutput("scp -r -t .")
+		_, err := session.CombinedOutput("scp -r -p -t .")
 		is.True(err != nil)
 		_, statErr := os.Stat(filepath.Join(root, "../../evil_dir"))
 		is.True(os.IsNotExist(statErr))
@@ -373,7 +418,7 @@ func TestPathTraversalEndToEnd(t *testing.T) {
 		h := NewFileSystemHandler(root)
 
 		session := setup(t, h, nil)
-		_, err := session.CombinedOutput("scp -f ../" + filepath.Base(secret) + "/secret.txt")
+		_, err := session.CombinedOutput("scp -p -f ../" + filepath.Base(secret) + "/secret.txt")
 		is.True(err != nil)
 	})
 
@@ -385,7 +430,7 @@ func TestPathTraversalEndToEnd(t *testing.T) {
 		h := NewFileSystemHandler(root)
 
 		session := setup(t, h, nil)
-		_, err := session.CombinedOutput("scp -f ../" + filepath.Base(secret) + "/secret*")
+		_, err := session.CombinedOutput("scp -p -f ../" + filepath.Base(secret) + "/secret*")
 		is.True(err != nil)
 	})
 
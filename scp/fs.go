@@ -2,7 +2,12 @@ package scp
 
 import (
 	"fmt"
+	"io"
 	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"charm.land/ssh"
 )
@@ -18,15 +23,15 @@ func NewFSReadHandler(fsys fs.FS) CopyToClientHandler {
 }
 
 func (h *fsHandler) Glob(_ ssh.Session, s string) ([]string, error) {
-	return fs.Glob(h.fsys, s) //nolint:wrapcheck
+	return fs.Glob(h.fsys, fsName(s)) //nolint:wrapcheck
 }
 
 func (h *fsHandler) WalkDir(_ ssh.Session, path string, fn fs.WalkDirFunc) error {
-	return fs.WalkDir(h.fsys, path, fn) //nolint:wrapcheck
+	return fs.WalkDir(h.fsys, fsName(path), fn) //nolint:wrapcheck
 }
 
 func (h *fsHandler) NewDirEntry(_ ssh.Session, path string) (*DirEntry, error) {
-	path = normalizePath(path)
+	path = fsName(path)
 	info, err := fs.Stat(h.fsys, path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open dir: %q: %w", path, err)
@@ -42,6 +47,7 @@ func (h *fsHandler) NewDirEntry(_ ssh.Session, path string) (*DirEntry, error) {
 }
 
 func (h *fsHandler) NewFileEntry(_ ssh.Session, path string) (*FileEntry, func() error, error) {
+	path = fsName(path)
 	info, err := fs.Stat(h.fsys, path)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to stat %q: %w", path, err)
@@ -60,3 +66,102 @@ func (h *fsHandler) NewFileEntry(_ ssh.Session, path string) (*FileEntry, func()
 		Reader:   f,
 	}, f.Close, nil
 }
+
+// WriteFS is the writable counterpart of fs.FS, used to receive files copied
+// from the client. Names follow the fs.FS conventions: they are unrooted,
+// slash-separated paths, with "." being the root.
+//
+// It can be implemented on top of anything that can store files, such as an
+// in-memory map or an object store.
+type WriteFS interface {
+	// Mkdir creates the given directory. Its parent must exist.
+	Mkdir(name string, perm fs.FileMode) error
+
+	// Create creates or truncates the given file. The file contents are
+	// written to the returned io.WriteCloser, and should only be considered
+	// complete after Close returns without error.
+	Create(name string, perm fs.FileMode) (io.WriteCloser, error)
+}
+
+// ChtimesFS can be implemented by a WriteFS to preserve the modification and
+// access times sent by the client.
+type ChtimesFS interface {
+	WriteFS
+
+	// Chtimes changes the access and modification times of the given file.
+	Chtimes(name string, atime, mtime time.Time) error
+}
+
+// ReadWriteFS is a fs.FS that can also be written to.
+type ReadWriteFS interface {
+	fs.FS
+	WriteFS
+}
+
+type fsWriteHandler struct{ fsys WriteFS }
+
+var _ CopyFromClientHandler = &fsWriteHandler{}
+
+// NewFSWriteHandler returns a write-only CopyFromClientHandler that accepts
+// any WriteFS as input.
+func NewFSWriteHandler(fsys WriteFS) CopyFromClientHandler {
+	return &fsWriteHandler{fsys: fsys}
+}
+
+// NewFSHandler returns a Handler that reads from and writes to the given
+// ReadWriteFS.
+func NewFSHandler(fsys ReadWriteFS) Handler {
+	return struct {
+		CopyToClientHandler
+		CopyFromClientHandler
+	}{
+		NewFSReadHandler(fsys),
+		NewFSWriteHandler(fsys),
+	}
+}
+
+// fsName converts a scp path to a fs.FS name, making sure it can't escape
+// the file system root.
+func fsName(name string) string {
+	name = strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(name)), "/")
+	if name == "" {
+		return "."
+	}
+	return name
+}
+
+func (h *fsWriteHandler) chtimes(name string, mtime, atime int64) error {
+	fsys, ok := h.fsys.(ChtimesFS)
+	if !ok || mtime == 0 || atime == 0 {
+		return nil
+	}
+	if err := fsys.Chtimes(name, time.Unix(atime, 0), time.Unix(mtime, 0)); err != nil {
+		return fmt.Errorf("failed to chtimes: %q: %w", name, err)
+	}
+	return nil
+}
+
+func (h *fsWriteHandler) Mkdir(_ ssh.Session, entry *DirEntry) error {
+	name := fsName(entry.Filepath)
+	if err := h.fsys.Mkdir(name, entry.Mode); err != nil {
+		return fmt.Errorf("failed to create dir: %q: %w", entry.Filepath, err)
+	}
+	return h.chtimes(name, entry.Mtime, entry.Atime)
+}
+
+func (h *fsWriteHandler) Write(_ ssh.Session, entry *FileEntry) (int64, error) {
+	name := fsName(entry.Filepath)
+	w, err := h.fsys.Create(name, entry.Mode)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open file: %q: %w", entry.Filepath, err)
+	}
+	defer w.Close() //nolint:errcheck
+	written, err := io.Copy(w, entry.Reader)
+	if err != nil {
+		return 0, fmt.Errorf("failed to write file: %q: %w", entry.Filepath, err)
+	}
+	if err := w.Close(); err != nil {
+		return 0, fmt.Errorf("failed to close file: %q: %w", entry.Filepath, err)
+	}
+	return written, h.chtimes(name, entry.Mtime, entry.Atime)
+}
@@ -3,6 +3,7 @@ package scp
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -23,11 +24,30 @@ func TestFS(t *testing.T) {
 		chtimesTree(t, dir, atime, mtime)
 
 		session := setup(t, h, nil)
-		bts, err := session.CombinedOutput("scp -f a.txt")
+		bts, err := session.CombinedOutput("scp -p -f a.txt")
 		is.NoErr(err)
 		requireEqualGolden(t, bts)
 	})
 
+	t.Run("absolute path", func(t *testing.T) {
+		is := is.New(t)
+
+		dir := t.TempDir()
+		h := NewFSReadHandler(os.DirFS(dir))
+		is.NoErr(os.MkdirAll(filepath.Join(dir, "a"), 0o755))
+		is.NoErr(os.WriteFile(filepath.Join(dir, "a/b.txt"), []byte("a text file"), 0o644))
+		chtimesTree(t, dir, atime, mtime)
+
+		expected, err := setup(t, h, nil).CombinedOutput("scp -p -f a/b.txt")
+		is.NoErr(err)
+		bts, err := setup(t, h, nil).CombinedOutput("scp -p -f /a/b.txt")
+		is.NoErr(err)
+		is.Equal(string(expected), string(bts))
+		bts, err = setup(t, h, nil).CombinedOutput("scp -r -p -f /a")
+		is.NoErr(err)
+		is.True(strings.Contains(string(bts), "a text file"))
+	})
+
 	t.Run("glob", func(t *testing.T) {
 		is := is.New(t)
 
@@ -38,7 +58,7 @@ func TestFS(t *testing.T) {
 		chtimesTree(t, dir, atime, mtime)
 
 		session := setup(t, h, nil)
-		bts, err := session.CombinedOutput("scp -f *.txt")
+		bts, err := session.CombinedOutput("scp -p -f *.txt")
 		is.NoErr(err)
 		requireEqualGolden(t, bts)
 	})
@@ -50,7 +70,7 @@ func TestFS(t *testing.T) {
 		h := NewFSReadHandler(os.DirFS(dir))
 
 		session := setup(t, h, nil)
-		_, err := session.CombinedOutput("scp -f a.txt")
+		_, err := session.CombinedOutput("scp -p -f a.txt")
 		is.True(err != nil)
 	})
 
@@ -66,7 +86,7 @@ func TestFS(t *testing.T) {
 		chtimesTree(t, dir, atime, mtime)
 
 		session := setup(t, h, nil)
-		bts, err := session.CombinedOutput("scp -r -f a")
+		bts, err := session.CombinedOutput("scp -r -p -f a")
 		is.NoErr(err)
 		requireEqualGolden(t, bts)
 	})
@@ -83,7 +103,7 @@ func TestFS(t *testing.T) {
 		chtimesTree(t, dir, atime, mtime)
 
 		session := setup(t, h, nil)
-		bts, err := session.CombinedOutput("scp -r -f a/*")
+		bts, err := session.CombinedOutput("scp -r -p -f a/*")
 		is.NoErr(err)
 		requireEqualGolden(t, bts)
 	})
@@ -100,7 +120,7 @@ func TestFS(t *testing.T) {
 		chtimesTree(t, dir, atime, mtime)
 
 		session := setup(t, h, nil)
-		bts, err := session.CombinedOutput("scp -r -f /")
+		bts, err := session.CombinedOutput("scp -r -p -f /")
 		is.NoErr(err)
 		requireEqualGolden(t, bts)
 	})
@@ -112,7 +132,7 @@ func TestFS(t *testing.T) {
 		h := NewFSReadHandler(os.DirFS(dir))
 
 		session := setup(t, h, nil)
-		_, err := session.CombinedOutput("scp -r -f a")
+		_, err := session.CombinedOutput("scp -r -p -f a")
 		is.True(err != nil)
 	})
 
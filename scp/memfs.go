@@ -0,0 +1,287 @@
+package scp
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemFS is an in-memory ReadWriteFS, useful for tests and for serving
+// generated content without touching the disk.
+//
+// It is safe for concurrent use.
+type MemFS struct {
+	mu    sync.RWMutex
+	files map[string]*memFile
+}
+
+var (
+	_ ReadWriteFS  = &MemFS{}
+	_ ChtimesFS    = &MemFS{}
+	_ fs.ReadDirFS = &MemFS{}
+	_ fs.StatFS    = &MemFS{}
+)
+
+type memFile struct {
+	name    string
+	data    []byte
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+// NewMemFS returns an empty MemFS.
+func NewMemFS() *MemFS {
+	return &MemFS{
+		files: map[string]*memFile{
+			".": {name: ".", mode: fs.ModeDir | 0o755, modTime: time.Now()},
+		},
+	}
+}
+
+func (m *MemFS) lookup(op, name string) (*memFile, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	f, ok := m.files[name]
+	if !ok {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return f, nil
+}
+
+// checkParent makes sure the parent of name is an existing directory.
+func (m *MemFS) checkParent(op, name string) error {
+	if !fs.ValidPath(name) || name == "." {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	parent, ok := m.files[path.Dir(name)]
+	if !ok || !parent.mode.IsDir() {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return nil
+}
+
+// Open implements fs.FS.
+func (m *MemFS) Open(name string) (fs.File, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	f, err := m.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if f.mode.IsDir() {
+		entries, _ := m.readDir(name)
+		return &memDir{info: f.info(), entries: entries}, nil
+	}
+	return &memReader{info: f.info(), Reader: bytes.NewReader(f.data)}, nil
+}
+
+// Stat implements fs.StatFS.
+func (m *MemFS) Stat(name string) (fs.FileInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	f, err := m.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return f.info(), nil
+}
+
+// ReadDir implements fs.ReadDirFS.
+func (m *MemFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.readDir(name)
+}
+
+func (m *MemFS) readDir(name string) ([]fs.DirEntry, error) {
+	f, err := m.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !f.mode.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	var entries []fs.DirEntry
+	for p, child := range m.files {
+		if p != "." && path.Dir(p) == name {
+			entries = append(entries, fs.FileInfoToDirEntry(child.info()))
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+// Mkdir implements WriteFS.
+func (m *MemFS) Mkdir(name string, perm fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkParent("mkdir", name); err != nil {
+		return err
+	}
+	if _, ok := m.files[name]; ok {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
+	}
+	m.files[name] = &memFile{
+		name:    name,
+		mode:    fs.ModeDir | perm.Perm(),
+		modTime: time.Now(),
+	}
+	return nil
+}
+
+// MkdirAll creates the given directory along with any missing parents.
+func (m *MemFS) MkdirAll(name string, perm fs.FileMode) error {
+	if name == "." {
+		return nil
+	}
+	if err := m.MkdirAll(path.Dir(name), perm); err != nil {
+		return err
+	}
+	if info, err := m.Stat(name); err == nil && info.IsDir() {
+		return nil
+	}
+	return m.Mkdir(name, perm)
+}
+
+// Create implements WriteFS. The contents become visible once the returned
+// writer is closed.
+func (m *MemFS) Create(name string, perm fs.FileMode) (io.WriteCloser, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if err := m.checkParent("create", name); err != nil {
+		return nil, err
+	}
+	if f, ok := m.files[name]; ok && f.mode.IsDir() {
+		return nil, &fs.PathError{Op: "create", Path: name, Err: fs.ErrExist}
+	}
+	return &memWriter{fsys: m, name: name, mode: perm.Perm()}, nil
+}
+
+// WriteFile writes the given data to the named file, creating it if
+// necessary.
+func (m *MemFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	w, err := m.Create(name, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err //nolint:wrapcheck
+	}
+	return w.Close()
+}
+
+// Chtimes implements ChtimesFS. Only the modification time is kept.
+func (m *MemFS) Chtimes(name string, _, mtime time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, err := m.lookup("chtimes", name)
+	if err != nil {
+		return err
+	}
+	f.modTime = mtime
+	return nil
+}
+
+func (f *memFile) info() *memFileInfo {
+	return &memFileInfo{
+		name:    path.Base(f.name),
+		size:    int64(len(f.data)),
+		mode:    f.mode,
+		modTime: f.modTime,
+	}
+}
+
+type memFileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (i *memFileInfo) Name() string       { return i.name }
+func (i *memFileInfo) Size() int64        { return i.size }
+func (i *memFileInfo) Mode() fs.FileMode  { return i.mode }
+func (i *memFileInfo) ModTime() time.Time { return i.modTime }
+func (i *memFileInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i *memFileInfo) Sys() any           { return nil }
+
+type memReader struct {
+	*bytes.Reader
+	info *memFileInfo
+}
+
+func (r *memReader) Stat() (fs.FileInfo, error) { return r.info, nil }
+func (r *memReader) Close() error               { return nil }
+
+type memDir struct {
+	info    *memFileInfo
+	entries []fs.DirEntry
+}
+
+func (d *memDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *memDir) Close() error               { return nil }
+
+func (d *memDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: fs.ErrInvalid}
+}
+
+func (d *memDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	n = min(n, len(d.entries))
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
+
+type memWriter struct {
+	fsys   *MemFS
+	name   string
+	mode   fs.FileMode
+	buf    bytes.Buffer
+	closed bool
+}
+
+func (w *memWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, fs.ErrClosed
+	}
+	return w.buf.Write(p) //nolint:wrapcheck
+}
+
+// Close commits the written contents. Closing more than once is a no-op.
+func (w *memWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	w.fsys.mu.Lock()
+	defer w.fsys.mu.Unlock()
+	if err := w.fsys.checkParent("create", w.name); err != nil {
+		return err
+	}
+	if f, ok := w.fsys.files[w.name]; ok && f.mode.IsDir() {
+		return &fs.PathError{Op: "create", Path: w.name, Err: fs.ErrExist}
+	}
+	w.fsys.files[w.name] = &memFile{
+		name:    w.name,
+		data:    bytes.Clone(w.buf.Bytes()),
+		mode:    w.mode,
+		modTime: time.Now(),
+	}
+	return nil
+}
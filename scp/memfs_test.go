@@ -0,0 +1,118 @@
+package scp
+
+import (
+	"bytes"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestMemFS(t *testing.T) {
+	t.Run("fstest", func(t *testing.T) {
+		is := is.New(t)
+		m := NewMemFS()
+		is.NoErr(m.MkdirAll("a/b", 0o755))
+		is.NoErr(m.WriteFile("a/b/c.txt", []byte("c text file"), 0o644))
+		is.NoErr(m.WriteFile("d.txt", []byte("d text file"), 0o600))
+		is.NoErr(fstest.TestFS(m, "a/b/c.txt", "d.txt"))
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		is := is.New(t)
+		m := NewMemFS()
+		is.True(m.Mkdir("a/b", 0o755) != nil)               // parent do not exist
+		is.True(m.WriteFile("a/b.txt", nil, 0o644) != nil)  // parent do not exist
+		is.True(m.WriteFile("../b.txt", nil, 0o644) != nil) // invalid path
+		is.NoErr(m.Mkdir("a", 0o755))
+		is.True(m.Mkdir("a", 0o755) != nil)                       // already exists
+		is.True(m.WriteFile("a", nil, 0o644) != nil)              // is a dir
+		is.True(m.Chtimes("nope", time.Now(), time.Now()) != nil) // do not exist
+		_, err := m.Open("nope")
+		is.True(err != nil) // do not exist
+	})
+
+	t.Run("uncommitted", func(t *testing.T) {
+		is := is.New(t)
+		m := NewMemFS()
+		w, err := m.Create("a.txt", 0o644)
+		is.NoErr(err)
+		_, err = w.Write([]byte("hello"))
+		is.NoErr(err)
+		_, err = m.Stat("a.txt")
+		is.True(err != nil) // not visible until closed
+		is.NoErr(w.Close())
+		is.NoErr(w.Close())
+		bts, err := fs.ReadFile(m, "a.txt")
+		is.NoErr(err)
+		is.Equal("hello", string(bts))
+	})
+}
+
+func TestFSHandler(t *testing.T) {
+	mtime := int64(1183832947)
+
+	t.Run("scp -t", func(t *testing.T) {
+		is := is.New(t)
+		m := NewMemFS()
+
+		var in bytes.Buffer
+		in.WriteString("D0755 0 folder1\n")
+		in.WriteString("T1183832947 0 1183833773 0\n")
+		in.WriteString("C0644 6 file1\n")
+		in.WriteString("hello\n")
+		in.Write(NULL)
+		in.WriteString("E\n")
+
+		session := setup(t, nil, NewFSWriteHandler(m))
+		session.Stdin = &in
+		_, err := session.CombinedOutput("scp -r -p -t /")
+		is.NoErr(err)
+
+		info, err := m.Stat("folder1")
+		is.NoErr(err)
+		is.True(info.IsDir())
+
+		info, err = m.Stat("folder1/file1")
+		is.NoErr(err)
+		is.Equal(fs.FileMode(0o644), info.Mode())
+		is.Equal(mtime, info.ModTime().Unix())
+
+		bts, err := fs.ReadFile(m, "folder1/file1")
+		is.NoErr(err)
+		is.Equal("hello\n", string(bts))
+	})
+
+	t.Run("scp -t traversal", func(t *testing.T) {
+		is := is.New(t)
+		m := NewMemFS()
+
+		var in bytes.Buffer
+		in.WriteString("C0644 5 file\n")
+		in.WriteString("hello")
+		in.Write(NULL)
+
+		session := setup(t, nil, NewFSWriteHandler(m))
+		session.Stdin = &in
+		_, err := session.CombinedOutput("scp -t ../../")
+		is.NoErr(err)
+
+		_, err = m.Stat("file")
+		is.NoErr(err)
+	})
+
+	t.Run("scp -f", func(t *testing.T) {
+		is := is.New(t)
+		m := NewMemFS()
+		is.NoErr(m.MkdirAll("a/b", 0o755))
+		is.NoErr(m.WriteFile("a/b/c.txt", []byte("c text file"), 0o644))
+		is.NoErr(m.Chtimes("a/b/c.txt", time.Unix(mtime, 0), time.Unix(mtime, 0)))
+
+		session := setup(t, NewFSHandler(m), nil)
+		bts, err := session.CombinedOutput("scp -p -f a/b/c.txt")
+		is.NoErr(err)
+		is.Equal("T1183832947 0 1183832947 0\nC0644 11 c.txt\nc text file\x00", string(bts))
+	})
+}
@@ -0,0 +1,65 @@
+package scp
+
+import (
+	"io"
+
+	"charm.land/ssh"
+)
+
+// Transfer describes a single file being copied.
+type Transfer struct {
+	// Op is the direction of the copy.
+	Op Op
+
+	// Path is the server path of the file.
+	Path string
+
+	// Size is the size of the file, in bytes.
+	Size int64
+
+	// Bytes is the number of bytes transferred so far.
+	Bytes int64
+}
+
+// Observer can be implemented to be notified about the files copied by the
+// Middleware, e.g. to report progress or audit transfers.
+//
+// For each file, Start is called first, then Progress as the file is
+// transferred, and finally either Finish or Error.
+type Observer interface {
+	// Start is called when a file is about to be transferred.
+	Start(ssh.Session, Transfer)
+
+	// Progress is called every time some bytes of the file are transferred.
+	Progress(ssh.Session, Transfer)
+
+	// Finish is called once the file was transferred successfully.
+	Finish(ssh.Session, Transfer)
+
+	// Error is called if the file transfer failed or was skipped.
+	Error(ssh.Session, Transfer, error)
+}
+
+type noopObserver struct{}
+
+func (noopObserver) Start(ssh.Session, Transfer)        {}
+func (noopObserver) Progress(ssh.Session, Transfer)     {}
+func (noopObserver) Finish(ssh.Session, Transfer)       {}
+func (noopObserver) Error(ssh.Session, Transfer, error) {}
+
+// observedReader reports the bytes read from a file to an Observer.
+type observedReader struct {
+	r   io.Reader
+	s   ssh.Session
+	obs Observer
+	t   *Transfer
+}
+
+func (r *observedReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	if n > 0 {
+		r.t.Bytes += int64(n)
+		r.obs.Progress(r.s, *r.t)
+	}
+	return n, err //nolint:wrapcheck
+}
@@ -0,0 +1,91 @@
+package scp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"charm.land/ssh"
+	"github.com/matryer/is"
+)
+
+// recordingObserver keeps track of the events it got.
+type recordingObserver struct {
+	mu       sync.Mutex
+	events   []string
+	progress int64
+}
+
+func (o *recordingObserver) record(format string, v ...any) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.events = append(o.events, fmt.Sprintf(format, v...))
+}
+
+func (o *recordingObserver) Start(_ ssh.Session, t Transfer) {
+	o.record("start %c %s %d", t.Op, filepath.Base(t.Path), t.Size)
+}
+
+func (o *recordingObserver) Progress(_ ssh.Session, t Transfer) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.progress = t.Bytes
+}
+
+func (o *recordingObserver) Finish(_ ssh.Session, t Transfer) {
+	o.record("finish %c %s %d/%d", t.Op, filepath.Base(t.Path), t.Bytes, t.Size)
+}
+
+func (o *recordingObserver) Error(_ ssh.Session, t Transfer, err error) {
+	o.record("error %c %s %v", t.Op, filepath.Base(t.Path), errors.Is(err, ErrQuotaExceeded))
+}
+
+func TestObserver(t *testing.T) {
+	t.Run("scp -t", func(t *testing.T) {
+		is := is.New(t)
+		obs := &recordingObserver{}
+
+		var in bytes.Buffer
+		in.WriteString("C0644 100 big.txt\n")
+		in.WriteString("C0644 5 small.txt\n")
+		in.WriteString("hello")
+		in.Write(NULL)
+
+		session := setupWithOptions(t, nil, NewFileSystemHandler(t.TempDir()), WithObserver(obs), WithLimits(Limits{MaxFileSize: 10}))
+		session.Stdin = &in
+		_, err := session.Output("scp -t .")
+		requireExitStatus(t, err, 1)
+		is.Equal([]string{
+			"start t big.txt 100",
+			"error t big.txt true",
+			"start t small.txt 5",
+			"finish t small.txt 5/5",
+		}, obs.events)
+		is.Equal(int64(5), obs.progress)
+	})
+
+	t.Run("scp -f", func(t *testing.T) {
+		is := is.New(t)
+		dir := t.TempDir()
+		is.NoErr(os.WriteFile(filepath.Join(dir, "a.txt"), []byte("hello"), 0o644))
+		is.NoErr(os.WriteFile(filepath.Join(dir, "b.txt"), []byte("hello world"), 0o644))
+		obs := &recordingObserver{}
+
+		session := setupWithOptions(t, NewFileSystemHandler(dir), nil, WithObserver(obs))
+		// refuses a.txt, accepts b.txt.
+		session.Stdin = bytes.NewReader([]byte("\x00\x01nope\n\x00\x00"))
+		_, err := session.Output("scp -f *.txt")
+		requireExitStatus(t, err, 1)
+		is.Equal([]string{
+			"start f a.txt 5",
+			"error f a.txt false",
+			"start f b.txt 11",
+			"finish f b.txt 11/11",
+		}, obs.events)
+		is.Equal(int64(11), obs.progress)
+	})
+}
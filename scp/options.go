@@ -0,0 +1,53 @@
+package scp
+
+import "charm.land/ssh"
+
+// Option configures the scp Middleware.
+type Option func(*options)
+
+type options struct {
+	limits        func(ssh.Session) Limits
+	onClientError func(ssh.Session, *ClientError)
+	observer      Observer
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		limits:        func(ssh.Session) Limits { return Limits{} },
+		onClientError: func(ssh.Session, *ClientError) {},
+		observer:      noopObserver{},
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithLimits sets the upload Limits applied to every session.
+func WithLimits(l Limits) Option {
+	return WithLimitsFunc(func(ssh.Session) Limits { return l })
+}
+
+// WithLimitsFunc sets a function returning the upload Limits for a given
+// session, which allows different limits per user or public key.
+func WithLimitsFunc(fn func(ssh.Session) Limits) Option {
+	return func(o *options) {
+		o.limits = fn
+	}
+}
+
+// WithClientErrorHandler sets a function called whenever the client reports
+// an error while receiving files, e.g. to log it or clean up.
+func WithClientErrorHandler(fn func(ssh.Session, *ClientError)) Option {
+	return func(o *options) {
+		o.onClientError = fn
+	}
+}
+
+// WithObserver sets an Observer notified about every file copied to or from
+// the client.
+func WithObserver(obs Observer) Option {
+	return func(o *options) {
+		o.observer = obs
+	}
+}
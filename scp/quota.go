@@ -0,0 +1,57 @@
+package scp
+
+import "errors"
+
+// ErrQuotaExceeded is returned when a copy from the client exceeds the
+// configured Limits.
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// Limits restricts what clients can copy to the server in a single session.
+// Zero values mean no limit.
+type Limits struct {
+	// MaxFileSize is the maximum size of a single file, in bytes. Bigger files
+	// are skipped with a warning, and the rest of the transfer continues.
+	MaxFileSize int64
+
+	// MaxTotalSize is the maximum number of bytes in the whole session.
+	MaxTotalSize int64
+
+	// MaxFiles is the maximum number of files in the whole session.
+	MaxFiles int
+
+	// MaxDepth is the maximum directory depth, relative to the target path.
+	// Deeper directories are skipped with a warning.
+	MaxDepth int
+}
+
+// quota keeps track of a session usage against its Limits.
+type quota struct {
+	limits Limits
+	files  int
+	total  int64
+}
+
+func (q *quota) checkDir(name string, depth int) error {
+	if q.limits.MaxDepth > 0 && depth > q.limits.MaxDepth {
+		return Warningf("%w: %s: max directory depth is %d", ErrQuotaExceeded, name, q.limits.MaxDepth)
+	}
+	return nil
+}
+
+// checkFile checks the given file against the limits, and accounts for it if
+// it is allowed.
+func (q *quota) checkFile(name string, size int64) error {
+	l := q.limits
+	if l.MaxFileSize > 0 && size > l.MaxFileSize {
+		return Warningf("%w: %s: max file size is %d bytes", ErrQuotaExceeded, name, l.MaxFileSize)
+	}
+	if l.MaxFiles > 0 && q.files+1 > l.MaxFiles {
+		return Fatalf("%w: %s: max number of files is %d", ErrQuotaExceeded, name, l.MaxFiles)
+	}
+	if l.MaxTotalSize > 0 && q.total+size > l.MaxTotalSize {
+		return Fatalf("%w: %s: max total size is %d bytes", ErrQuotaExceeded, name, l.MaxTotalSize)
+	}
+	q.files++
+	q.total += size
+	return nil
+}
@@ -0,0 +1,177 @@
+package scp
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"charm.land/ssh"
+	"charm.land/wish/v2/testsession"
+	"github.com/matryer/is"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func TestLimits(t *testing.T) {
+	t.Run("max file size", func(t *testing.T) {
+		is := is.New(t)
+		dir := t.TempDir()
+
+		var in bytes.Buffer
+		in.WriteString("C0644 100 big.txt\n")
+		in.WriteString("C0644 5 small.txt\n")
+		in.WriteString("hello")
+		in.Write(NULL)
+
+		session := setupWithOptions(t, nil, NewFileSystemHandler(dir), WithLimits(Limits{MaxFileSize: 10}))
+		session.Stdin = &in
+		out, err := session.Output("scp -t .")
+		requireExitStatus(t, err, 1) // warnings still fail the transfer
+		is.Equal("\x00\x01quota exceeded: big.txt: max file size is 10 bytes\n\x00\x00\x00", string(out))
+
+		_, err = os.Stat(filepath.Join(dir, "big.txt"))
+		is.True(os.IsNotExist(err))
+		bts, err := os.ReadFile(filepath.Join(dir, "small.txt"))
+		is.NoErr(err)
+		is.Equal("hello", string(bts))
+	})
+
+	t.Run("max files", func(t *testing.T) {
+		is := is.New(t)
+		dir := t.TempDir()
+
+		var in bytes.Buffer
+		in.WriteString("C0644 5 a.txt\n")
+		in.WriteString("hello")
+		in.Write(NULL)
+		in.WriteString("C0644 5 b.txt\n")
+		in.WriteString("hello")
+		in.Write(NULL)
+
+		session := setupWithOptions(t, nil, NewFileSystemHandler(dir), WithLimits(Limits{MaxFiles: 1}))
+		session.Stdin = &in
+		out, err := session.Output("scp -t .")
+		requireExitStatus(t, err, 1)
+		is.Equal("\x00\x00\x00\x02quota exceeded: b.txt: max number of files is 1\n", string(out))
+
+		_, err = os.Stat(filepath.Join(dir, "b.txt"))
+		is.True(os.IsNotExist(err))
+	})
+
+	t.Run("max total size", func(t *testing.T) {
+		is := is.New(t)
+		dir := t.TempDir()
+
+		var in bytes.Buffer
+		in.WriteString("C0644 5 a.txt\n")
+		in.WriteString("hello")
+		in.Write(NULL)
+		in.WriteString("C0644 5 b.txt\n")
+		in.WriteString("hello")
+		in.Write(NULL)
+
+		session := setupWithOptions(t, nil, NewFileSystemHandler(dir), WithLimits(Limits{MaxTotalSize: 8}))
+		session.Stdin = &in
+		out, err := session.Output("scp -t .")
+		requireExitStatus(t, err, 1)
+		is.Equal("\x00\x00\x00\x02quota exceeded: b.txt: max total size is 8 bytes\n", string(out))
+	})
+
+	t.Run("max depth", func(t *testing.T) {
+		is := is.New(t)
+		dir := t.TempDir()
+
+		var in bytes.Buffer
+		in.WriteString("D0755 0 a\n")
+		in.WriteString("D0755 0 b\n")
+		in.WriteString("C0644 5 c.txt\n")
+		in.WriteString("hello")
+		in.Write(NULL)
+		in.WriteString("E\n")
+
+		session := setupWithOptions(t, nil, NewFileSystemHandler(dir), WithLimits(Limits{MaxDepth: 1}))
+		session.Stdin = &in
+		_, err := session.Output("scp -r -t .")
+		requireExitStatus(t, err, 1)
+
+		_, err = os.Stat(filepath.Join(dir, "a/b"))
+		is.True(os.IsNotExist(err))
+		bts, err := os.ReadFile(filepath.Join(dir, "a/c.txt"))
+		is.NoErr(err)
+		is.Equal("hello", string(bts))
+	})
+
+	t.Run("unmatched end of directory", func(t *testing.T) {
+		is := is.New(t)
+		root := t.TempDir()
+		dir := filepath.Join(root, "target")
+		is.NoErr(os.Mkdir(dir, 0o755))
+
+		var in bytes.Buffer
+		in.WriteString("E\n")
+		in.WriteString("D0755 0 a\n")
+		in.WriteString("C0644 5 x.txt\n")
+		in.WriteString("hello")
+		in.Write(NULL)
+		in.WriteString("E\n")
+
+		session := setupWithOptions(t, nil, NewFileSystemHandler(dir), WithLimits(Limits{MaxDepth: 1}))
+		session.Stdin = &in
+		_, err := session.Output("scp -r -t .")
+		requireExitStatus(t, err, 1)
+
+		entries, err := os.ReadDir(root)
+		is.NoErr(err)
+		is.Equal(1, len(entries)) // only the target directory
+		entries, err = os.ReadDir(dir)
+		is.NoErr(err)
+		is.Equal(0, len(entries))
+	})
+
+	t.Run("per user", func(t *testing.T) {
+		is := is.New(t)
+		dir := t.TempDir()
+
+		var in bytes.Buffer
+		in.WriteString("C0644 5 a.txt\n")
+		in.WriteString("hello")
+		in.Write(NULL)
+
+		session := setupWithOptions(t, nil, NewFileSystemHandler(dir), WithLimitsFunc(func(s ssh.Session) Limits {
+			if s.User() == "testuser" {
+				return Limits{MaxFiles: 1}
+			}
+			return Limits{MaxFiles: -1}
+		}))
+		session.Stdin = &in
+		_, err := session.Output("scp -t .")
+		is.NoErr(err)
+	})
+}
+
+func setupWithOptions(tb testing.TB, rh CopyToClientHandler, wh CopyFromClientHandler, opts ...Option) *gossh.Session {
+	tb.Helper()
+	session := testsession.New(tb, &ssh.Server{
+		Handler: Middleware(rh, wh, opts...)(func(s ssh.Session) {
+			s.Exit(0)
+		}),
+	}, nil)
+	if rh != nil {
+		// only sessions copying to the client read acks; the others may
+		// exit before reading stdin at all.
+		session.Stdin = acks()
+	}
+	return session
+}
+
+func requireExitStatus(tb testing.TB, err error, code int) {
+	tb.Helper()
+	var exitErr *gossh.ExitError
+	if !errors.As(err, &exitErr) {
+		tb.Fatalf("expected exit error, got %v", err)
+	}
+	if exitErr.ExitStatus() != code {
+		tb.Fatalf("expected exit status %d, got %d", code, exitErr.ExitStatus())
+	}
+}
@@ -2,6 +2,7 @@
 package scp
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
@@ -57,7 +58,8 @@ type Handler interface {
 
 // Middleware provides a wish middleware using the given CopyToClientHandler
 // and CopyFromClientHandler.
-func Middleware(rh CopyToClientHandler, wh CopyFromClientHandler) wish.Middleware {
+func Middleware(rh CopyToClientHandler, wh CopyFromClientHandler, opts ...Option) wish.Middleware {
+	o := newOptions(opts)
 	return func(sh ssh.Handler) ssh.Handler {
 		return func(s ssh.Session) {
 			info := GetInfo(s.Command())
@@ -67,22 +69,29 @@ func Middleware(rh CopyToClientHandler, wh CopyFromClientHandler) wish.Middlewar
 			}
 
 			var err error
+			rep := &reporter{w: s}
 			switch info.Op {
 			case OpCopyToClient:
 				if rh == nil {
 					err = fmt.Errorf("no handler provided for scp -f")
 					break
 				}
-				err = copyToClient(s, info, rh)
+				err = copyToClient(s, rep, info, rh, o)
 			case OpCopyFromClient:
 				if wh == nil {
 					err = fmt.Errorf("no handler provided for scp -t")
 					break
 				}
-				err = copyFromClient(s, info, wh)
+				err = copyFromClient(s, rep, info, wh, o)
 			}
-			if err != nil {
-				wish.Fatal(s, err)
+			var cerr *ClientError
+			if err != nil && !errors.As(err, &cerr) {
+				// reports the error unless it was reported already, or
+				// it came from the client itself.
+				_ = rep.report(&FatalError{err})
+			}
+			if err != nil || rep.warnings > 0 {
+				_ = s.Exit(1)
 				return
 			}
 		}
@@ -112,6 +121,10 @@ type AppendableEntry interface {
 
 // FileEntry is an Entry that reads from a Reader, defining a file and
 // its contents.
+//
+// Atime and Mtime are only sent to the client when it asked to preserve them
+// (scp -p). Likewise, they are only set on files copied from the client in
+// that case.
 type FileEntry struct {
 	Name     string
 	Filepath string
@@ -184,6 +197,8 @@ func (e *RootEntry) Write(w io.Writer) error {
 
 // DirEntry is an Entry with mode, possibly children, and possibly a
 // parent.
+//
+// As with FileEntry, Atime and Mtime are only used when preserving.
 type DirEntry struct {
 	Children []Entry
 	Name     string
@@ -261,6 +276,17 @@ type Info struct {
 	// Recursive is true if its a recursive SCP.
 	Recursive bool
 
+	// Preserve is true if the client asked to preserve modification times,
+	// access times, and modes (scp -p).
+	Preserve bool
+
+	// TargetIsDir is true if the client expects the target path to be a
+	// directory, which is the case when copying multiple files (scp -d).
+	TargetIsDir bool
+
+	// Verbose is true if the client is running in verbose mode (scp -v).
+	Verbose bool
+
 	// Path is the server path of the scp operation.
 	Path string
 
@@ -279,6 +305,12 @@ func GetInfo(cmd []string) Info {
 		switch p {
 		case "-r":
 			info.Recursive = true
+		case "-p":
+			info.Preserve = true
+		case "-d":
+			info.TargetIsDir = true
+		case "-v":
+			info.Verbose = true
 		case "-f":
 			if i+1 >= len(cmd) {
 				return info
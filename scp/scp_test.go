@@ -2,13 +2,12 @@ package scp
 
 import (
 	"bytes"
+	"io"
 	"os"
 	"path/filepath"
 	"runtime"
 	"testing"
 
-	"charm.land/ssh"
-	"charm.land/wish/v2/testsession"
 	"github.com/google/go-cmp/cmp"
 	"github.com/matryer/is"
 	gossh "golang.org/x/crypto/ssh"
@@ -53,6 +52,17 @@ func TestGetInfo(t *testing.T) {
 		is.Equal("file", info.Path)
 	})
 
+	t.Run("scp flags", func(t *testing.T) {
+		is := is.New(t)
+		info := GetInfo([]string{"scp", "-v", "-r", "-p", "-d", "-t", "dir"})
+		is.True(info.Ok)
+		is.True(info.Recursive)
+		is.True(info.Preserve)
+		is.True(info.TargetIsDir)
+		is.True(info.Verbose)
+		is.Equal(info.Op, OpCopyFromClient)
+	})
+
 	t.Run("missing path after -f", func(t *testing.T) {
 		info := GetInfo([]string{"scp", "-f"})
 		is.New(t).Equal(info.Ok, false)
@@ -124,11 +134,12 @@ func TestInvalidOps(t *testing.T) {
 
 func setup(tb testing.TB, rh CopyToClientHandler, wh CopyFromClientHandler) *gossh.Session {
 	tb.Helper()
-	return testsession.New(tb, &ssh.Server{
-		Handler: Middleware(rh, wh)(func(s ssh.Session) {
-			s.Exit(0)
-		}),
-	}, nil)
+	return setupWithOptions(tb, rh, wh)
+}
+
+// acks returns a client input that acknowledges everything the server sends.
+func acks() io.Reader {
+	return bytes.NewReader(bytes.Repeat(NULL, 1024))
 }
 
 func requireEqualGolden(tb testing.TB, out []byte) {
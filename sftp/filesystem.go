@@ -0,0 +1,161 @@
+package sftp
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"charm.land/ssh"
+)
+
+// fileSystemHandler is a Handler implementation for a given root path.
+type fileSystemHandler struct{ root string }
+
+var (
+	_ Handler        = &fileSystemHandler{}
+	_ SetStatHandler = &fileSystemHandler{}
+)
+
+// NewFileSystemHandler return a Handler based on the given dir. Clients can't
+// access anything outside of it.
+func NewFileSystemHandler(root string) Handler {
+	return &fileSystemHandler{
+		root: filepath.Clean(root),
+	}
+}
+
+func (h *fileSystemHandler) prefixed(p string) (string, error) {
+	safe := filepath.FromSlash(path.Clean("/" + p))
+	joined := filepath.Join(h.root, safe)
+	if joined != h.root && !strings.HasPrefix(joined, h.root+string(filepath.Separator)) {
+		return "", fmt.Errorf("path traversal detected: %q resolves outside root", p)
+	}
+	return joined, nil
+}
+
+func (h *fileSystemHandler) Stat(_ ssh.Session, name string) (fs.FileInfo, error) {
+	p, err := h.prefixed(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(p)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat %q: %w", name, err)
+	}
+	return info, nil
+}
+
+func (h *fileSystemHandler) ReadDir(_ ssh.Session, name string) ([]fs.FileInfo, error) {
+	p, err := h.prefixed(name)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(p)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dir: %q: %w", name, err)
+	}
+	infos := make([]fs.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat %q: %w", entry.Name(), err)
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+func (h *fileSystemHandler) Open(_ ssh.Session, name string) (ReadFile, error) {
+	p, err := h.prefixed(name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %q: %w", name, err)
+	}
+	return f, nil
+}
+
+func (h *fileSystemHandler) Write(_ ssh.Session, name string, flag int, mode fs.FileMode) (WriteFile, error) {
+	p, err := h.prefixed(name)
+	if err != nil {
+		return nil, err
+	}
+	// os.File.WriteAt refuses files opened with O_APPEND, clients send the
+	// offsets themselves anyway.
+	f, err := os.OpenFile(p, flag&^os.O_APPEND, mode)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %q: %w", name, err)
+	}
+	return f, nil
+}
+
+func (h *fileSystemHandler) Rename(_ ssh.Session, from, to string) error {
+	pfrom, err := h.prefixed(from)
+	if err != nil {
+		return err
+	}
+	pto, err := h.prefixed(to)
+	if err != nil {
+		return err
+	}
+	if pfrom == h.root || pto == h.root {
+		return fmt.Errorf("failed to rename %q: %w", from, fs.ErrPermission)
+	}
+	if err := os.Rename(pfrom, pto); err != nil {
+		return fmt.Errorf("failed to rename %q: %w", from, err)
+	}
+	return nil
+}
+
+func (h *fileSystemHandler) Remove(_ ssh.Session, name string) error {
+	p, err := h.prefixed(name)
+	if err != nil {
+		return err
+	}
+	if p == h.root {
+		return fmt.Errorf("failed to remove %q: %w", name, fs.ErrPermission)
+	}
+	if err := os.Remove(p); err != nil {
+		return fmt.Errorf("failed to remove %q: %w", name, err)
+	}
+	return nil
+}
+
+func (h *fileSystemHandler) Mkdir(_ ssh.Session, name string, mode fs.FileMode) error {
+	p, err := h.prefixed(name)
+	if err != nil {
+		return err
+	}
+	if err := os.Mkdir(p, mode); err != nil {
+		return fmt.Errorf("failed to create dir: %q: %w", name, err)
+	}
+	return nil
+}
+
+func (h *fileSystemHandler) SetStat(_ ssh.Session, name string, attrs Attrs) error {
+	p, err := h.prefixed(name)
+	if err != nil {
+		return err
+	}
+	if attrs.HasSize() {
+		if err := os.Truncate(p, int64(attrs.Size)); err != nil { //nolint:gosec
+			return fmt.Errorf("failed to truncate %q: %w", name, err)
+		}
+	}
+	if attrs.HasMode() {
+		if err := os.Chmod(p, attrs.Mode().Perm()); err != nil {
+			return fmt.Errorf("failed to chmod %q: %w", name, err)
+		}
+	}
+	if attrs.HasTimes() {
+		if err := os.Chtimes(p, attrs.AccessTime(), attrs.ModTime()); err != nil {
+			return fmt.Errorf("failed to chtimes: %q: %w", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package sftp
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestFileSystemHandlerPrefixed(t *testing.T) {
+	root := t.TempDir()
+	h := NewFileSystemHandler(root).(*fileSystemHandler)
+
+	for name, tc := range map[string]struct {
+		path     string
+		expected string
+	}{
+		"root":                  {"/", root},
+		"empty":                 {"", root},
+		"file":                  {"/a.txt", filepath.Join(root, "a.txt")},
+		"relative":              {"dir/a.txt", filepath.Join(root, "dir", "a.txt")},
+		"parent":                {"../a.txt", filepath.Join(root, "a.txt")},
+		"nested parent":         {"/dir/../../../a.txt", filepath.Join(root, "a.txt")},
+		"absolute outside":      {"/etc/passwd", filepath.Join(root, "etc", "passwd")},
+		"root prefix is jailed": {root + "/a.txt", filepath.Join(root, root, "a.txt")},
+	} {
+		t.Run(name, func(t *testing.T) {
+			is := is.New(t)
+			p, err := h.prefixed(tc.path)
+			is.NoErr(err)
+			is.Equal(tc.expected, p)
+		})
+	}
+}
+
+func TestFileSystemHandlerRoot(t *testing.T) {
+	is := is.New(t)
+	h := NewFileSystemHandler(t.TempDir())
+	is.True(h.Remove(nil, "/") != nil)
+	is.True(h.Rename(nil, "/", "/foo") != nil)
+}
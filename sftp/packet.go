@@ -0,0 +1,300 @@
+package sftp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"time"
+)
+
+// packet types, as defined in draft-ietf-secsh-filexfer-02.
+const (
+	fxpInit     = 1
+	fxpVersion  = 2
+	fxpOpen     = 3
+	fxpClose    = 4
+	fxpRead     = 5
+	fxpWrite    = 6
+	fxpLstat    = 7
+	fxpFstat    = 8
+	fxpSetstat  = 9
+	fxpFsetstat = 10
+	fxpOpendir  = 11
+	fxpReaddir  = 12
+	fxpRemove   = 13
+	fxpMkdir    = 14
+	fxpRmdir    = 15
+	fxpRealpath = 16
+	fxpStat     = 17
+	fxpRename   = 18
+	fxpReadlink = 19
+	fxpSymlink  = 20
+	fxpStatus   = 101
+	fxpHandle   = 102
+	fxpData     = 103
+	fxpName     = 104
+	fxpAttrs    = 105
+	fxpExtended = 200
+)
+
+// status codes.
+const (
+	fxOK               = 0
+	fxEOF              = 1
+	fxNoSuchFile       = 2
+	fxPermissionDenied = 3
+	fxFailure          = 4
+	fxBadMessage       = 5
+	fxOpUnsupported    = 8
+)
+
+// attribute flags.
+const (
+	attrSize        = 0x00000001
+	attrUIDGID      = 0x00000002
+	attrPermissions = 0x00000004
+	attrACModTime   = 0x00000008
+	attrExtended    = 0x80000000
+)
+
+// open flags.
+const (
+	fxfRead   = 0x00000001
+	fxfWrite  = 0x00000002
+	fxfAppend = 0x00000004
+	fxfCreat  = 0x00000008
+	fxfTrunc  = 0x00000010
+	fxfExcl   = 0x00000020
+)
+
+// unix file type bits used in the permissions attribute.
+const (
+	modeDir     = 0o040000
+	modeRegular = 0o100000
+	modeSymlink = 0o120000
+)
+
+// maxPacketSize bounds the packets accepted from clients. OpenSSH limits
+// them to 256KiB as well.
+const maxPacketSize = 256 * 1024
+
+var errShortPacket = errors.New("short packet")
+
+// readPacket reads a single packet, returning its type and payload.
+func readPacket(r io.Reader) (byte, []byte, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, nil, err //nolint:wrapcheck
+	}
+	n := binary.BigEndian.Uint32(hdr[:])
+	if n == 0 || n > maxPacketSize {
+		return 0, nil, fmt.Errorf("invalid packet length: %d", n)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return 0, nil, err //nolint:wrapcheck
+	}
+	return b[0], b[1:], nil
+}
+
+// buffer decodes the fields of a packet payload.
+type buffer struct {
+	b   []byte
+	err error
+}
+
+func (b *buffer) uint32() uint32 {
+	if b.err != nil {
+		return 0
+	}
+	if len(b.b) < 4 {
+		b.err = errShortPacket
+		return 0
+	}
+	v := binary.BigEndian.Uint32(b.b)
+	b.b = b.b[4:]
+	return v
+}
+
+func (b *buffer) uint64() uint64 {
+	hi := b.uint32()
+	lo := b.uint32()
+	return uint64(hi)<<32 | uint64(lo)
+}
+
+func (b *buffer) bytes() []byte {
+	n := b.uint32()
+	if b.err != nil {
+		return nil
+	}
+	if uint32(len(b.b)) < n {
+		b.err = errShortPacket
+		return nil
+	}
+	v := b.b[:n]
+	b.b = b.b[n:]
+	return v
+}
+
+func (b *buffer) string() string {
+	return string(b.bytes())
+}
+
+// attrs decodes a file attributes structure.
+func (b *buffer) attrs() Attrs {
+	var a Attrs
+	a.Flags = b.uint32()
+	if a.Flags&attrSize != 0 {
+		a.Size = b.uint64()
+	}
+	if a.Flags&attrUIDGID != 0 {
+		a.UID = b.uint32()
+		a.GID = b.uint32()
+	}
+	if a.Flags&attrPermissions != 0 {
+		a.Permissions = b.uint32()
+	}
+	if a.Flags&attrACModTime != 0 {
+		a.Atime = b.uint32()
+		a.Mtime = b.uint32()
+	}
+	if a.Flags&attrExtended != 0 {
+		n := b.uint32()
+		for i := uint32(0); i < n && b.err == nil; i++ {
+			_ = b.string()
+			_ = b.string()
+		}
+	}
+	return a
+}
+
+// encoder builds a packet.
+type encoder []byte
+
+func newPacket(typ byte, id uint32) *encoder {
+	e := encoder(make([]byte, 4, 64))
+	e.byte(typ)
+	e.uint32(id)
+	return &e
+}
+
+func (e *encoder) byte(v byte) {
+	*e = append(*e, v)
+}
+
+func (e *encoder) uint32(v uint32) {
+	*e = binary.BigEndian.AppendUint32(*e, v)
+}
+
+func (e *encoder) uint64(v uint64) {
+	*e = binary.BigEndian.AppendUint64(*e, v)
+}
+
+func (e *encoder) bytes(v []byte) {
+	e.uint32(uint32(len(v))) //nolint:gosec
+	*e = append(*e, v...)
+}
+
+func (e *encoder) string(v string) {
+	e.bytes([]byte(v))
+}
+
+func (e *encoder) attrs(a Attrs) {
+	e.uint32(a.Flags)
+	if a.Flags&attrSize != 0 {
+		e.uint64(a.Size)
+	}
+	if a.Flags&attrUIDGID != 0 {
+		e.uint32(a.UID)
+		e.uint32(a.GID)
+	}
+	if a.Flags&attrPermissions != 0 {
+		e.uint32(a.Permissions)
+	}
+	if a.Flags&attrACModTime != 0 {
+		e.uint32(a.Atime)
+		e.uint32(a.Mtime)
+	}
+}
+
+// finish sets the packet length and returns the encoded packet.
+func (e *encoder) finish() []byte {
+	b := *e
+	binary.BigEndian.PutUint32(b, uint32(len(b)-4)) //nolint:gosec
+	return b
+}
+
+// Attrs are the file attributes exchanged with the client.
+type Attrs struct {
+	Flags       uint32
+	Size        uint64
+	UID         uint32
+	GID         uint32
+	Permissions uint32
+	Atime       uint32
+	Mtime       uint32
+}
+
+// HasSize reports whether the size attribute is set.
+func (a Attrs) HasSize() bool { return a.Flags&attrSize != 0 }
+
+// HasMode reports whether the permissions attribute is set.
+func (a Attrs) HasMode() bool { return a.Flags&attrPermissions != 0 }
+
+// HasTimes reports whether the access and modification times are set.
+func (a Attrs) HasTimes() bool { return a.Flags&attrACModTime != 0 }
+
+// Mode returns the permissions attribute as a fs.FileMode.
+func (a Attrs) Mode() fs.FileMode {
+	m := fs.FileMode(a.Permissions & 0o777)
+	switch a.Permissions & 0o170000 {
+	case modeDir:
+		m |= fs.ModeDir
+	case modeSymlink:
+		m |= fs.ModeSymlink
+	}
+	return m
+}
+
+// AccessTime returns the access time attribute.
+func (a Attrs) AccessTime() time.Time { return time.Unix(int64(a.Atime), 0) }
+
+// ModTime returns the modification time attribute.
+func (a Attrs) ModTime() time.Time { return time.Unix(int64(a.Mtime), 0) }
+
+// fileInfoAttrs converts a fs.FileInfo to Attrs.
+func fileInfoAttrs(info fs.FileInfo) Attrs {
+	perm := uint32(info.Mode().Perm())
+	switch {
+	case info.IsDir():
+		perm |= modeDir
+	case info.Mode()&fs.ModeSymlink != 0:
+		perm |= modeSymlink
+	default:
+		perm |= modeRegular
+	}
+	mtime := uint32(info.ModTime().Unix()) //nolint:gosec
+	return Attrs{
+		Flags:       attrSize | attrPermissions | attrACModTime,
+		Size:        uint64(info.Size()), //nolint:gosec
+		Permissions: perm,
+		Atime:       mtime,
+		Mtime:       mtime,
+	}
+}
+
+// longName formats the given file like `ls -l` does, which is what clients
+// display in long listings.
+func longName(info fs.FileInfo) string {
+	mode := info.Mode().String()
+	if info.Mode()&fs.ModeSymlink != 0 {
+		mode = "l" + mode[1:]
+	}
+	date := info.ModTime().Format("Jan _2 15:04")
+	if time.Since(info.ModTime()) > 180*24*time.Hour {
+		date = info.ModTime().Format("Jan _2  2006")
+	}
+	return fmt.Sprintf("%s 1 0 0 %8d %s %s", mode, info.Size(), date, info.Name())
+}
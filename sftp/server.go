@@ -0,0 +1,395 @@
+package sftp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strconv"
+
+	"charm.land/ssh"
+)
+
+// protocolVersion is the SFTP protocol version implemented by the server.
+const protocolVersion = 3
+
+// readdirBatch is how many entries are sent per READDIR response.
+const readdirBatch = 100
+
+// maxReadSize bounds the data sent in a single READ response.
+const maxReadSize = 32 * 1024
+
+// ErrUnsupported is returned for operations the handler doesn't support.
+var ErrUnsupported = errors.New("operation unsupported")
+
+type dirHandle struct {
+	path    string
+	entries []fs.FileInfo
+}
+
+type fileHandle struct {
+	path string
+	r    io.ReaderAt
+	w    io.WriterAt
+	c    io.Closer
+}
+
+type server struct {
+	s       ssh.Session
+	h       Handler
+	handles map[string]any
+	next    uint64
+}
+
+func newServer(s ssh.Session, h Handler) *server {
+	return &server{
+		s:       s,
+		h:       h,
+		handles: map[string]any{},
+	}
+}
+
+func (srv *server) serve() error {
+	defer srv.closeAll()
+
+	typ, payload, err := readPacket(srv.s)
+	if err != nil {
+		return fmt.Errorf("failed to read init: %w", err)
+	}
+	if typ != fxpInit {
+		return fmt.Errorf("unexpected packet type: %d", typ)
+	}
+	b := &buffer{b: payload}
+	if v := b.uint32(); v < protocolVersion {
+		return fmt.Errorf("unsupported protocol version: %d", v)
+	}
+	// the version packet has no request id, so we build it by hand.
+	e := encoder(make([]byte, 4, 9))
+	e.byte(fxpVersion)
+	e.uint32(protocolVersion)
+	if _, err := srv.s.Write(e.finish()); err != nil {
+		return fmt.Errorf("failed to write version: %w", err)
+	}
+
+	for {
+		typ, payload, err := readPacket(srv.s)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("failed to read packet: %w", err)
+		}
+		b := &buffer{b: payload}
+		id := b.uint32()
+		if b.err != nil {
+			return fmt.Errorf("failed to read packet: %w", b.err)
+		}
+		if _, err := srv.s.Write(srv.handle(typ, id, b)); err != nil {
+			return fmt.Errorf("failed to write packet: %w", err)
+		}
+	}
+}
+
+func (srv *server) closeAll() {
+	for _, h := range srv.handles {
+		if f, ok := h.(*fileHandle); ok {
+			_ = f.c.Close()
+		}
+	}
+}
+
+// handle handles a single request, returning the encoded response.
+func (srv *server) handle(typ byte, id uint32, b *buffer) []byte {
+	switch typ {
+	case fxpOpen:
+		p, pflags, attrs := srv.path(b), b.uint32(), b.attrs()
+		if b.err != nil {
+			return badMessage(id)
+		}
+		return srv.open(id, p, pflags, attrs)
+	case fxpClose:
+		handle := b.string()
+		if b.err != nil {
+			return badMessage(id)
+		}
+		return srv.close(id, handle)
+	case fxpRead:
+		handle, offset, length := b.string(), b.uint64(), b.uint32()
+		if b.err != nil {
+			return badMessage(id)
+		}
+		return srv.read(id, handle, offset, length)
+	case fxpWrite:
+		handle, offset, data := b.string(), b.uint64(), b.bytes()
+		if b.err != nil {
+			return badMessage(id)
+		}
+		return srv.write(id, handle, offset, data)
+	case fxpStat, fxpLstat:
+		p := srv.path(b)
+		if b.err != nil {
+			return badMessage(id)
+		}
+		return srv.stat(id, p)
+	case fxpFstat:
+		handle := b.string()
+		if b.err != nil {
+			return badMessage(id)
+		}
+		p, ok := srv.handlePath(handle)
+		if !ok {
+			return status(id, fxFailure, "invalid handle")
+		}
+		return srv.stat(id, p)
+	case fxpSetstat:
+		p, attrs := srv.path(b), b.attrs()
+		if b.err != nil {
+			return badMessage(id)
+		}
+		return srv.setstat(id, p, attrs)
+	case fxpFsetstat:
+		handle, attrs := b.string(), b.attrs()
+		if b.err != nil {
+			return badMessage(id)
+		}
+		p, ok := srv.handlePath(handle)
+		if !ok {
+			return status(id, fxFailure, "invalid handle")
+		}
+		return srv.setstat(id, p, attrs)
+	case fxpOpendir:
+		p := srv.path(b)
+		if b.err != nil {
+			return badMessage(id)
+		}
+		return srv.opendir(id, p)
+	case fxpReaddir:
+		handle := b.string()
+		if b.err != nil {
+			return badMessage(id)
+		}
+		return srv.readdir(id, handle)
+	case fxpRemove, fxpRmdir:
+		p := srv.path(b)
+		if b.err != nil {
+			return badMessage(id)
+		}
+		return errStatus(id, srv.h.Remove(srv.s, p))
+	case fxpMkdir:
+		p, attrs := srv.path(b), b.attrs()
+		if b.err != nil {
+			return badMessage(id)
+		}
+		mode := fs.FileMode(0o755)
+		if attrs.HasMode() {
+			mode = attrs.Mode().Perm()
+		}
+		return errStatus(id, srv.h.Mkdir(srv.s, p, mode))
+	case fxpRealpath:
+		p := srv.path(b)
+		if b.err != nil {
+			return badMessage(id)
+		}
+		e := newPacket(fxpName, id)
+		e.uint32(1)
+		e.string(p)
+		e.string(p)
+		e.attrs(Attrs{})
+		return e.finish()
+	case fxpRename:
+		from, to := srv.path(b), srv.path(b)
+		if b.err != nil {
+			return badMessage(id)
+		}
+		return errStatus(id, srv.h.Rename(srv.s, from, to))
+	default:
+		// readlink, symlink and extended requests.
+		return status(id, fxOpUnsupported, ErrUnsupported.Error())
+	}
+}
+
+// path reads a path from the buffer and makes it absolute.
+func (srv *server) path(b *buffer) string {
+	return path.Clean("/" + b.string())
+}
+
+func (srv *server) handlePath(handle string) (string, bool) {
+	switch h := srv.handles[handle].(type) {
+	case *fileHandle:
+		return h.path, true
+	case *dirHandle:
+		return h.path, true
+	default:
+		return "", false
+	}
+}
+
+func (srv *server) newHandle(v any) []byte {
+	srv.next++
+	handle := strconv.FormatUint(srv.next, 10)
+	srv.handles[handle] = v
+	return []byte(handle)
+}
+
+func (srv *server) open(id uint32, p string, pflags uint32, attrs Attrs) []byte {
+	if pflags&(fxfWrite|fxfAppend|fxfCreat|fxfTrunc) == 0 {
+		f, err := srv.h.Open(srv.s, p)
+		if err != nil {
+			return errStatus(id, err)
+		}
+		e := newPacket(fxpHandle, id)
+		e.bytes(srv.newHandle(&fileHandle{path: p, r: f, c: f}))
+		return e.finish()
+	}
+
+	flag := os.O_WRONLY
+	if pflags&fxfRead != 0 {
+		flag = os.O_RDWR
+	}
+	if pflags&fxfAppend != 0 {
+		flag |= os.O_APPEND
+	}
+	if pflags&fxfCreat != 0 {
+		flag |= os.O_CREATE
+	}
+	if pflags&fxfTrunc != 0 {
+		flag |= os.O_TRUNC
+	}
+	if pflags&fxfExcl != 0 {
+		flag |= os.O_EXCL
+	}
+	mode := fs.FileMode(0o644)
+	if attrs.HasMode() {
+		mode = attrs.Mode().Perm()
+	}
+	f, err := srv.h.Write(srv.s, p, flag, mode)
+	if err != nil {
+		return errStatus(id, err)
+	}
+	fh := &fileHandle{path: p, w: f, c: f}
+	if r, ok := f.(io.ReaderAt); ok && pflags&fxfRead != 0 {
+		fh.r = r
+	}
+	e := newPacket(fxpHandle, id)
+	e.bytes(srv.newHandle(fh))
+	return e.finish()
+}
+
+func (srv *server) close(id uint32, handle string) []byte {
+	h, ok := srv.handles[handle]
+	if !ok {
+		return status(id, fxFailure, "invalid handle")
+	}
+	delete(srv.handles, handle)
+	if f, ok := h.(*fileHandle); ok {
+		return errStatus(id, f.c.Close())
+	}
+	return status(id, fxOK, "")
+}
+
+func (srv *server) read(id uint32, handle string, offset uint64, length uint32) []byte {
+	f, ok := srv.handles[handle].(*fileHandle)
+	if !ok || f.r == nil {
+		return status(id, fxFailure, "invalid handle")
+	}
+	length = min(length, maxReadSize)
+	buf := make([]byte, length)
+	n, err := f.r.ReadAt(buf, int64(offset)) //nolint:gosec
+	if n == 0 {
+		if err == nil || errors.Is(err, io.EOF) {
+			return status(id, fxEOF, "EOF")
+		}
+		return errStatus(id, err)
+	}
+	e := newPacket(fxpData, id)
+	e.bytes(buf[:n])
+	return e.finish()
+}
+
+func (srv *server) write(id uint32, handle string, offset uint64, data []byte) []byte {
+	f, ok := srv.handles[handle].(*fileHandle)
+	if !ok || f.w == nil {
+		return status(id, fxFailure, "invalid handle")
+	}
+	_, err := f.w.WriteAt(data, int64(offset)) //nolint:gosec
+	return errStatus(id, err)
+}
+
+func (srv *server) stat(id uint32, p string) []byte {
+	info, err := srv.h.Stat(srv.s, p)
+	if err != nil {
+		return errStatus(id, err)
+	}
+	e := newPacket(fxpAttrs, id)
+	e.attrs(fileInfoAttrs(info))
+	return e.finish()
+}
+
+func (srv *server) setstat(id uint32, p string, attrs Attrs) []byte {
+	h, ok := srv.h.(SetStatHandler)
+	if !ok {
+		return status(id, fxOpUnsupported, ErrUnsupported.Error())
+	}
+	return errStatus(id, h.SetStat(srv.s, p, attrs))
+}
+
+func (srv *server) opendir(id uint32, p string) []byte {
+	entries, err := srv.h.ReadDir(srv.s, p)
+	if err != nil {
+		return errStatus(id, err)
+	}
+	e := newPacket(fxpHandle, id)
+	e.bytes(srv.newHandle(&dirHandle{path: p, entries: entries}))
+	return e.finish()
+}
+
+func (srv *server) readdir(id uint32, handle string) []byte {
+	d, ok := srv.handles[handle].(*dirHandle)
+	if !ok {
+		return status(id, fxFailure, "invalid handle")
+	}
+	if len(d.entries) == 0 {
+		return status(id, fxEOF, "EOF")
+	}
+	batch := d.entries[:min(len(d.entries), readdirBatch)]
+	d.entries = d.entries[len(batch):]
+
+	e := newPacket(fxpName, id)
+	e.uint32(uint32(len(batch))) //nolint:gosec
+	for _, info := range batch {
+		e.string(info.Name())
+		e.string(longName(info))
+		e.attrs(fileInfoAttrs(info))
+	}
+	return e.finish()
+}
+
+func status(id uint32, code uint32, msg string) []byte {
+	e := newPacket(fxpStatus, id)
+	e.uint32(code)
+	e.string(msg)
+	e.string("")
+	return e.finish()
+}
+
+func badMessage(id uint32) []byte {
+	return status(id, fxBadMessage, "bad message")
+}
+
+// errStatus returns a status response matching the given error.
+func errStatus(id uint32, err error) []byte {
+	switch {
+	case err == nil:
+		return status(id, fxOK, "")
+	case errors.Is(err, fs.ErrNotExist):
+		return status(id, fxNoSuchFile, err.Error())
+	case errors.Is(err, fs.ErrPermission):
+		return status(id, fxPermissionDenied, err.Error())
+	case errors.Is(err, ErrUnsupported):
+		return status(id, fxOpUnsupported, err.Error())
+	default:
+		return status(id, fxFailure, err.Error())
+	}
+}
@@ -0,0 +1,78 @@
+// Package sftp provides a SFTP subsystem for wish.
+package sftp
+
+import (
+	"io"
+	"io/fs"
+
+	"charm.land/ssh"
+	"charm.land/wish/v2"
+)
+
+// ReadFile is a file opened for reading.
+type ReadFile interface {
+	io.ReaderAt
+	io.Closer
+}
+
+// WriteFile is a file opened for writing. If it also implements io.ReaderAt,
+// clients may read from it as well.
+type WriteFile interface {
+	io.WriterAt
+	io.Closer
+}
+
+// Handler is a interface that can be implemented to handle SFTP requests.
+//
+// Paths are always absolute and slash-separated, with "/" being the root of
+// what is exposed to the client.
+type Handler interface {
+	// Stat returns information about the given path.
+	Stat(ssh.Session, string) (fs.FileInfo, error)
+
+	// ReadDir returns the entries of the given directory.
+	ReadDir(ssh.Session, string) ([]fs.FileInfo, error)
+
+	// Open opens the given file for reading.
+	Open(ssh.Session, string) (ReadFile, error)
+
+	// Write opens the given file for writing. The flags are the ones
+	// accepted by os.OpenFile.
+	Write(ssh.Session, string, int, fs.FileMode) (WriteFile, error)
+
+	// Rename renames the given path.
+	Rename(ssh.Session, string, string) error
+
+	// Remove removes the given file or empty directory.
+	Remove(ssh.Session, string) error
+
+	// Mkdir creates the given directory.
+	Mkdir(ssh.Session, string, fs.FileMode) error
+}
+
+// SetStatHandler can be implemented by a Handler to allow clients to change
+// file attributes, e.g. permissions and times when using `-p`.
+type SetStatHandler interface {
+	SetStat(ssh.Session, string, Attrs) error
+}
+
+// SubsystemName is the name of the SFTP subsystem.
+const SubsystemName = "sftp"
+
+// SubsystemHandler returns an ssh.SubsystemHandler serving SFTP with the
+// given Handler.
+func SubsystemHandler(h Handler) ssh.SubsystemHandler {
+	return func(s ssh.Session) {
+		if err := newServer(s, h).serve(); err != nil {
+			wish.Fatalln(s, err)
+			return
+		}
+		_ = s.Exit(0)
+	}
+}
+
+// Subsystem returns an ssh.Option that registers the SFTP subsystem with the
+// given Handler.
+func Subsystem(h Handler) ssh.Option {
+	return wish.WithSubsystem(SubsystemName, SubsystemHandler(h))
+}
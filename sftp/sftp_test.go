@@ -0,0 +1,195 @@
+package sftp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"charm.land/ssh"
+	"charm.land/wish/v2"
+	"charm.land/wish/v2/testsession"
+	"github.com/charmbracelet/keygen"
+	"github.com/matryer/is"
+)
+
+func TestSubsystem(t *testing.T) {
+	if _, err := exec.LookPath("sftp"); err != nil {
+		t.Skip("sftp not found in PATH")
+	}
+
+	is := is.New(t)
+	root := t.TempDir()
+	is.NoErr(os.WriteFile(filepath.Join(root, "a.txt"), []byte("a text file"), 0o644))
+	is.NoErr(os.MkdirAll(filepath.Join(root, "dir"), 0o755))
+	addr, pk := serve(t, NewFileSystemHandler(root))
+
+	local := t.TempDir()
+	is.NoErr(os.WriteFile(filepath.Join(local, "b.txt"), []byte("another text file"), 0o644))
+
+	out, err := runSFTP(t, addr, pk, local, []string{
+		"ls -l",
+		"get a.txt",
+		"put b.txt dir/b.txt",
+		"mkdir dir/sub",
+		"rename dir/b.txt dir/sub/c.txt",
+		"chmod 600 dir/sub/c.txt",
+		"put b.txt d.txt",
+		"rm d.txt",
+		"mkdir e",
+		"rmdir e",
+	})
+	is.NoErr(err)
+	is.True(strings.Contains(out, "a.txt"))
+
+	bts, err := os.ReadFile(filepath.Join(local, "a.txt"))
+	is.NoErr(err)
+	is.Equal("a text file", string(bts))
+
+	bts, err = os.ReadFile(filepath.Join(root, "dir/sub/c.txt"))
+	is.NoErr(err)
+	is.Equal("another text file", string(bts))
+
+	info, err := os.Stat(filepath.Join(root, "dir/sub/c.txt"))
+	is.NoErr(err)
+	is.Equal(os.FileMode(0o600), info.Mode().Perm())
+
+	_, err = os.Stat(filepath.Join(root, "d.txt"))
+	is.True(os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(root, "e"))
+	is.True(os.IsNotExist(err))
+
+	t.Run("scp", func(t *testing.T) {
+		is := is.New(t)
+		host, port, err := net.SplitHostPort(addr)
+		is.NoErr(err)
+		// scp uses the SFTP protocol by default since OpenSSH 9.0, -s forces
+		// it on older versions.
+		cmd := exec.Command(
+			"scp", "-s",
+			"-P", port,
+			"-i", pk,
+			"-F", "/dev/null",
+			"-o", "UserKnownHostsFile=/dev/null",
+			"-o", "StrictHostKeyChecking=no",
+			filepath.Join(local, "b.txt"),
+			fmt.Sprintf("wish@%s:scp.txt", host),
+		)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Log("scp out:", string(out))
+		}
+		is.NoErr(err)
+
+		bts, err := os.ReadFile(filepath.Join(root, "scp.txt"))
+		is.NoErr(err)
+		is.Equal("another text file", string(bts))
+	})
+
+	t.Run("traversal", func(t *testing.T) {
+		is := is.New(t)
+		secret := filepath.Join(filepath.Dir(root), "secret.txt")
+		is.NoErr(os.WriteFile(secret, []byte("secret"), 0o644))
+		t.Cleanup(func() { _ = os.Remove(secret) })
+
+		_, err := runSFTP(t, addr, pk, local, []string{"get ../secret.txt"})
+		is.True(err != nil)
+		_, err = os.Stat(filepath.Join(local, "secret.txt"))
+		is.True(os.IsNotExist(err))
+	})
+}
+
+func TestUnsupported(t *testing.T) {
+	is := is.New(t)
+	srv := &ssh.Server{}
+	is.NoErr(Subsystem(NewFileSystemHandler(t.TempDir()))(srv))
+	sess, err := testsession.NewClientSession(t, testsession.Listen(t, srv), nil)
+	is.NoErr(err)
+
+	w, err := sess.StdinPipe()
+	is.NoErr(err)
+	r, err := sess.StdoutPipe()
+	is.NoErr(err)
+	is.NoErr(sess.RequestSubsystem(SubsystemName))
+
+	_, err = w.Write(rawPacket(fxpInit, 0, 0, 0, 3))
+	is.NoErr(err)
+	typ, _, err := readPacket(r)
+	is.NoErr(err)
+	is.Equal(byte(fxpVersion), typ)
+
+	var payload []byte
+	payload = binary.BigEndian.AppendUint32(payload, 1)
+	payload = binary.BigEndian.AppendUint32(payload, uint32(len("foo")))
+	payload = append(payload, "foo"...)
+	_, err = w.Write(rawPacket(fxpReadlink, payload...))
+	is.NoErr(err)
+	typ, resp, err := readPacket(r)
+	is.NoErr(err)
+	is.Equal(byte(fxpStatus), typ)
+	b := &buffer{b: resp}
+	is.Equal(uint32(1), b.uint32())
+	is.Equal(uint32(fxOpUnsupported), b.uint32())
+}
+
+func rawPacket(typ byte, payload ...byte) []byte {
+	var b bytes.Buffer
+	_ = binary.Write(&b, binary.BigEndian, uint32(len(payload)+1))
+	b.WriteByte(typ)
+	b.Write(payload)
+	return b.Bytes()
+}
+
+func serve(tb testing.TB, h Handler) (string, string) {
+	tb.Helper()
+	is := is.New(tb)
+
+	pk := filepath.Join(tb.TempDir(), "id_ed25519")
+	_, err := keygen.New(pk, keygen.WithKeyType(keygen.Ed25519), keygen.WithWrite())
+	is.NoErr(err)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	is.NoErr(err)
+	srv, err := wish.NewServer(
+		wish.WithHostKeyPath(filepath.Join(tb.TempDir(), "id_ed25519")),
+		wish.WithPublicKeyAuth(func(ssh.Context, ssh.PublicKey) bool { return true }),
+		Subsystem(h),
+	)
+	is.NoErr(err)
+	go func() { _ = srv.Serve(l) }()
+	tb.Cleanup(func() { _ = srv.Close() })
+	return l.Addr().String(), pk
+}
+
+func runSFTP(tb testing.TB, addr, pk, cwd string, cmds []string) (string, error) {
+	tb.Helper()
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err //nolint:wrapcheck
+	}
+	batch := filepath.Join(tb.TempDir(), "batch")
+	if err := os.WriteFile(batch, []byte(strings.Join(cmds, "\n")+"\n"), 0o644); err != nil {
+		return "", err //nolint:wrapcheck
+	}
+	cmd := exec.Command(
+		"sftp",
+		"-b", batch,
+		"-P", port,
+		"-i", pk,
+		"-F", "/dev/null",
+		"-o", "UserKnownHostsFile=/dev/null",
+		"-o", "StrictHostKeyChecking=no",
+		fmt.Sprintf("wish@%s", host),
+	)
+	cmd.Dir = cwd
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		tb.Log("sftp out:", string(out))
+	}
+	return string(out), err //nolint:wrapcheck
+}
@@ -0,0 +1,197 @@
+// Package throttle provides a middleware that limits the bandwidth used by
+// sessions.
+//
+// It applies to anything reading from or writing to the session, so it works
+// for scp, git, and plain command sessions alike.
+package throttle
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"charm.land/ssh"
+	"charm.land/wish/v2"
+	"golang.org/x/time/rate"
+)
+
+// Rates are the maximum number of bytes per second allowed in each direction
+// (from and to the client). Zero values mean no limit.
+type Rates struct {
+	// Global is shared by all sessions.
+	Global int
+
+	// PerUser is shared by all sessions of the same user.
+	PerUser int
+
+	// PerSession applies to each session individually.
+	PerSession int
+}
+
+// Middleware provides a middleware that throttles the session input and
+// output to the given rates.
+//
+// The middleware should be placed before the middlewares that should be
+// throttled, i.e. last in the wish.WithMiddleware list.
+func Middleware(rates Rates) wish.Middleware {
+	global := newBuckets(rates.Global)
+	users := &userBuckets{rate: rates.PerUser, buckets: map[string]*userBucket{}}
+	return func(sh ssh.Handler) ssh.Handler {
+		return func(s ssh.Session) {
+			user := users.acquire(s.User())
+			defer users.release(s.User())
+
+			sess := newBuckets(rates.PerSession)
+			sh(&session{
+				Session: s,
+				in:      []*rate.Limiter{sess.in, user.in, global.in},
+				out:     []*rate.Limiter{sess.out, user.out, global.out},
+			})
+		}
+	}
+}
+
+// buckets are the token buckets of a given scope, one per direction. They are
+// nil if there is no limit.
+type buckets struct {
+	in, out *rate.Limiter
+}
+
+func newBuckets(bytesPerSec int) *buckets {
+	if bytesPerSec <= 0 {
+		return &buckets{}
+	}
+	return &buckets{
+		in:  rate.NewLimiter(rate.Limit(bytesPerSec), bytesPerSec),
+		out: rate.NewLimiter(rate.Limit(bytesPerSec), bytesPerSec),
+	}
+}
+
+// userBuckets keeps the buckets of the users with active sessions.
+type userBuckets struct {
+	mu      sync.Mutex
+	rate    int
+	buckets map[string]*userBucket
+}
+
+type userBucket struct {
+	*buckets
+	sessions int
+}
+
+func (u *userBuckets) acquire(user string) *buckets {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	b, ok := u.buckets[user]
+	if !ok {
+		b = &userBucket{buckets: newBuckets(u.rate)}
+		u.buckets[user] = b
+	}
+	b.sessions++
+	return b.buckets
+}
+
+func (u *userBuckets) release(user string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	b, ok := u.buckets[user]
+	if !ok {
+		return
+	}
+	b.sessions--
+	if b.sessions <= 0 {
+		delete(u.buckets, user)
+	}
+}
+
+// session is a throttled ssh.Session.
+type session struct {
+	ssh.Session
+	in, out []*rate.Limiter
+}
+
+func (s *session) Read(b []byte) (int, error) {
+	return read(s.Context(), s.Session, s.in, b)
+}
+
+func (s *session) Write(b []byte) (int, error) {
+	return write(s.Context(), s.Session, s.out, b)
+}
+
+func (s *session) Stderr() io.ReadWriter {
+	return &stderr{s.Context(), s.Session.Stderr(), s.in, s.out}
+}
+
+type stderr struct {
+	ctx     context.Context
+	rw      io.ReadWriter
+	in, out []*rate.Limiter
+}
+
+func (s *stderr) Read(b []byte) (int, error)  { return read(s.ctx, s.rw, s.in, b) }
+func (s *stderr) Write(b []byte) (int, error) { return write(s.ctx, s.rw, s.out, b) }
+
+// read reads at most a burst worth of bytes, and then waits for them to be
+// allowed, which slows the client down once the ssh window is full.
+func read(ctx context.Context, r io.Reader, limiters []*rate.Limiter, b []byte) (int, error) {
+	if n := maxChunk(limiters); n > 0 && len(b) > n {
+		b = b[:n]
+	}
+	n, err := r.Read(b)
+	if n > 0 {
+		if werr := wait(ctx, limiters, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err //nolint:wrapcheck
+}
+
+// write writes b in chunks of at most a burst worth of bytes, waiting for
+// each of them to be allowed.
+func write(ctx context.Context, w io.Writer, limiters []*rate.Limiter, b []byte) (int, error) {
+	chunk := maxChunk(limiters)
+	if chunk <= 0 {
+		return w.Write(b) //nolint:wrapcheck
+	}
+	var written int
+	for len(b) > 0 {
+		p := b[:min(chunk, len(b))]
+		if err := wait(ctx, limiters, len(p)); err != nil {
+			return written, err
+		}
+		n, err := w.Write(p)
+		written += n
+		if err != nil {
+			return written, err //nolint:wrapcheck
+		}
+		b = b[n:]
+	}
+	return written, nil
+}
+
+// maxChunk returns the smallest burst of the given limiters, or 0 if there
+// are no limits.
+func maxChunk(limiters []*rate.Limiter) int {
+	var chunk int
+	for _, l := range limiters {
+		if l == nil {
+			continue
+		}
+		if chunk == 0 || l.Burst() < chunk {
+			chunk = l.Burst()
+		}
+	}
+	return chunk
+}
+
+func wait(ctx context.Context, limiters []*rate.Limiter, n int) error {
+	for _, l := range limiters {
+		if l == nil {
+			continue
+		}
+		if err := l.WaitN(ctx, n); err != nil {
+			return err //nolint:wrapcheck
+		}
+	}
+	return nil
+}
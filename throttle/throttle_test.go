@@ -0,0 +1,123 @@
+package throttle
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"charm.land/ssh"
+	"charm.land/wish/v2/testsession"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestThrottleWrite(t *testing.T) {
+	s := &ssh.Server{
+		Handler: Middleware(Rates{PerSession: 1000})(func(s ssh.Session) {
+			_, _ = s.Write(bytes.Repeat([]byte("a"), 2500))
+		}),
+	}
+
+	start := time.Now()
+	out, err := testsession.New(t, s, nil).Output("")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(out) != 2500 {
+		t.Fatalf("expected 2500 bytes, got %d", len(out))
+	}
+	// the first 1000 bytes are the burst, the rest takes 1.5 seconds.
+	if d := time.Since(start); d < 1400*time.Millisecond {
+		t.Fatalf("expected throttled output, took %s", d)
+	}
+}
+
+func TestThrottleRead(t *testing.T) {
+	s := &ssh.Server{
+		Handler: Middleware(Rates{PerUser: 1000})(func(s ssh.Session) {
+			n, _ := io.Copy(io.Discard, s)
+			_, _ = s.Write([]byte{byte(n / 100)})
+		}),
+	}
+
+	sess := testsession.New(t, s, nil)
+	sess.Stdin = strings.NewReader(strings.Repeat("a", 2000))
+	start := time.Now()
+	out, err := sess.Output("")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(out) != 1 || out[0] != 20 {
+		t.Fatalf("expected 2000 bytes read, got %v", out)
+	}
+	if d := time.Since(start); d < 900*time.Millisecond {
+		t.Fatalf("expected throttled input, took %s", d)
+	}
+}
+
+func TestThrottleGlobal(t *testing.T) {
+	s := &ssh.Server{
+		Handler: Middleware(Rates{Global: 1000, PerSession: 100_000})(func(s ssh.Session) {
+			_, _ = s.Write(bytes.Repeat([]byte("a"), 1000))
+		}),
+	}
+	addr := testsession.Listen(t, s)
+
+	// the sessions share the global rate, so 3000 bytes take at least 2s.
+	start := time.Now()
+	var g errgroup.Group
+	for range 3 {
+		g.Go(func() error {
+			sess, err := testsession.NewClientSession(t, addr, nil)
+			if err != nil {
+				return err
+			}
+			_, err = sess.Output("")
+			return err
+		})
+	}
+	if err := g.Wait(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if d := time.Since(start); d < 1900*time.Millisecond {
+		t.Fatalf("expected throttled output, took %s", d)
+	}
+}
+
+func TestNoLimits(t *testing.T) {
+	s := &ssh.Server{
+		Handler: Middleware(Rates{})(func(s ssh.Session) {
+			_, _ = s.Write(bytes.Repeat([]byte("a"), 1_000_000))
+		}),
+	}
+
+	start := time.Now()
+	out, err := testsession.New(t, s, nil).Output("")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(out) != 1_000_000 {
+		t.Fatalf("expected 1000000 bytes, got %d", len(out))
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Fatalf("expected no throttling, took %s", d)
+	}
+}
+
+func TestUserBuckets(t *testing.T) {
+	u := &userBuckets{rate: 10, buckets: map[string]*userBucket{}}
+	a := u.acquire("a")
+	if b := u.acquire("a"); a != b {
+		t.Fatal("expected the same buckets for the same user")
+	}
+	if b := u.acquire("b"); a == b {
+		t.Fatal("expected different buckets for different users")
+	}
+	u.release("a")
+	u.release("a")
+	u.release("b")
+	if len(u.buckets) != 0 {
+		t.Fatalf("expected buckets to be released, got %d", len(u.buckets))
+	}
+}
@@ -0,0 +1,193 @@
+package wish
+
+import (
+	"crypto/hmac"
+	"crypto/sha1" //nolint:gosec
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"charm.land/ssh"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// TOTP is a keyboard-interactive challenge asking for a time-based one-time
+// password (RFC 6238), as generated by authenticator apps from a secret
+// shared with the server, using HMAC-SHA1.
+//
+// Each code is accepted once per user, and only the users with a secret may
+// pass, although the others are asked for a code as well, to not tell them
+// apart.
+type TOTP struct {
+	// Secret returns the secret of the user, false if they have none.
+	Secret func(ctx ssh.Context) ([]byte, bool)
+
+	// Digits is the number of digits of the codes. Defaults to 6.
+	Digits int
+
+	// Period is how long a code is valid. Defaults to 30 seconds. Periods
+	// under a second are invalid, and no code is accepted then.
+	Period time.Duration
+
+	// Skew is the number of periods before and after the current one whose
+	// codes are accepted, to allow for clock drift. Zero only accepts the
+	// codes of the current period; NewTOTP sets it to 1.
+	Skew int
+
+	// Prompt is the question asked to the client. Defaults to
+	// "Verification code: ".
+	Prompt string
+
+	// Now returns the current time, e.g. a fixed one in tests. Defaults to
+	// time.Now.
+	Now func() time.Time
+
+	mu   sync.Mutex
+	used map[string]int64
+}
+
+// NewTOTP returns a TOTP challenge with the default settings, using the given
+// secrets.
+func NewTOTP(secret func(ctx ssh.Context) ([]byte, bool)) *TOTP {
+	return &TOTP{
+		Secret: secret,
+		Digits: 6,
+		Period: 30 * time.Second,
+		Skew:   1,
+		Prompt: "Verification code: ",
+		Now:    time.Now,
+		used:   map[string]int64{},
+	}
+}
+
+// ParseTOTPSecret parses a secret in the base32 encoding shown by
+// authenticator apps, ignoring spaces, case and padding.
+func ParseTOTPSecret(s string) ([]byte, error) {
+	s = strings.ToUpper(strings.Join(strings.Fields(s), ""))
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return nil, fmt.Errorf("parse totp secret: %w", err)
+	}
+	return secret, nil
+}
+
+// totpParams are the settings of a TOTP, with the defaults applied.
+type totpParams struct {
+	digits int
+	period int64
+	prompt string
+	now    func() time.Time
+}
+
+// params returns the settings of the challenge, using the defaults for the
+// unset ones. It reports false if the Period is invalid.
+func (t *TOTP) params() (totpParams, bool) {
+	p := totpParams{digits: t.Digits, period: int64(t.Period / time.Second), prompt: t.Prompt, now: t.Now}
+	if p.digits <= 0 {
+		p.digits = 6
+	}
+	if t.Period == 0 {
+		p.period = 30
+	}
+	if p.prompt == "" {
+		p.prompt = "Verification code: "
+	}
+	if p.now == nil {
+		p.now = time.Now
+	}
+	return p, p.period > 0
+}
+
+// Code returns the code of the secret at the given time, or an empty string
+// if the Period is invalid.
+func (t *TOTP) Code(secret []byte, at time.Time) string {
+	p, ok := t.params()
+	if !ok {
+		return ""
+	}
+	return p.code(secret, p.counter(at))
+}
+
+// counter returns the period of the given time since the Unix epoch.
+func (p totpParams) counter(at time.Time) int64 {
+	return at.Unix() / p.period
+}
+
+// code returns the HOTP (RFC 4226) value of the counter.
+func (p totpParams) code(secret []byte, counter int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	mod := uint64(1)
+	for range min(p.digits, 10) {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", p.digits, uint64(value)%mod)
+}
+
+// Validate reports whether the code is valid for the secret at the given
+// time. Unlike the challenge, it doesn't prevent codes from being reused.
+func (t *TOTP) Validate(secret []byte, code string, at time.Time) bool {
+	p, ok := t.params()
+	if !ok {
+		return false
+	}
+	_, ok = t.validate(p, secret, code, at)
+	return ok
+}
+
+// validate returns the counter of the code if it's valid.
+func (t *TOTP) validate(p totpParams, secret []byte, code string, at time.Time) (int64, bool) {
+	code = strings.TrimSpace(code)
+	if len(code) != p.digits {
+		return 0, false
+	}
+	counter := p.counter(at)
+	for i := -t.Skew; i <= t.Skew; i++ {
+		if subtle.ConstantTimeCompare([]byte(p.code(secret, counter+int64(i))), []byte(code)) == 1 {
+			return counter + int64(i), true
+		}
+	}
+	return 0, false
+}
+
+// KeyboardInteractiveHandler asks the client for a code, and reports whether
+// it's valid for the secret of the user and wasn't used before. It's an
+// ssh.KeyboardInteractiveHandler, see KeyboardInteractiveMethod.
+func (t *TOTP) KeyboardInteractiveHandler(ctx ssh.Context, challenger gossh.KeyboardInteractiveChallenge) bool {
+	p, ok := t.params()
+	if !ok {
+		return false
+	}
+	answers, err := challenger("", "", []string{p.prompt}, []bool{false})
+	if err != nil || len(answers) != 1 {
+		return false
+	}
+	secret, ok := t.Secret(ctx)
+	if !ok {
+		return false
+	}
+	counter, ok := t.validate(p, secret, answers[0], p.now())
+	if !ok {
+		return false
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if used, ok := t.used[ctx.User()]; ok && counter <= used {
+		return false
+	}
+	if t.used == nil {
+		t.used = map[string]int64{}
+	}
+	t.used[ctx.User()] = counter
+	return true
+}
@@ -0,0 +1,103 @@
+package wish
+
+import (
+	"testing"
+	"time"
+
+	"charm.land/ssh"
+)
+
+// rfcSecret is the SHA1 secret of the test vectors of RFC 6238.
+var rfcSecret = []byte("12345678901234567890")
+
+func TestTOTPCode(t *testing.T) {
+	totp := NewTOTP(nil)
+	totp.Digits = 8
+	for unix, code := range map[int64]string{
+		59:          "94287082",
+		1111111109:  "07081804",
+		1111111111:  "14050471",
+		1234567890:  "89005924",
+		2000000000:  "69279037",
+		20000000000: "65353130",
+	} {
+		requireEqual(t, code, totp.Code(rfcSecret, time.Unix(unix, 0)))
+	}
+	requireEqual(t, "050471", NewTOTP(nil).Code(rfcSecret, time.Unix(1111111111, 0)))
+}
+
+func TestTOTPValidate(t *testing.T) {
+	totp := NewTOTP(nil)
+	now := time.Unix(1111111111, 0)
+	requireEqual(t, true, totp.Validate(rfcSecret, totp.Code(rfcSecret, now), now))
+	requireEqual(t, true, totp.Validate(rfcSecret, " "+totp.Code(rfcSecret, now.Add(-30*time.Second)), now))
+	requireEqual(t, true, totp.Validate(rfcSecret, totp.Code(rfcSecret, now.Add(30*time.Second)), now))
+	requireEqual(t, false, totp.Validate(rfcSecret, totp.Code(rfcSecret, now.Add(-time.Minute)), now))
+	requireEqual(t, false, totp.Validate(rfcSecret, "", now))
+	requireEqual(t, false, totp.Validate([]byte("another secret"), totp.Code(rfcSecret, now), now))
+
+	totp.Skew = 0
+	requireEqual(t, false, totp.Validate(rfcSecret, totp.Code(rfcSecret, now.Add(30*time.Second)), now))
+}
+
+func TestTOTPKeyboardInteractive(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	totp := NewTOTP(func(ctx ssh.Context) ([]byte, bool) {
+		return rfcSecret, ctx.User() == "foo"
+	})
+	totp.Now = func() time.Time { return now }
+	answer := func(code string) func(string, string, []string, []bool) ([]string, error) {
+		return func(_, _ string, questions []string, _ []bool) ([]string, error) {
+			requireEqual(t, 1, len(questions))
+			return []string{code}, nil
+		}
+	}
+	ctx := func(user string) ssh.Context {
+		c := &testContext{}
+		c.SetValue(ssh.ContextKeyUser, user)
+		return c
+	}
+	code := totp.Code(rfcSecret, now)
+
+	requireEqual(t, false, totp.KeyboardInteractiveHandler(ctx("bar"), answer(code)))
+	requireEqual(t, false, totp.KeyboardInteractiveHandler(ctx("foo"), answer("000000")))
+	requireEqual(t, true, totp.KeyboardInteractiveHandler(ctx("foo"), answer(code)))
+
+	// codes can't be reused, nor older ones.
+	requireEqual(t, false, totp.KeyboardInteractiveHandler(ctx("foo"), answer(code)))
+	requireEqual(t, false, totp.KeyboardInteractiveHandler(ctx("foo"), answer(totp.Code(rfcSecret, now.Add(-30*time.Second)))))
+	requireEqual(t, true, totp.KeyboardInteractiveHandler(ctx("foo"), answer(totp.Code(rfcSecret, now.Add(30*time.Second)))))
+}
+
+func TestTOTPDefaults(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	totp := &TOTP{Secret: func(ssh.Context) ([]byte, bool) { return rfcSecret, true }}
+	code := totp.Code(rfcSecret, now)
+	requireEqual(t, 6, len(code))
+	requireEqual(t, NewTOTP(nil).Code(rfcSecret, now), code)
+	requireEqual(t, true, totp.Validate(rfcSecret, code, now))
+
+	totp.Now = func() time.Time { return now }
+	ctx := &testContext{}
+	ctx.SetValue(ssh.ContextKeyUser, "foo")
+	requireEqual(t, true, totp.KeyboardInteractiveHandler(ctx, func(_, _ string, questions []string, _ []bool) ([]string, error) {
+		requireEqual(t, NewTOTP(nil).Prompt, questions[0])
+		return []string{code}, nil
+	}))
+
+	totp.Period = time.Millisecond
+	requireEqual(t, "", totp.Code(rfcSecret, now))
+	requireEqual(t, false, totp.Validate(rfcSecret, code, now))
+}
+
+func TestParseTOTPSecret(t *testing.T) {
+	secret, err := ParseTOTPSecret("gezd gnbv gy3t qojq gezd gnbv gy3t qojq")
+	requireNoError(t, err)
+	requireEqual(t, string(rfcSecret), string(secret))
+	secret, err = ParseTOTPSecret("MFRGG===")
+	requireNoError(t, err)
+	requireEqual(t, "abc", string(secret))
+	if _, err := ParseTOTPSecret("not base32!"); err == nil {
+		t.Fatal("expected an error, got nil")
+	}
+}
@@ -0,0 +1,308 @@
+package wish
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"charm.land/ssh"
+	"golang.org/x/crypto/bcrypt"
+	gossh "golang.org/x/crypto/ssh"
+	"gopkg.in/yaml.v3"
+)
+
+// ErrUserNotFound is returned by UserStore implementations for unknown users.
+var ErrUserNotFound = errors.New("user not found")
+
+// User is a user of a UserStore.
+type User struct {
+	// Name is the user name, as given by the clients.
+	Name string
+
+	// Keys are the public keys allowed to authenticate the user.
+	Keys []ssh.PublicKey
+
+	// Groups are the groups, or roles, of the user.
+	Groups []string
+}
+
+// HasKey reports whether the key is one of the user's.
+func (u User) HasKey(key ssh.PublicKey) bool {
+	return slices.ContainsFunc(u.Keys, func(k ssh.PublicKey) bool {
+		return ssh.KeysEqual(key, k)
+	})
+}
+
+// InGroup reports whether the user is in the group.
+func (u User) InGroup(group string) bool {
+	return slices.Contains(u.Groups, group)
+}
+
+// UserStore is a directory of users, authenticating them and telling their
+// groups to the middlewares. See WithUserStore.
+type UserStore interface {
+	// LookupUser returns the user with the given name, or an error wrapping
+	// ErrUserNotFound.
+	LookupUser(name string) (User, error)
+
+	// VerifyPassword reports whether the password is the user's.
+	VerifyPassword(name, password string) bool
+}
+
+// UserRecord is a user as defined in a MemoryUserStore or a user file.
+type UserRecord struct {
+	// Name is the user name.
+	Name string `json:"name" yaml:"name"`
+
+	// PasswordHash is the bcrypt hash of the password of the user, e.g. as
+	// given by HashPassword or htpasswd -nB. Users without one can't use
+	// passwords.
+	PasswordHash string `json:"password_hash,omitempty" yaml:"password_hash,omitempty"`
+
+	// Keys are the public keys of the user, in the authorized_keys format.
+	Keys []string `json:"keys,omitempty" yaml:"keys,omitempty"`
+
+	// Groups are the groups of the user.
+	Groups []string `json:"groups,omitempty" yaml:"groups,omitempty"`
+}
+
+// HashPassword returns the bcrypt hash of the password, for UserRecord.
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("hash password: %w", err)
+	}
+	return string(hash), nil
+}
+
+type storedUser struct {
+	user User
+	hash []byte
+}
+
+// MemoryUserStore is a UserStore holding its users in memory. It's safe for
+// concurrent use.
+type MemoryUserStore struct {
+	mu    sync.RWMutex
+	users map[string]storedUser
+}
+
+// NewMemoryUserStore returns a MemoryUserStore with the given users.
+func NewMemoryUserStore(users ...UserRecord) (*MemoryUserStore, error) {
+	s := &MemoryUserStore{users: map[string]storedUser{}}
+	for _, u := range users {
+		if err := s.PutUser(u); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// PutUser adds the user, replacing any user with the same name.
+func (s *MemoryUserStore) PutUser(u UserRecord) error {
+	if u.Name == "" {
+		return errors.New("user without name")
+	}
+	stored := storedUser{
+		user: User{Name: u.Name, Groups: slices.Clone(u.Groups)},
+		hash: []byte(u.PasswordHash),
+	}
+	for _, line := range u.Keys {
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+		if err != nil {
+			return fmt.Errorf("user %s: parse key: %w", u.Name, err)
+		}
+		stored.user.Keys = append(stored.user.Keys, key)
+	}
+	if len(stored.hash) > 0 {
+		if _, err := bcrypt.Cost(stored.hash); err != nil {
+			return fmt.Errorf("user %s: invalid password hash: %w", u.Name, err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[u.Name] = stored
+	return nil
+}
+
+// DeleteUser removes the user, if any.
+func (s *MemoryUserStore) DeleteUser(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.users, name)
+}
+
+// LookupUser implements UserStore.
+func (s *MemoryUserStore) LookupUser(name string) (User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	stored, ok := s.users[name]
+	if !ok {
+		return User{}, fmt.Errorf("%w: %s", ErrUserNotFound, name)
+	}
+	return stored.user, nil
+}
+
+// dummyHash is compared to the passwords of unknown users, so they take as
+// long as the others to be denied.
+var dummyHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("dummy"), bcrypt.DefaultCost)
+	return hash
+})
+
+// VerifyPassword implements UserStore.
+func (s *MemoryUserStore) VerifyPassword(name, password string) bool {
+	s.mu.RLock()
+	stored, ok := s.users[name]
+	s.mu.RUnlock()
+	if !ok || len(stored.hash) == 0 {
+		_ = bcrypt.CompareHashAndPassword(dummyHash(), []byte(password))
+		return false
+	}
+	return bcrypt.CompareHashAndPassword(stored.hash, []byte(password)) == nil
+}
+
+// FileUserStore is a UserStore reading its users from a JSON or YAML file,
+// reloaded whenever it changes. The file holds a users list of UserRecord:
+//
+//	users:
+//	  - name: carlos
+//	    password_hash: $2a$10$...
+//	    keys:
+//	      - ssh-ed25519 AAAA...
+//	    groups: [admins]
+//
+// Files with the .yaml or .yml extension are parsed as YAML, the others as
+// JSON. While the file is invalid or can't be read, no users are found.
+type FileUserStore struct {
+	file fileCache[*MemoryUserStore]
+}
+
+// NewFileUserStore returns the FileUserStore of the given file, which must
+// exist and be valid.
+func NewFileUserStore(path string) (*FileUserStore, error) {
+	s := &FileUserStore{file: fileCache[*MemoryUserStore]{
+		path: path,
+		parse: func(bts []byte) (*MemoryUserStore, error) {
+			return parseUserFile(path, bts)
+		},
+	}}
+	if _, err := s.file.reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func parseUserFile(path string, bts []byte) (*MemoryUserStore, error) {
+	var file struct {
+		Users []UserRecord `json:"users" yaml:"users"`
+	}
+	var err error
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(bts, &file)
+	default:
+		err = json.Unmarshal(bts, &file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	s, err := NewMemoryUserStore(file.Users...)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// LookupUser implements UserStore.
+func (s *FileUserStore) LookupUser(name string) (User, error) {
+	users, err := s.file.get()
+	if err != nil {
+		return User{}, fmt.Errorf("%w: %s: %w", ErrUserNotFound, name, err)
+	}
+	return users.LookupUser(name)
+}
+
+// VerifyPassword implements UserStore.
+func (s *FileUserStore) VerifyPassword(name, password string) bool {
+	users, err := s.file.get()
+	if err != nil {
+		return false
+	}
+	return users.VerifyPassword(name, password)
+}
+
+// UserPublicKeyHandler returns the ssh.PublicKeyHandler allowing the keys of
+// the users of the store, e.g. for an AuthPolicy. The user is available to
+// the middlewares with UserFromContext.
+func UserPublicKeyHandler(store UserStore) ssh.PublicKeyHandler {
+	return func(ctx ssh.Context, key ssh.PublicKey) bool {
+		u, err := store.LookupUser(ctx.User())
+		if err != nil || !u.HasKey(key) {
+			return false
+		}
+		ctx.SetValue(contextKeyUser, u)
+		return true
+	}
+}
+
+// UserPasswordHandler returns the ssh.PasswordHandler allowing the passwords
+// of the users of the store, e.g. for an AuthPolicy. The user is available to
+// the middlewares with UserFromContext.
+func UserPasswordHandler(store UserStore) ssh.PasswordHandler {
+	return func(ctx ssh.Context, password string) bool {
+		if !store.VerifyPassword(ctx.User(), password) {
+			return false
+		}
+		u, err := store.LookupUser(ctx.User())
+		if err != nil {
+			return false
+		}
+		ctx.SetValue(contextKeyUser, u)
+		return true
+	}
+}
+
+// UserKeyboardInteractiveHandler returns the ssh.KeyboardInteractiveHandler
+// asking the users of the store for their passwords, as clients often only
+// prompt for passwords this way.
+func UserKeyboardInteractiveHandler(store UserStore) ssh.KeyboardInteractiveHandler {
+	password := UserPasswordHandler(store)
+	return func(ctx ssh.Context, challenger gossh.KeyboardInteractiveChallenge) bool {
+		answers, err := challenger("", "", []string{"Password: "}, []bool{false})
+		if err != nil || len(answers) != 1 {
+			return false
+		}
+		return password(ctx, answers[0])
+	}
+}
+
+// WithUserStore returns an ssh.Option authenticating the users of the store
+// with their public keys or their passwords. The user is available to the
+// middlewares with UserFromContext, e.g. to authorize it by its groups.
+func WithUserStore(store UserStore) ssh.Option {
+	return func(s *ssh.Server) error {
+		s.PublicKeyHandler = UserPublicKeyHandler(store)
+		s.PasswordHandler = UserPasswordHandler(store)
+		s.KeyboardInteractiveHandler = UserKeyboardInteractiveHandler(store)
+		return nil
+	}
+}
+
+var contextKeyUser = &contextKey{"user"}
+
+// UserFromContext returns the user of the UserStore that authenticated the
+// session, when using WithUserStore or its handlers.
+func UserFromContext(ctx ssh.Context) (User, bool) {
+	u, ok := ctx.Value(contextKeyUser).(User)
+	if !ok || u.Name != ctx.User() {
+		// set by a failed attempt with another user name.
+		return User{}, false
+	}
+	return u, true
+}
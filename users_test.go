@@ -0,0 +1,174 @@
+package wish
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"charm.land/ssh"
+	"charm.land/wish/v2/testsession"
+	"golang.org/x/crypto/bcrypt"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// testUsers returns foo, with the testdata/foo key, and bar, with the
+// password secret.
+func testUsers(tb testing.TB) []UserRecord {
+	tb.Helper()
+	// HashPassword is too slow for the tests.
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	requireNoError(tb, err)
+	return []UserRecord{
+		{Name: "foo", Keys: []string{string(getBytes(tb, "testdata/foo.pub"))}, Groups: []string{"admins", "devs"}},
+		{Name: "bar", PasswordHash: string(hash), Groups: []string{"devs"}},
+	}
+}
+
+func TestMemoryUserStore(t *testing.T) {
+	s, err := NewMemoryUserStore(testUsers(t)...)
+	requireNoError(t, err)
+
+	foo, err := s.LookupUser("foo")
+	requireNoError(t, err)
+	requireEqual(t, true, foo.HasKey(parseKey(t, string(getBytes(t, "testdata/foo.pub")))))
+	requireEqual(t, false, foo.HasKey(parseKey(t, k1)))
+	requireEqual(t, true, foo.InGroup("admins"))
+	requireEqual(t, false, foo.InGroup("ops"))
+
+	requireEqual(t, true, s.VerifyPassword("bar", "secret"))
+	requireEqual(t, false, s.VerifyPassword("bar", "nope"))
+	requireEqual(t, false, s.VerifyPassword("foo", ""))
+	requireEqual(t, false, s.VerifyPassword("baz", "secret"))
+
+	s.DeleteUser("foo")
+	_, err = s.LookupUser("foo")
+	requireEqual(t, true, errors.Is(err, ErrUserNotFound))
+
+	for _, u := range []UserRecord{
+		{},
+		{Name: "baz", Keys: []string{"not a key"}},
+		{Name: "baz", PasswordHash: "secret"},
+	} {
+		if err := s.PutUser(u); err == nil {
+			t.Fatalf("expected an error for %+v", u)
+		}
+	}
+}
+
+func TestFileUserStore(t *testing.T) {
+	users := testUsers(t)
+	yaml := fmt.Sprintf("users:\n  - name: foo\n    keys:\n      - %s\n    groups: [admins]\n  - name: bar\n    password_hash: %q\n",
+		strings.TrimSpace(users[0].Keys[0]), users[1].PasswordHash)
+	json := fmt.Sprintf(`{"users": [{"name": "bar", "password_hash": %q, "groups": ["devs"]}]}`, users[1].PasswordHash)
+
+	t.Run("yaml", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "users.yaml")
+		requireNoError(t, os.WriteFile(path, []byte(yaml), 0o600))
+		s, err := NewFileUserStore(path)
+		requireNoError(t, err)
+		foo, err := s.LookupUser("foo")
+		requireNoError(t, err)
+		requireEqual(t, 1, len(foo.Keys))
+		requireEqual(t, true, foo.InGroup("admins"))
+		requireEqual(t, true, s.VerifyPassword("bar", "secret"))
+	})
+
+	t.Run("json", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "users.json")
+		requireNoError(t, os.WriteFile(path, []byte(json), 0o600))
+		s, err := NewFileUserStore(path)
+		requireNoError(t, err)
+		bar, err := s.LookupUser("bar")
+		requireNoError(t, err)
+		requireEqual(t, true, bar.InGroup("devs"))
+		_, err = s.LookupUser("foo")
+		requireEqual(t, true, errors.Is(err, ErrUserNotFound))
+	})
+
+	t.Run("reloads", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "users.json")
+		requireNoError(t, os.WriteFile(path, []byte(json), 0o600))
+		s, err := NewFileUserStore(path)
+		requireNoError(t, err)
+		requireEqual(t, true, s.VerifyPassword("bar", "secret"))
+
+		requireNoError(t, os.WriteFile(path, []byte(`{"users": [{"name": "baz"}]}`), 0o600))
+		_, err = s.LookupUser("baz")
+		requireNoError(t, err)
+		requireEqual(t, false, s.VerifyPassword("bar", "secret"))
+
+		// no users while it's invalid.
+		requireNoError(t, os.WriteFile(path, []byte(`{"users": [{"name": ""}]}`), 0o600))
+		_, err = s.LookupUser("baz")
+		requireEqual(t, true, errors.Is(err, ErrUserNotFound))
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "users.yml")
+		requireNoError(t, os.WriteFile(path, []byte("users: {"), 0o600))
+		if _, err := NewFileUserStore(path); err == nil {
+			t.Fatal("expected an error, got nil")
+		}
+		if _, err := NewFileUserStore("testdata/nope.json"); err == nil {
+			t.Fatal("expected an error, got nil")
+		}
+	})
+}
+
+func TestWithUserStore(t *testing.T) {
+	store, err := NewMemoryUserStore(testUsers(t)...)
+	requireNoError(t, err)
+	s := &ssh.Server{
+		Handler: func(s ssh.Session) {
+			u, ok := UserFromContext(s.Context())
+			fmt.Fprintf(s, "%v %s %s", ok, u.Name, strings.Join(u.Groups, ","))
+		},
+	}
+	requireNoError(t, WithUserStore(store)(s))
+	addr := testsession.Listen(t, s)
+
+	signer, err := gossh.ParsePrivateKey(getBytes(t, "testdata/foo"))
+	requireNoError(t, err)
+	for name, tt := range map[string]struct {
+		user     string
+		auth     gossh.AuthMethod
+		expected string
+	}{
+		"public key":           {"foo", gossh.PublicKeys(signer), "true foo admins,devs"},
+		"password":             {"bar", gossh.Password("secret"), "true bar devs"},
+		"keyboard-interactive": {"bar", answer("secret"), "true bar devs"},
+		"wrong user":           {"bar", gossh.PublicKeys(signer), ""},
+		"wrong password":       {"bar", gossh.Password("nope"), ""},
+		"unknown user":         {"baz", gossh.Password("secret"), ""},
+	} {
+		t.Run(name, func(t *testing.T) {
+			out, err := authLogin(t, addr, tt.user, tt.auth)
+			if tt.expected == "" {
+				requireNoAuth(t, err)
+				return
+			}
+			requireNoError(t, err)
+			requireEqual(t, tt.expected, out)
+		})
+	}
+}
+
+func TestUserFromContext(t *testing.T) {
+	ctx := &testContext{}
+	ctx.SetValue(ssh.ContextKeyUser, "foo")
+	_, ok := UserFromContext(ctx)
+	requireEqual(t, false, ok)
+
+	// set by an attempt as another user.
+	ctx.SetValue(contextKeyUser, User{Name: "bar"})
+	_, ok = UserFromContext(ctx)
+	requireEqual(t, false, ok)
+
+	ctx.SetValue(contextKeyUser, User{Name: "foo"})
+	u, ok := UserFromContext(ctx)
+	requireEqual(t, true, ok)
+	requireEqual(t, "foo", u.Name)
+}